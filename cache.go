@@ -1,6 +1,7 @@
 package gcpjwt
 
 import (
+	"crypto"
 	"time"
 
 	cache "github.com/patrickmn/go-cache"
@@ -25,10 +26,40 @@ func getCertsFromCache(serviceAccount string) (certificates, bool) {
 	return certs, ok
 }
 
-func updateCache(serviceaccount string, certs certificates, expires time.Time) {
+func getKeysFromCache(url string) (publicKeys, bool) {
+	keysObj, found := certsCache.Get(url)
+	if !found {
+		return nil, false
+	}
+
+	keys, ok := keysObj.(publicKeys)
+	return keys, ok
+}
+
+func getKMSPublicKeyFromCache(keyPath string) (crypto.PublicKey, bool) {
+	return certsCache.Get(kmsCacheKey(keyPath))
+}
+
+// cacheKMSPublicKey stores the public key of a KMS key version, which never changes
+func cacheKMSPublicKey(keyPath string, publicKey crypto.PublicKey) {
+	certsCache.Set(kmsCacheKey(keyPath), publicKey, cache.NoExpiration)
+}
+
+func kmsCacheKey(keyPath string) string {
+	return "kms:" + keyPath
+}
+
+// updateCache stores certificates by service account or publicKeys by url
+func updateCache(key string, keys interface{}, expires time.Time) {
 	exp := time.Until(expires)
-	certsCache.Set(serviceaccount, certs, exp)
+	certsCache.Set(key, keys, exp)
 
 	// Let's try and evict expired items
 	certsCache.DeleteExpired()
 }
+
+// cachedUntil returns when the cached certificates or keys expire, zero if they are not cached
+func cachedUntil(key string) time.Time {
+	_, expires, _ := certsCache.GetWithExpiration(key)
+	return expires
+}
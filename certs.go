@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rsa"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -20,6 +21,12 @@ const (
 type certificates map[string]*rsa.PublicKey
 
 func getCertificates(ctx context.Context, config *IAMConfig) (certificates, error) {
+	if local, err := useLocalMode(config.LocalMode); err != nil {
+		return nil, err
+	} else if local {
+		return localCertificates(config.ServiceAccount)
+	}
+
 	if config.EnableCache {
 		if certsResp, ok := getCertsFromCache(config.ServiceAccount); ok {
 			return certsResp, nil
@@ -32,18 +39,7 @@ func getCertificates(ctx context.Context, config *IAMConfig) (certificates, erro
 		client = getDefaultClient(ctx)
 	}
 
-	req, err := http.NewRequest(http.MethodGet, certificateURL+config.ServiceAccount, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	b, err := ioutil.ReadAll(resp.Body)
+	b, expires, err := fetchKeys(client, certificateURL+config.ServiceAccount, config.CacheExpiration)
 	if err != nil {
 		return nil, err
 	}
@@ -54,11 +50,6 @@ func getCertificates(ctx context.Context, config *IAMConfig) (certificates, erro
 		return nil, err
 	}
 
-	_, expires, err := cachecontrol.CachableResponse(req, resp, cachecontrol.Options{PrivateCache: true})
-	if err != nil && config.CacheExpiration > 0 {
-		expires = time.Now().Add(config.CacheExpiration)
-	}
-
 	certs := make(certificates)
 	for key, cert := range certsRaw {
 		rsaKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(cert))
@@ -74,3 +65,33 @@ func getCertificates(ctx context.Context, config *IAMConfig) (certificates, erro
 
 	return certs, nil
 }
+
+// fetchKeys gets the keys document at the url, returning its body along with its expiration time according to the
+// Cache-Control headers of the response, or cacheExpiration from now if not cacheable (zero time if 0).
+func fetchKeys(client *http.Client, url string, cacheExpiration time.Duration) ([]byte, time.Time, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("gcpjwt: unexpected status %d fetching keys from `%s`", resp.StatusCode, url)
+	}
+
+	_, expires, err := cachecontrol.CachableResponse(req, resp, cachecontrol.Options{PrivateCache: true})
+	if err != nil && cacheExpiration > 0 {
+		expires = time.Now().Add(cacheExpiration)
+	}
+
+	return b, expires, nil
+}
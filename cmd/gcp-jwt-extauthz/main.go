@@ -0,0 +1,120 @@
+// Command gcp-jwt-extauthz runs the extauthz Envoy external authorization gRPC service, verifying tokens signed by
+// the IAM API for trusted service accounts or by trusted Cloud KMS key versions.
+//
+//	gcp-jwt-extauthz -listen :9001 -audience https://service.example.com \
+//		-service-accounts caller@project.iam.gserviceaccount.com
+//
+// The trusted service accounts, key paths and audiences can be read instead from a gcpjwt.VerifierConfig JSON file
+// with -config, which is reloaded when it changes. The audiences of the config are the expected audiences, -audience
+// only applies without -config.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+	"github.com/csmadhu/gcp-jwt-go/extauthz"
+)
+
+func main() {
+	var (
+		listen          = flag.String("listen", ":9001", "address to serve the gRPC service on")
+		configFile      = flag.String("config", "", "gcpjwt.VerifierConfig JSON file, reloaded when it changes")
+		reloadInterval  = flag.Duration("reload-interval", 30*time.Second, "interval between checks of the -config file")
+		serviceAccounts = flag.String("service-accounts", "", "comma separated trusted service accounts")
+		kmsKeyPaths     = flag.String("kms-key-paths", "", "comma separated trusted KMS key versions")
+		audience        = flag.String("audience", "", "comma separated expected audiences without -config, https:// + the request's host if blank")
+		issuers         = flag.String("issuers", "", "comma separated accepted issuers, any if blank")
+		cacheExpiration = flag.Duration("cache-expiration", time.Hour, "certificate cache expiration")
+		signJwt         = flag.Bool("signjwt", false, "verify RS256 tokens signed with the IAM signJwt API")
+		claimHeaders    = flag.String("claim-headers", "sub=x-jwt-sub,iss=x-jwt-iss", "comma separated claim=header forwarded to the upstream")
+	)
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if *signJwt {
+		gcpjwt.SigningMethodIAMJWT.Override()
+	}
+
+	config := &gcpjwt.VerifierConfig{
+		ServiceAccounts: split(*serviceAccounts),
+		KMSKeyPaths:     split(*kmsKeyPaths),
+		EnableCache:     true,
+		CacheExpiration: *cacheExpiration,
+		Audiences:       split(*audience),
+	}
+	if *configFile != "" {
+		if *audience != "" {
+			log.Fatal("-audience cannot be used with -config, set the audiences in the config")
+		}
+		var err error
+		if config, err = gcpjwt.LoadVerifierConfigFile(*configFile); err != nil {
+			log.Fatalf("could not load %s: %v", *configFile, err)
+		}
+	}
+
+	verifier, err := gcpjwt.NewVerifier(ctx, config)
+	if err != nil {
+		log.Fatalf("could not create verifier: %v", err)
+	}
+	if *configFile != "" {
+		go verifier.WatchFile(ctx, *configFile, *reloadInterval, func(err error) {
+			log.Printf("could not reload %s: %v", *configFile, err)
+		})
+	}
+
+	// The audiences are read from the verifier's config so they are reloaded with it
+	server := extauthz.NewServer(verifier.Keyfunc(), "", split(*issuers)...)
+	server.Audiences = func() []string { return verifier.Config().Audiences }
+	server.ClaimHeaders = make(map[string]string)
+	for _, mapping := range split(*claimHeaders) {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("invalid claim header mapping `%s`", mapping)
+		}
+		server.ClaimHeaders[parts[0]] = parts[1]
+	}
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("could not listen on %s: %v", *listen, err)
+	}
+
+	grpcServer := grpc.NewServer()
+	server.Register(grpcServer)
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+
+	go func() {
+		<-ctx.Done()
+		grpcServer.GracefulStop()
+	}()
+
+	log.Printf("serving ext_authz on %s", lis.Addr())
+	if err := grpcServer.Serve(lis); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func split(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
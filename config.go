@@ -67,6 +67,13 @@ type IAMConfig struct {
 	// Used for verify requests
 	Client *http.Client
 
+	// RateLimit is an optional client-side limit applied to signing calls made for the ServiceAccount
+	RateLimit *RateLimit
+
+	// LocalMode signs and verifies with a deterministic local key for the ServiceAccount instead of the IAM API, for
+	// local development only. See EnableLocalMode.
+	LocalMode bool
+
 	lastKeyID string
 
 	sync.RWMutex
@@ -90,6 +97,19 @@ type KMSConfig struct {
 
 	// KMSClient to use for calls to the API. If nil, a standard one will be initiated
 	KMSClient *kms.KeyManagementClient
+
+	// RateLimit is an optional client-side limit applied to signing calls made for the KeyPath
+	RateLimit *RateLimit
+
+	// Attestation, if set, refuses to sign or verify unless the key version is HSM protected with a valid attestation
+	Attestation *KMSAttestation
+
+	// Preflight, if set, refuses to sign with key versions that are not enabled or do not match the signing method
+	Preflight *KMSPreflight
+
+	// LocalMode signs and verifies with a deterministic local key for the KeyPath instead of Cloud KMS, for local
+	// development only. See EnableLocalMode.
+	LocalMode bool
 }
 
 // KeyID will return the SHA1 hash of the configured KeyPath. Helper function for adding the kid header to your token.
@@ -0,0 +1,322 @@
+package cose
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Only the subset of CBOR (RFC 8949) needed for COSE_Sign1 and CWT is supported. Values decode to int64 (or uint64
+// when they do not fit), []byte, string, []interface{}, map[interface{}]interface{}, bool, nil, float64 and tag.
+
+const (
+	majorUnsigned = 0
+	majorNegative = 1
+	majorBytes    = 2
+	majorText     = 3
+	majorArray    = 4
+	majorMap      = 5
+	majorTag      = 6
+	majorSimple   = 7
+
+	maxDepth = 16
+)
+
+var errMalformed = errors.New("gcpjwt/cose: malformed cbor data")
+
+type tag struct {
+	number  uint64
+	content interface{}
+}
+
+func encodeCBOR(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := writeCBOR(buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major<<5 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major<<5 | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major<<5 | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func writeCBOR(buf *bytes.Buffer, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteByte(majorSimple<<5 | 22)
+	case bool:
+		if val {
+			buf.WriteByte(majorSimple<<5 | 21)
+		} else {
+			buf.WriteByte(majorSimple<<5 | 20)
+		}
+	case int:
+		writeInt(buf, int64(val))
+	case int64:
+		writeInt(buf, val)
+	case uint64:
+		writeHead(buf, majorUnsigned, val)
+	case []byte:
+		writeHead(buf, majorBytes, uint64(len(val)))
+		buf.Write(val)
+	case string:
+		writeHead(buf, majorText, uint64(len(val)))
+		buf.WriteString(val)
+	case []interface{}:
+		writeHead(buf, majorArray, uint64(len(val)))
+		for _, item := range val {
+			if err := writeCBOR(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		return writeMap(buf, val)
+	case tag:
+		writeHead(buf, majorTag, val.number)
+		return writeCBOR(buf, val.content)
+	default:
+		return fmt.Errorf("gcpjwt/cose: unsupported cbor type %T", v)
+	}
+	return nil
+}
+
+func writeInt(buf *bytes.Buffer, n int64) {
+	if n < 0 {
+		writeHead(buf, majorNegative, uint64(-1-n))
+		return
+	}
+	writeHead(buf, majorUnsigned, uint64(n))
+}
+
+// writeMap writes the map with its keys sorted by their encoding, as required for deterministic encoding
+func writeMap(buf *bytes.Buffer, m map[interface{}]interface{}) error {
+	type entry struct {
+		key   []byte
+		value interface{}
+	}
+	entries := make([]entry, 0, len(m))
+	for k, v := range m {
+		key, err := encodeCBOR(k)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{key, v})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	writeHead(buf, majorMap, uint64(len(entries)))
+	for _, e := range entries {
+		buf.Write(e.key)
+		if err := writeCBOR(buf, e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeCBOR(data []byte) (interface{}, error) {
+	d := &decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(d.data) {
+		return nil, errMalformed
+	}
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+	off  int
+}
+
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, errMalformed
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+func (d *decoder) head() (byte, byte, uint64, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info == 24:
+		b, err := d.next(1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		n = uint64(b[0])
+	case info == 25:
+		b, err := d.next(2)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b))
+	case info == 26:
+		b, err := d.next(4)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		n = uint64(binary.BigEndian.Uint32(b))
+	case info == 27:
+		b, err := d.next(8)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		n = binary.BigEndian.Uint64(b)
+	default:
+		// Indefinite lengths are not supported
+		return 0, 0, 0, errMalformed
+	}
+
+	return major, info, n, nil
+}
+
+func (d *decoder) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errMalformed
+	}
+
+	major, info, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case majorUnsigned:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case majorNegative:
+		if n > math.MaxInt64 {
+			return nil, errMalformed
+		}
+		return -1 - int64(n), nil
+	case majorBytes:
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case majorText:
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case majorArray:
+		if n > uint64(len(d.data)) {
+			return nil, errMalformed
+		}
+		arr := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, item)
+		}
+		return arr, nil
+	case majorMap:
+		if n > uint64(len(d.data)) {
+			return nil, errMalformed
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, uint64, string:
+			default:
+				return nil, fmt.Errorf("gcpjwt/cose: unsupported cbor map key type %T", k)
+			}
+			// Duplicate keys could be read differently by other decoders, e.g. the alg of a protected header
+			if _, ok := m[k]; ok {
+				return nil, fmt.Errorf("gcpjwt/cose: duplicate cbor map key %v", k)
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case majorTag:
+		content, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return tag{n, content}, nil
+	}
+
+	// majorSimple
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return float64(float16(uint16(n))), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(n))), nil
+	case 27:
+		return math.Float64frombits(n), nil
+	}
+	return nil, errMalformed
+}
+
+func float16(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		// Subnormal numbers
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+}
@@ -0,0 +1,230 @@
+package cose
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+func newTestSigner(t *testing.T, alg int64) (*Signer, crypto.PublicKey) {
+	var privateKey interface{}
+	var publicKey crypto.PublicKey
+	switch alg {
+	case AlgorithmES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privateKey, publicKey = key, &key.PublicKey
+	case AlgorithmEdDSA:
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privateKey, publicKey = key, pub
+	case AlgorithmPS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privateKey, publicKey = key, &key.PublicKey
+	}
+
+	return &Signer{
+		alg:   alg,
+		keyID: []byte("test"),
+		sign: func(toBeSigned []byte) ([]byte, error) {
+			sig, err := algorithms[alg].Sign(string(toBeSigned), privateKey)
+			if err != nil {
+				return nil, err
+			}
+			return jwt.DecodeSegment(sig)
+		},
+	}, publicKey
+}
+
+func Test_cbor(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"Small", int64(10), "0a"},
+		{"Negative", int64(-500), "3901f3"},
+		{"Large", uint64(1) << 63, "1b8000000000000000"},
+		{"Text", "IETF", "6449455446"},
+		{"Bytes", []byte{1, 2, 3, 4}, "4401020304"},
+		{"Array", []interface{}{int64(1), []interface{}{int64(2), int64(3)}}, "8201820203"},
+		{"Map", map[interface{}]interface{}{int64(10): int64(1), int64(-1): int64(2), "a": false}, "a30a0120026161f4"},
+		{"Tag", tag{1, int64(1363896240)}, "c11a514b67b0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodeCBOR(tt.value)
+			if err != nil {
+				t.Fatalf("encodeCBOR() error = %v", err)
+			}
+			if hex.EncodeToString(got) != tt.want {
+				t.Errorf("encodeCBOR() = %x, want %s", got, tt.want)
+			}
+
+			decoded, err := decodeCBOR(got)
+			if err != nil {
+				t.Fatalf("decodeCBOR() error = %v", err)
+			}
+			reencoded, _ := encodeCBOR(decoded)
+			if !bytes.Equal(reencoded, got) {
+				t.Errorf("decodeCBOR() round trip = %x, want %x", reencoded, got)
+			}
+		})
+	}
+
+	for _, malformed := range []string{"", "18", "5f", "9a00000005", "4401", "a201010102"} {
+		b, _ := hex.DecodeString(malformed)
+		if _, err := decodeCBOR(b); err == nil {
+			t.Errorf("decodeCBOR(%s) expected error", malformed)
+		}
+	}
+}
+
+func Test_claimsFromCBOR(t *testing.T) {
+	// https://www.rfc-editor.org/rfc/rfc8392#appendix-A.1
+	b, _ := hex.DecodeString("a70175636f61703a2f2f61732e6578616d706c652e636f6d02656572696b77037818636f61703a2f2f6c69" +
+		"6768742e6578616d706c652e636f6d041a5612aeb0051a5610d9f0061a5610d9f007420b71")
+	v, err := decodeCBOR(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := claimsFromCBOR(v.(map[interface{}]interface{}))
+	if err != nil {
+		t.Fatalf("claimsFromCBOR() error = %v", err)
+	}
+	want := jwt.StandardClaims{
+		Issuer:    "coap://as.example.com",
+		Subject:   "erikw",
+		Audience:  "coap://light.example.com",
+		ExpiresAt: 1444064944,
+		NotBefore: 1443944944,
+		IssuedAt:  1443944944,
+		Id:        "\x0b\x71",
+	}
+	if *claims != want {
+		t.Errorf("claimsFromCBOR() = %+v, want %+v", claims, want)
+	}
+
+	encoded, err := encodeCBOR(claimsToCBOR(claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, b) {
+		t.Errorf("claimsToCBOR() = %x, want %x", encoded, b)
+	}
+}
+
+func TestSign1(t *testing.T) {
+	for _, alg := range []int64{AlgorithmES256, AlgorithmEdDSA, AlgorithmPS256} {
+		signer, publicKey := newTestSigner(t, alg)
+		keyFunc := func(gotAlg int64, kid []byte) (crypto.PublicKey, error) {
+			if gotAlg != alg || string(kid) != "test" {
+				t.Errorf("Keyfunc() unexpected alg %d or kid %s", gotAlg, kid)
+			}
+			return publicKey, nil
+		}
+
+		msg, err := signer.Sign1([]byte("payload"), []byte("aad"))
+		if err != nil {
+			t.Fatalf("Signer.Sign1() error = %v", err)
+		}
+		if msg[0] != 0xd2 {
+			t.Errorf("Signer.Sign1() expected a tagged COSE_Sign1 message, got %x", msg[0])
+		}
+
+		payload, err := VerifySign1(msg, keyFunc, []byte("aad"))
+		if err != nil {
+			t.Errorf("VerifySign1() error = %v", err)
+		} else if string(payload) != "payload" {
+			t.Errorf("VerifySign1() = %s, want payload", payload)
+		}
+
+		if _, err := VerifySign1(msg, keyFunc, []byte("other")); err == nil {
+			t.Errorf("VerifySign1() expected error with different external aad")
+		}
+	}
+}
+
+func TestKMSVerifyKeyfunc(t *testing.T) {
+	ctx := context.Background()
+	config := &gcpjwt.KMSConfig{KeyPath: "projects/p/locations/l/keyRings/r/cryptoKeys/cose/cryptoKeyVersions/1", LocalMode: true}
+
+	signer, err := NewKMSSigner(ctx, gcpjwt.SigningMethodKMSES256, config)
+	if err != nil {
+		t.Fatalf("NewKMSSigner() error = %v", err)
+	}
+	msg, err := signer.Sign1([]byte("payload"), nil)
+	if err != nil {
+		t.Fatalf("Signer.Sign1() error = %v", err)
+	}
+
+	keyFunc, err := KMSVerifyKeyfunc(ctx, gcpjwt.SigningMethodKMSES256, config)
+	if err != nil {
+		t.Fatalf("KMSVerifyKeyfunc() error = %v", err)
+	}
+	if _, err := VerifySign1(msg, keyFunc, nil); err != nil {
+		t.Errorf("VerifySign1() error = %v", err)
+	}
+	if _, err := keyFunc(AlgorithmES384, []byte(config.KeyID())); err == nil {
+		t.Errorf("Keyfunc() expected error for an algorithm other than the key's")
+	}
+	if _, err := keyFunc(AlgorithmES256, []byte("other")); err == nil {
+		t.Errorf("Keyfunc() expected error for an unknown kid")
+	}
+}
+
+func TestCWT(t *testing.T) {
+	signer, publicKey := newTestSigner(t, AlgorithmES256)
+	keyFunc := func(alg int64, kid []byte) (crypto.PublicKey, error) {
+		return publicKey, nil
+	}
+
+	now := time.Now().Unix()
+	tests := []struct {
+		name    string
+		claims  jwt.StandardClaims
+		wantErr bool
+	}{
+		{"Valid", jwt.StandardClaims{Issuer: "iss", Subject: "device", Audience: "aud", IssuedAt: now, ExpiresAt: now + 60, Id: "id"}, false},
+		{"Expired", jwt.StandardClaims{Issuer: "iss", ExpiresAt: now - 60}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := signer.SignCWT(&tt.claims, nil)
+			if err != nil {
+				t.Fatalf("Signer.SignCWT() error = %v", err)
+			}
+			if token[0] != 0xd8 || token[1] != tagCWT {
+				t.Errorf("Signer.SignCWT() expected a tagged CWT, got %x", token[:2])
+			}
+
+			claims, err := VerifyCWT(token, keyFunc, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyCWT() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if *claims != tt.claims {
+				t.Errorf("VerifyCWT() = %+v, want %+v", claims, tt.claims)
+			}
+		})
+	}
+}
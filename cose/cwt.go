@@ -0,0 +1,157 @@
+package cose
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	tagCWT = 61
+
+	claimIssuer    = 1
+	claimSubject   = 2
+	claimAudience  = 3
+	claimExpiresAt = 4
+	claimNotBefore = 5
+	claimIssuedAt  = 6
+	claimID        = 7
+)
+
+var (
+	// ErrInvalidClaims is returned when the payload of a CWT is not a valid claims set
+	ErrInvalidClaims = errors.New("gcpjwt/cose: invalid CWT claims set")
+)
+
+// SignCWT returns a tagged CWT (a COSE_Sign1 message wrapped in the CWT tag) with the claims mapped from their
+// jwt.StandardClaims equivalents. The claim `jti` is mapped to `cti` as bytes.
+// https://www.rfc-editor.org/rfc/rfc8392#section-3
+func (s *Signer) SignCWT(claims *jwt.StandardClaims, externalAAD []byte) ([]byte, error) {
+	payload, err := encodeCBOR(claimsToCBOR(claims))
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := s.sign1(payload, externalAAD)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeCBOR(tag{tagCWT, msg})
+}
+
+// VerifyCWT verifies a CWT, tagged or not, with the key returned by the keyFunc and validates its time based claims,
+// returning them as their jwt.StandardClaims equivalents.
+func VerifyCWT(token []byte, keyFunc Keyfunc, externalAAD []byte) (*jwt.StandardClaims, error) {
+	v, err := decodeCBOR(token)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := v.(tag); ok && t.number == tagCWT {
+		v = t.content
+	}
+
+	payload, err := verifySign1(v, keyFunc, externalAAD)
+	if err != nil {
+		return nil, err
+	}
+
+	claimsSet, err := decodeCBOR(payload)
+	if err != nil {
+		return nil, err
+	}
+	claimsMap, ok := claimsSet.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidClaims
+	}
+
+	claims, err := claimsFromCBOR(claimsMap)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, claims.Valid()
+}
+
+func claimsToCBOR(claims *jwt.StandardClaims) map[interface{}]interface{} {
+	m := make(map[interface{}]interface{})
+	setString := func(key int64, value string) {
+		if value != "" {
+			m[key] = value
+		}
+	}
+	setTime := func(key int64, value int64) {
+		if value != 0 {
+			m[key] = value
+		}
+	}
+
+	setString(claimIssuer, claims.Issuer)
+	setString(claimSubject, claims.Subject)
+	setString(claimAudience, claims.Audience)
+	setTime(claimExpiresAt, claims.ExpiresAt)
+	setTime(claimNotBefore, claims.NotBefore)
+	setTime(claimIssuedAt, claims.IssuedAt)
+	if claims.Id != "" {
+		m[int64(claimID)] = []byte(claims.Id)
+	}
+
+	return m
+}
+
+func claimsFromCBOR(m map[interface{}]interface{}) (*jwt.StandardClaims, error) {
+	claims := &jwt.StandardClaims{}
+	for k, v := range m {
+		key, ok := k.(int64)
+		if !ok {
+			// Private claims are ignored
+			continue
+		}
+
+		var err error
+		switch key {
+		case claimIssuer:
+			claims.Issuer, err = cborString(v)
+		case claimSubject:
+			claims.Subject, err = cborString(v)
+		case claimAudience:
+			claims.Audience, err = cborString(v)
+		case claimExpiresAt:
+			claims.ExpiresAt, err = cborTime(v)
+		case claimNotBefore:
+			claims.NotBefore, err = cborTime(v)
+		case claimIssuedAt:
+			claims.IssuedAt, err = cborTime(v)
+		case claimID:
+			id, ok := v.([]byte)
+			if !ok {
+				err = ErrInvalidClaims
+			}
+			claims.Id = string(id)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return claims, nil
+}
+
+func cborString(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", ErrInvalidClaims
+	}
+	return s, nil
+}
+
+// cborTime reads a NumericDate, which may be an integer or a floating point number
+func cborTime(v interface{}) (int64, error) {
+	switch t := v.(type) {
+	case int64:
+		return t, nil
+	case float64:
+		return int64(t), nil
+	}
+	return 0, ErrInvalidClaims
+}
@@ -0,0 +1,231 @@
+// Package cose produces and verifies COSE_Sign1 messages (RFC 9052) and CBOR Web Tokens (RFC 8392) with the Cloud KMS
+// signing methods, so the same KMS keys can serve JWT and CWT consumers.
+package cose
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+// COSE algorithm identifiers
+// https://www.iana.org/assignments/cose/cose.xhtml#algorithms
+const (
+	AlgorithmES256 int64 = -7
+	AlgorithmES384 int64 = -35
+	AlgorithmEdDSA int64 = -8
+	AlgorithmPS256 int64 = -37
+	AlgorithmRS256 int64 = -257
+)
+
+const (
+	tagSign1 = 18
+
+	headerAlgorithm = 1
+	headerKeyID     = 4
+)
+
+var (
+	// ErrInvalidMessage is returned when a message is not a well formed COSE_Sign1 message
+	ErrInvalidMessage = errors.New("gcpjwt/cose: invalid COSE_Sign1 message")
+
+	algorithms = map[int64]jwt.SigningMethod{
+		AlgorithmES256: jwt.SigningMethodES256,
+		AlgorithmES384: jwt.SigningMethodES384,
+		AlgorithmEdDSA: jwt.SigningMethodEdDSA,
+		AlgorithmPS256: jwt.SigningMethodPS256,
+		AlgorithmRS256: jwt.SigningMethodRS256,
+	}
+)
+
+// algorithmFor returns the COSE algorithm identifier for the JWT algorithm
+func algorithmFor(alg string) (int64, bool) {
+	for id, method := range algorithms {
+		if method.Alg() == alg {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// Signer produces COSE_Sign1 messages with a Cloud KMS key. The algorithm and key id are set in the protected header.
+type Signer struct {
+	alg   int64
+	keyID []byte
+	sign  func(toBeSigned []byte) ([]byte, error)
+}
+
+// NewKMSSigner returns a Signer using the KMS signing method with the key configured in the KMSConfig. The key id is
+// the KMSConfig's KeyID.
+func NewKMSSigner(ctx context.Context, method *gcpjwt.SigningMethodKMS, config *gcpjwt.KMSConfig) (*Signer, error) {
+	alg, ok := algorithmFor(method.Overridden().Alg())
+	if !ok {
+		return nil, fmt.Errorf("gcpjwt/cose: unsupported signing method `%s`", method.Alg())
+	}
+
+	key := gcpjwt.NewKMSContext(ctx, config)
+	return &Signer{
+		alg:   alg,
+		keyID: []byte(config.KeyID()),
+		sign: func(toBeSigned []byte) ([]byte, error) {
+			sig, err := method.Sign(string(toBeSigned), key)
+			if err != nil {
+				return nil, err
+			}
+			return jwt.DecodeSegment(sig)
+		},
+	}, nil
+}
+
+// Sign1 returns a tagged COSE_Sign1 message for the payload. The external additional authenticated data is signed
+// but not included in the message and must be provided again to verify it.
+func (s *Signer) Sign1(payload, externalAAD []byte) ([]byte, error) {
+	msg, err := s.sign1(payload, externalAAD)
+	if err != nil {
+		return nil, err
+	}
+	return encodeCBOR(msg)
+}
+
+func (s *Signer) sign1(payload, externalAAD []byte) (tag, error) {
+	protected, err := encodeCBOR(map[interface{}]interface{}{
+		int64(headerAlgorithm): s.alg,
+		int64(headerKeyID):     s.keyID,
+	})
+	if err != nil {
+		return tag{}, err
+	}
+
+	toBeSigned, err := sigStructure(protected, externalAAD, payload)
+	if err != nil {
+		return tag{}, err
+	}
+
+	sig, err := s.sign(toBeSigned)
+	if err != nil {
+		return tag{}, fmt.Errorf("gcpjwt/cose: could not sign message: %v", err)
+	}
+
+	return tag{tagSign1, []interface{}{
+		protected,
+		map[interface{}]interface{}{},
+		payload,
+		sig,
+	}}, nil
+}
+
+// Keyfunc returns the public key to verify a message with, based on the algorithm and key id of the (unverified)
+// protected header.
+type Keyfunc func(alg int64, keyID []byte) (crypto.PublicKey, error)
+
+// KMSVerifyKeyfunc is a helper that returns a Keyfunc for the key configured in the KMSConfig, used with the KMS
+// signing method. The public key is retrieved when creating the Keyfunc. The message's algorithm must be the one of
+// the signing method and, if the message has a key id, it must match the KMSConfig's KeyID.
+func KMSVerifyKeyfunc(ctx context.Context, method *gcpjwt.SigningMethodKMS, config *gcpjwt.KMSConfig) (Keyfunc, error) {
+	keyAlg, ok := algorithmFor(method.Overridden().Alg())
+	if !ok {
+		return nil, fmt.Errorf("gcpjwt/cose: unsupported signing method `%s`", method.Alg())
+	}
+
+	keyID := []byte(config.KeyID())
+	jwk, err := gcpjwt.KMSJWK(ctx, config, method)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(alg int64, kid []byte) (crypto.PublicKey, error) {
+		if alg != keyAlg {
+			return nil, fmt.Errorf("gcpjwt/cose: algorithm %d found in header does not match the key's algorithm %d", alg, keyAlg)
+		}
+		if len(kid) > 0 && !bytes.Equal(kid, keyID) {
+			return nil, fmt.Errorf("gcpjwt/cose: unknown kid `%s` found in header", kid)
+		}
+		return publicKey, nil
+	}, nil
+}
+
+// VerifySign1 verifies a COSE_Sign1 message, tagged or not, with the key returned by the keyFunc and the external
+// additional authenticated data used to sign it, returning the payload.
+func VerifySign1(msg []byte, keyFunc Keyfunc, externalAAD []byte) ([]byte, error) {
+	v, err := decodeCBOR(msg)
+	if err != nil {
+		return nil, err
+	}
+	return verifySign1(v, keyFunc, externalAAD)
+}
+
+func verifySign1(v interface{}, keyFunc Keyfunc, externalAAD []byte) ([]byte, error) {
+	if t, ok := v.(tag); ok {
+		if t.number != tagSign1 {
+			return nil, ErrInvalidMessage
+		}
+		v = t.content
+	}
+
+	parts, ok := v.([]interface{})
+	if !ok || len(parts) != 4 {
+		return nil, ErrInvalidMessage
+	}
+	protected, ok1 := parts[0].([]byte)
+	payload, ok2 := parts[2].([]byte)
+	sig, ok3 := parts[3].([]byte)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, ErrInvalidMessage
+	}
+
+	header, err := decodeCBOR(protected)
+	if err != nil {
+		return nil, err
+	}
+	headerMap, ok := header.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidMessage
+	}
+	alg, _ := headerMap[int64(headerAlgorithm)].(int64)
+	method, ok := algorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("gcpjwt/cose: unsupported algorithm %d", alg)
+	}
+	kid, _ := headerMap[int64(headerKeyID)].([]byte)
+
+	publicKey, err := keyFunc(alg, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	toBeSigned, err := sigStructure(protected, externalAAD, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// COSE signatures use the same encoding as JWS signatures
+	if err := method.Verify(string(toBeSigned), jwt.EncodeSegment(sig), publicKey); err != nil {
+		return nil, fmt.Errorf("gcpjwt/cose: invalid signature: %v", err)
+	}
+
+	return payload, nil
+}
+
+// sigStructure returns the Sig_structure to sign for a COSE_Sign1 message
+// https://www.rfc-editor.org/rfc/rfc9052#section-4.4
+func sigStructure(protected, externalAAD, payload []byte) ([]byte, error) {
+	if externalAAD == nil {
+		externalAAD = []byte{}
+	}
+	return encodeCBOR([]interface{}{
+		"Signature1",
+		protected,
+		externalAAD,
+		payload,
+	})
+}
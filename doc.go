@@ -12,6 +12,18 @@ header with its own).
 You should only need to override the algorithm(s) you plan to use. It is also incorrect to override overlapping,
 algorithms such as `gcpjwt.SigningMethodKMSRS256.Override()` and `gcpjwt.SigningMethodIAMJWT.Override()`
 
+If you need overlapping algorithms in the same process, use the Overridden() variants instead, which return a copy of
+the signing method with the standard algorithm identifier and do not touch the global jwt-go registry, along with a
+gcpjwt.Registry per verifier. Restore() undoes a previous call to Override().
+
+	kmsRegistry := gcpjwt.NewRegistry(gcpjwt.SigningMethodKMSRS256.Overridden())
+	token, err := kmsRegistry.Parse(tokenString, keyFunc)
+
+For local development without Google Cloud credentials, gcpjwt.EnableLocalMode() (or the LocalMode field of IAMConfig
+and KMSConfig) signs and verifies with deterministic keys derived from the service account or key path. Anyone can
+derive these keys, so local mode is refused in Google Cloud runtimes, on Compute Engine and in Kubernetes clusters, and
+logs a warning when used.
+
 Example:
 
 	import (
@@ -26,11 +38,15 @@ Example:
 		gcpjwt.SigningMethodKMSPS256.Override() // PS256
 		gcpjwt.SigningMethodKMSES256.Override() // ES256
 		gcpjwt.SigningMethodKMSES384.Override() // ES384
+		gcpjwt.SigningMethodKMSEdDSA.Override() // EdDSA
 
 		// IAM API - This implements RS256 exclusively
 		gcpjwt.SigningMethodIAMJWT.Override() // For signJwt
 		gcpjwt.SigningMethodIAMBlob.Override() // For signBlob
 
+		// Secret Manager - HMAC keys stored as secret versions
+		gcpjwt.SigningMethodSecretHS256.Override() // HS256
+
 		// AppEngine - Standard runtime only, does not apply to Flexible runtime, implements RS256 exclusively
 		// You can also use any of the above methods on AppEngine Standard
 		gcpjwt.SigningMethodAppEngine.Override()
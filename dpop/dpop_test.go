@@ -0,0 +1,150 @@
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+func newTestSigner(t *testing.T) *Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := gcpjwt.NewJWK(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Signer{method: jwt.SigningMethodES256, key: key, jwk: jwk}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	signer := newTestSigner(t)
+	thumbprint, err := signer.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const uri = "https://api.example.com/resource"
+	proof := func(method, uri, accessToken, nonce string) string {
+		p, err := signer.Proof(method, uri, accessToken, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name        string
+		verifier    *Verifier
+		proof       string
+		method      string
+		uri         string
+		accessToken string
+		wantErr     bool
+	}{
+		{"Valid", &Verifier{}, proof("GET", uri+"?q=1#f", "", ""), "GET", uri, "", false},
+		{"ValidWithToken", &Verifier{}, proof("POST", uri, "token", ""), "POST", uri, "token", false},
+		{"WrongMethod", &Verifier{}, proof("GET", uri, "", ""), "POST", uri, "", true},
+		{"WrongURI", &Verifier{}, proof("GET", uri, "", ""), "GET", uri + "/other", "", true},
+		{"WrongToken", &Verifier{}, proof("GET", uri, "token", ""), "GET", uri, "other", true},
+		{"MissingToken", &Verifier{}, proof("GET", uri, "", ""), "GET", uri, "token", true},
+		{"Nonce", &Verifier{Nonce: func() string { return "n" }}, proof("GET", uri, "", "n"), "GET", uri, "", false},
+		{"WrongNonce", &Verifier{Nonce: func() string { return "n" }}, proof("GET", uri, "", "x"), "GET", uri, "", true},
+		{"Malformed", &Verifier{}, "not.a.proof", "GET", uri, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.verifier.Verify(tt.proof, tt.method, tt.uri, tt.accessToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verifier.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != thumbprint {
+				t.Errorf("Verifier.Verify() = %v, want %v", got, thumbprint)
+			}
+		})
+	}
+
+	t.Run("WrongType", func(t *testing.T) {
+		token := jwt.NewWithClaims(signer.method, &ProofClaims{ID: "1", HTTPMethod: "GET", HTTPURI: uri})
+		token.Header["jwk"] = signer.jwk
+		p, err := token.SignedString(signer.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (&Verifier{}).Verify(p, "GET", uri, ""); !errors.Is(err, ErrInvalidProof) {
+			t.Errorf("Verifier.Verify() error = %v, want %v", err, ErrInvalidProof)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		v := &Verifier{ReplayCache: NewMemoryReplayCache()}
+		p := proof("GET", uri, "", "")
+		if _, err := v.Verify(p, "GET", uri, ""); err != nil {
+			t.Fatalf("Verifier.Verify() error = %v", err)
+		}
+		if _, err := v.Verify(p, "GET", uri, ""); err != ErrReplayedProof {
+			t.Errorf("Verifier.Verify() error = %v, want %v", err, ErrReplayedProof)
+		}
+	})
+}
+
+func TestTransport(t *testing.T) {
+	signer := newTestSigner(t)
+	const nonce = "server-nonce"
+	verifier := &Verifier{Nonce: func() string { return nonce }}
+
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		accessToken, ok := AccessToken(r)
+		if !ok || accessToken != "access-token" {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+		if _, err := verifier.VerifyRequest(r, accessToken); err != nil {
+			w.Header().Set(NonceHeaderName, nonce)
+			w.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access-token"}),
+		Signer: signer,
+	}}
+
+	resp, err := client.Post(server.URL+"/path?q=1", "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Transport status = %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+	if calls != 2 {
+		t.Errorf("Transport calls = %v, want 2 (nonce retry)", calls)
+	}
+
+	// The nonce is remembered for following requests
+	resp, err = client.Get(server.URL + "/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls != 3 {
+		t.Errorf("Transport status = %v calls = %v, want %v and 3", resp.StatusCode, calls, http.StatusOK)
+	}
+}
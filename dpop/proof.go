@@ -0,0 +1,145 @@
+// Package dpop creates and verifies DPoP proofs (RFC 9449) to sender-constrain access tokens, with the proofs signed
+// by a Cloud KMS key.
+// https://www.rfc-editor.org/rfc/rfc9449
+package dpop
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+const (
+	// HeaderName is the name of the HTTP header carrying the DPoP proof
+	HeaderName = "DPoP"
+	// NonceHeaderName is the name of the HTTP header a server uses to provide a nonce to include in proofs
+	NonceHeaderName = "DPoP-Nonce"
+	// AuthorizationScheme is the scheme of the Authorization header for DPoP-bound access tokens
+	AuthorizationScheme = "DPoP"
+
+	proofType = "dpop+jwt"
+)
+
+// ProofClaims are the claims of a DPoP proof JWT.
+type ProofClaims struct {
+	ID              string `json:"jti"`
+	HTTPMethod      string `json:"htm"`
+	HTTPURI         string `json:"htu"`
+	IssuedAt        int64  `json:"iat"`
+	AccessTokenHash string `json:"ath,omitempty"`
+	Nonce           string `json:"nonce,omitempty"`
+}
+
+// Valid implements jwt.Claims, time based validation is done by the Verifier.
+func (c *ProofClaims) Valid() error {
+	return nil
+}
+
+// Confirmation is the `cnf` claim of an access token bound to a DPoP key.
+type Confirmation struct {
+	JWKThumbprint string `json:"jkt"`
+}
+
+// BoundClaims are the standard claims of an access token with its optional `cnf` claim.
+type BoundClaims struct {
+	jwt.StandardClaims
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+// Signer creates DPoP proofs signed with a Cloud KMS key, embedding the public key as a JWK in the proof header.
+type Signer struct {
+	method jwt.SigningMethod
+	key    interface{}
+	jwk    *gcpjwt.JWK
+}
+
+// NewKMSSigner returns a Signer using the KMS signing method with the key configured in the KMSConfig. The public key
+// is retrieved once to be embedded in every proof.
+func NewKMSSigner(ctx context.Context, method *gcpjwt.SigningMethodKMS, config *gcpjwt.KMSConfig) (*Signer, error) {
+	publicKey, err := gcpjwt.KMSPublicKey(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk, err := gcpjwt.NewJWK(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{
+		method: method.Overridden(),
+		key:    gcpjwt.NewKMSContext(ctx, config),
+		jwk:    jwk,
+	}, nil
+}
+
+// JWK returns the public key embedded in proofs.
+func (s *Signer) JWK() *gcpjwt.JWK {
+	return s.jwk
+}
+
+// Thumbprint returns the JWK thumbprint of the public key, the value access tokens are bound to with the `cnf.jkt`
+// claim.
+func (s *Signer) Thumbprint() (string, error) {
+	return s.jwk.Thumbprint()
+}
+
+// Proof returns a DPoP proof for a request with the HTTP method and URI. The access token and nonce are optional and
+// set the `ath` and `nonce` claims.
+func (s *Signer) Proof(method, uri, accessToken, nonce string) (string, error) {
+	htu, err := normalizeURI(uri)
+	if err != nil {
+		return "", err
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	claims := &ProofClaims{
+		ID:         base64.RawURLEncoding.EncodeToString(jti),
+		HTTPMethod: method,
+		HTTPURI:    htu,
+		IssuedAt:   time.Now().Unix(),
+		Nonce:      nonce,
+	}
+	if accessToken != "" {
+		claims.AccessTokenHash = AccessTokenHash(accessToken)
+	}
+
+	token := jwt.NewWithClaims(s.method, claims)
+	token.Header["typ"] = proofType
+	token.Header["jwk"] = s.jwk
+
+	return token.SignedString(s.key)
+}
+
+// AccessTokenHash returns the value of the `ath` claim for the access token.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// normalizeURI returns the URI without its query and fragment parts, as used for the `htu` claim
+func normalizeURI(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+
+	return u.String(), nil
+}
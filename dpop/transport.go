@@ -0,0 +1,102 @@
+package dpop
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// Transport is an http.RoundTripper attaching a DPoP-bound access token from the Source and a fresh DPoP proof to
+// every request. When the server asks for a nonce (use_dpop_nonce), the request is retried once with it if its body
+// can be replayed.
+type Transport struct {
+	// Base is the underlying http.RoundTripper, http.DefaultTransport is used if nil
+	Base http.RoundTripper
+
+	// Source provides the access tokens, e.g. the TokenSource of the gcpjwt/oauth2 package
+	Source oauth2.TokenSource
+
+	// Signer creates the DPoP proofs
+	Signer *Signer
+
+	mu    sync.Mutex
+	nonce string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Source == nil || t.Signer == nil {
+		return nil, errors.New("gcpjwt/dpop: Transport's Source and Signer must be set")
+	}
+
+	token, err := t.Source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.roundTrip(req, token.AccessToken, t.currentNonce())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := resp.Header.Get(NonceHeaderName)
+	if nonce == "" {
+		return resp, nil
+	}
+	t.setNonce(nonce)
+
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(resp.Header.Get("WWW-Authenticate"), "use_dpop_nonce") {
+		return resp, nil
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	resp.Body.Close()
+	return t.roundTrip(req, token.AccessToken, nonce)
+}
+
+func (t *Transport) roundTrip(req *http.Request, accessToken, nonce string) (*http.Response, error) {
+	proof, err := t.Signer.Proof(req.Method, req.URL.String(), accessToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// RoundTrippers must not modify the provided request
+	r := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	r.Header.Set("Authorization", AuthorizationScheme+" "+accessToken)
+	r.Header.Set(HeaderName, proof)
+
+	return t.base().RoundTrip(r)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) currentNonce() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.nonce
+}
+
+func (t *Transport) setNonce(nonce string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nonce = nonce
+}
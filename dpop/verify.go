@@ -0,0 +1,191 @@
+package dpop
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	cache "github.com/patrickmn/go-cache"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+const (
+	defaultMaxAge = 5 * time.Minute
+	defaultLeeway = 30 * time.Second
+)
+
+var (
+	// ErrInvalidProof is returned when a DPoP proof is malformed or does not match the request
+	ErrInvalidProof = errors.New("gcpjwt/dpop: invalid DPoP proof")
+	// ErrReplayedProof is returned when a DPoP proof was already used
+	ErrReplayedProof = errors.New("gcpjwt/dpop: DPoP proof replayed")
+	// ErrInvalidNonce is returned when a DPoP proof does not carry the expected nonce
+	ErrInvalidNonce = errors.New("gcpjwt/dpop: invalid DPoP nonce")
+
+	// Only asymmetric algorithms are allowed, resolved without the global (possibly overridden) jwt-go registry
+	proofMethods = gcpjwt.NewRegistry(
+		jwt.SigningMethodRS256,
+		jwt.SigningMethodPS256,
+		jwt.SigningMethodES256,
+		jwt.SigningMethodES384,
+		jwt.SigningMethodES512,
+		jwt.SigningMethodEdDSA,
+	)
+)
+
+// ReplayCache records the `jti` of proofs to reject replays.
+type ReplayCache interface {
+	// Seen records the jti until the expiration and reports whether it was already recorded
+	Seen(jti string, expires time.Time) bool
+}
+
+type memoryReplayCache struct {
+	c *cache.Cache
+}
+
+// NewMemoryReplayCache returns an in-memory ReplayCache.
+func NewMemoryReplayCache() ReplayCache {
+	return &memoryReplayCache{c: cache.New(defaultMaxAge, time.Minute)}
+}
+
+func (m *memoryReplayCache) Seen(jti string, expires time.Time) bool {
+	return m.c.Add(jti, struct{}{}, time.Until(expires)) != nil
+}
+
+// Verifier verifies DPoP proofs.
+type Verifier struct {
+	// MaxAge is the maximum age of a proof based on its `iat` claim. Defaults to 5 minutes.
+	MaxAge time.Duration
+
+	// Leeway is the allowed clock skew for the `iat` claim. Defaults to 30 seconds.
+	Leeway time.Duration
+
+	// ReplayCache rejects proofs that were already used when set.
+	ReplayCache ReplayCache
+
+	// Nonce returns the nonce proofs must carry when set, see NonceHeaderName.
+	Nonce func() string
+
+	// RequestURI returns the URI of the request to compare to the `htu` claim, defaults to the request's scheme, Host
+	// and path. Override it when behind a proxy.
+	RequestURI func(r *http.Request) string
+}
+
+// VerifyRequest verifies the DPoP proof header of the request for the access token (if any), returning the JWK
+// thumbprint of the proof's key to compare with the `cnf.jkt` claim of the access token.
+func (v *Verifier) VerifyRequest(r *http.Request, accessToken string) (string, error) {
+	proofs := r.Header.Values(HeaderName)
+	if len(proofs) != 1 {
+		return "", ErrInvalidProof
+	}
+
+	uri := requestURI(r)
+	if v.RequestURI != nil {
+		uri = v.RequestURI(r)
+	}
+
+	return v.Verify(proofs[0], r.Method, uri, accessToken)
+}
+
+// Verify verifies a DPoP proof for a request with the HTTP method and URI and the access token (if any), returning
+// the JWK thumbprint of the proof's key.
+func (v *Verifier) Verify(proof, method, uri, accessToken string) (string, error) {
+	var jwk *gcpjwt.JWK
+	claims := &ProofClaims{}
+	token, err := proofMethods.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != proofType {
+			return nil, ErrInvalidProof
+		}
+
+		var err error
+		jwk, err = headerJWK(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+
+		return jwk.PublicKey()
+	})
+	if err != nil || !token.Valid {
+		return "", fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+
+	htu, err := normalizeURI(uri)
+	if err != nil {
+		return "", err
+	}
+	if claims.ID == "" || claims.HTTPMethod != method || claims.HTTPURI != htu {
+		return "", ErrInvalidProof
+	}
+
+	maxAge, leeway := v.MaxAge, v.Leeway
+	if maxAge <= 0 {
+		maxAge = defaultMaxAge
+	}
+	if leeway <= 0 {
+		leeway = defaultLeeway
+	}
+	iat := time.Unix(claims.IssuedAt, 0)
+	now := time.Now()
+	if iat.After(now.Add(leeway)) || iat.Before(now.Add(-maxAge)) {
+		return "", ErrInvalidProof
+	}
+
+	if accessToken != "" && claims.AccessTokenHash != AccessTokenHash(accessToken) {
+		return "", ErrInvalidProof
+	}
+
+	if v.Nonce != nil && claims.Nonce != v.Nonce() {
+		return "", ErrInvalidNonce
+	}
+
+	if v.ReplayCache != nil && v.ReplayCache.Seen(claims.ID, iat.Add(maxAge+leeway)) {
+		return "", ErrReplayedProof
+	}
+
+	return jwk.Thumbprint()
+}
+
+// headerJWK reads the `jwk` header of a proof, which must not contain a private key
+func headerJWK(v interface{}) (*gcpjwt.JWK, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidProof
+	}
+	if _, ok := m["d"]; ok {
+		return nil, ErrInvalidProof
+	}
+
+	str := func(key string) string {
+		s, _ := m[key].(string)
+		return s
+	}
+	return &gcpjwt.JWK{
+		KeyType: str("kty"),
+		Curve:   str("crv"),
+		X:       str("x"),
+		Y:       str("y"),
+		N:       str("n"),
+		E:       str("e"),
+	}, nil
+}
+
+func requestURI(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.Path)
+}
+
+// AccessToken extracts the access token of a DPoP Authorization header.
+func AccessToken(r *http.Request) (string, bool) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], AuthorizationScheme) || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
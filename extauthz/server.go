@@ -0,0 +1,209 @@
+// Package extauthz is an Envoy external authorization (ext_authz) gRPC service verifying the bearer tokens of
+// requests with the gcpjwt keyfuncs, so tokens are verified once by the sidecar (Envoy, Istio) instead of by every
+// upstream service. Verified claims are forwarded to the upstream as request headers.
+//
+// https://www.envoyproxy.io/docs/envoy/latest/api-v3/service/auth/v3/external_auth.proto
+package extauthz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/golang-jwt/jwt"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+const (
+	// DefaultPayloadHeader is the header the verified claims are forwarded in, as base64url encoded JSON
+	DefaultPayloadHeader = "x-jwt-payload"
+)
+
+// Server implements the Envoy ext_authz Authorization service. Like jwtmiddleware.NewHandler, requests without a
+// valid bearer token are denied with 401 and tokens for another audience or issuer with 403.
+type Server struct {
+	// Keyfunc selects the key to verify tokens with, e.g. gcpjwt.IAMVerfiyKeyfunc, gcpjwt.KMSVerfiyKeyfunc or the
+	// Keyfunc of a gcpjwt.Verifier
+	Keyfunc jwt.Keyfunc
+
+	// Registry, if set, resolves the signing method of tokens instead of the global jwt-go registry
+	Registry *gcpjwt.Registry
+
+	// Audience is the expected `aud` claim, https:// + the request's host if blank
+	Audience string
+
+	// Audiences, if set, returns the accepted `aud` claims instead of Audience, e.g. the audiences of a
+	// gcpjwt.Verifier's current config so both agree. https:// + the request's host is expected if it returns none.
+	Audiences func() []string
+
+	// Issuers, if not empty, are the accepted `iss` claims
+	Issuers []string
+
+	// PayloadHeader is the header the verified claims are forwarded in, DefaultPayloadHeader if blank
+	PayloadHeader string
+
+	// ClaimHeaders maps top-level claims to headers they are forwarded in, e.g. "sub" to "x-jwt-sub". The headers of
+	// absent claims are removed from the request so clients cannot set them.
+	ClaimHeaders map[string]string
+}
+
+// NewServer returns a Server verifying tokens with the keyFunc for the audience, issued by one of the issuers if any.
+func NewServer(keyFunc jwt.Keyfunc, audience string, issuers ...string) *Server {
+	return &Server{
+		Keyfunc:  keyFunc,
+		Audience: audience,
+		Issuers:  issuers,
+	}
+}
+
+// Register registers the Server as the Authorization service of the gRPC server.
+func (s *Server) Register(server *grpc.Server) {
+	authv3.RegisterAuthorizationServer(server, s)
+}
+
+// Check implements the Authorization service, allowing requests with a valid token and forwarding its claims.
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	if httpReq == nil {
+		return denied(codes.InvalidArgument, typev3.StatusCode_BadRequest, "missing HTTP request attributes"), nil
+	}
+
+	tokenString, ok := bearerToken(httpReq.GetHeaders()["authorization"])
+	if !ok {
+		return denied(codes.Unauthenticated, typev3.StatusCode_Unauthorized, "missing bearer token"), nil
+	}
+
+	claims := jwt.MapClaims{}
+	parser := new(jwt.Parser)
+	keyFunc := s.Keyfunc
+	if s.Registry != nil {
+		keyFunc = s.Registry.Keyfunc(keyFunc)
+	}
+	token, err := parser.ParseWithClaims(tokenString, claims, keyFunc)
+	if err != nil || !token.Valid {
+		return denied(codes.Unauthenticated, typev3.StatusCode_Unauthorized, "invalid token"), nil
+	}
+
+	if !s.verifyAudience(claims, httpReq.GetHost()) || !s.verifyIssuer(claims) {
+		return denied(codes.PermissionDenied, typev3.StatusCode_Forbidden, "token not accepted"), nil
+	}
+
+	headers, remove, err := s.claimHeaders(token, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{Headers: headers, HeadersToRemove: remove},
+		},
+	}, nil
+}
+
+func (s *Server) verifyAudience(claims jwt.MapClaims, host string) bool {
+	audiences := []string{s.Audience}
+	if s.Audiences != nil {
+		audiences = s.Audiences()
+	}
+	if len(audiences) == 0 || len(audiences) == 1 && audiences[0] == "" {
+		audiences = []string{fmt.Sprintf("https://%s", host)}
+	}
+	for _, aud := range audiences {
+		if claims.VerifyAudience(aud, true) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) verifyIssuer(claims jwt.MapClaims) bool {
+	if len(s.Issuers) == 0 {
+		return true
+	}
+	for _, iss := range s.Issuers {
+		if claims.VerifyIssuer(iss, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// claimHeaders returns the headers forwarding the claims, overwriting any sent by the client, and the headers of the
+// absent claims to remove
+func (s *Server) claimHeaders(token *jwt.Token, claims jwt.MapClaims) ([]*corev3.HeaderValueOption, []string, error) {
+	payloadHeader := s.PayloadHeader
+	if payloadHeader == "" {
+		payloadHeader = DefaultPayloadHeader
+	}
+
+	// Forward the payload as signed rather than re-encoding the claims
+	parts := strings.Split(token.Raw, ".")
+	headers := []*corev3.HeaderValueOption{header(payloadHeader, parts[1])}
+
+	var remove []string
+	for claim, name := range s.ClaimHeaders {
+		value, ok := claims[claim]
+		if !ok {
+			remove = append(remove, name)
+			continue
+		}
+
+		var str string
+		switch v := value.(type) {
+		case string:
+			str = v
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, nil, err
+			}
+			str = string(b)
+		}
+		headers = append(headers, header(name, str))
+	}
+
+	return headers, remove, nil
+}
+
+func header(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: key, Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
+
+func denied(code codes.Code, httpCode typev3.StatusCode, message string) *authv3.CheckResponse {
+	var headers []*corev3.HeaderValueOption
+	if httpCode == typev3.StatusCode_Unauthorized {
+		headers = append(headers, header("www-authenticate", "Bearer"))
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(code), Message: message},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: httpCode},
+				Headers: headers,
+				Body:    message,
+			},
+		},
+	}
+}
+
+// bearerToken extracts the token of a Bearer authorization header
+func bearerToken(authorization string) (string, bool) {
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[len(prefix):]), true
+}
@@ -0,0 +1,150 @@
+package extauthz
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/grpc/codes"
+)
+
+func TestServer_Check(t *testing.T) {
+	secret := []byte("secret")
+	server := NewServer(func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, "", "caller@project.iam.gserviceaccount.com")
+	server.ClaimHeaders = map[string]string{"sub": "x-jwt-sub", "groups": "x-jwt-groups", "missing": "x-jwt-missing"}
+
+	sign := func(claims jwt.MapClaims, key []byte) string {
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := jwt.MapClaims{
+		"iss":    "caller@project.iam.gserviceaccount.com",
+		"aud":    "https://service.example.com",
+		"sub":    "user",
+		"groups": []string{"a", "b"},
+		"exp":    exp,
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      codes.Code
+		wantHTTP      typev3.StatusCode
+	}{
+		{"Valid", "Bearer " + sign(valid, secret), codes.OK, 0},
+		{"LowercaseScheme", "bearer " + sign(valid, secret), codes.OK, 0},
+		{"Missing", "", codes.Unauthenticated, typev3.StatusCode_Unauthorized},
+		{"Basic", "Basic dXNlcjpwYXNz", codes.Unauthenticated, typev3.StatusCode_Unauthorized},
+		{"InvalidSignature", "Bearer " + sign(valid, []byte("other")), codes.Unauthenticated, typev3.StatusCode_Unauthorized},
+		{"Expired", "Bearer " + sign(jwt.MapClaims{"iss": valid["iss"], "aud": valid["aud"], "exp": time.Now().Add(-time.Hour).Unix()}, secret), codes.Unauthenticated, typev3.StatusCode_Unauthorized},
+		{"WrongAudience", "Bearer " + sign(jwt.MapClaims{"iss": valid["iss"], "aud": "https://other.example.com"}, secret), codes.PermissionDenied, typev3.StatusCode_Forbidden},
+		{"WrongIssuer", "Bearer " + sign(jwt.MapClaims{"iss": "other", "aud": valid["aud"]}, secret), codes.PermissionDenied, typev3.StatusCode_Forbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := server.Check(context.Background(), &authv3.CheckRequest{
+				Attributes: &authv3.AttributeContext{
+					Request: &authv3.AttributeContext_Request{
+						Http: &authv3.AttributeContext_HttpRequest{
+							Host:    "service.example.com",
+							Headers: map[string]string{"authorization": tt.authorization, "x-jwt-sub": "spoofed", "x-jwt-missing": "spoofed"},
+						},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+			if got := codes.Code(resp.Status.Code); got != tt.wantCode {
+				t.Fatalf("Check() code = %v, want %v", got, tt.wantCode)
+			}
+
+			if tt.wantCode != codes.OK {
+				if got := resp.GetDeniedResponse().GetStatus().GetCode(); got != tt.wantHTTP {
+					t.Errorf("Check() HTTP status = %v, want %v", got, tt.wantHTTP)
+				}
+				return
+			}
+
+			headers := make(map[string]string)
+			for _, h := range resp.GetOkResponse().GetHeaders() {
+				headers[h.Header.Key] = h.Header.Value
+			}
+			if headers["x-jwt-sub"] != "user" || headers["x-jwt-groups"] != `["a","b"]` {
+				t.Errorf("Check() headers = %v", headers)
+			}
+			if _, ok := headers["x-jwt-missing"]; ok {
+				t.Errorf("Check() forwarded a missing claim")
+			}
+			if remove := resp.GetOkResponse().GetHeadersToRemove(); len(remove) != 1 || remove[0] != "x-jwt-missing" {
+				t.Errorf("Check() headers to remove = %v, want [x-jwt-missing]", remove)
+			}
+
+			payload, err := jwt.DecodeSegment(headers[DefaultPayloadHeader])
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims := jwt.MapClaims{}
+			if err := json.Unmarshal(payload, &claims); err != nil || claims["sub"] != "user" {
+				t.Errorf("Check() payload = %s, error = %v", payload, err)
+			}
+		})
+	}
+}
+
+func TestServer_CheckAudiences(t *testing.T) {
+	secret := []byte("secret")
+	var audiences []string
+	server := NewServer(func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, "https://ignored.example.com")
+	server.Audiences = func() []string { return audiences }
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"aud": "https://api.example.com"}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(host string) codes.Code {
+		resp, err := server.Check(context.Background(), &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{
+						Host:    host,
+						Headers: map[string]string{"authorization": "Bearer " + tokenString},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		return codes.Code(resp.Status.Code)
+	}
+
+	if got := check("api.example.com"); got != codes.OK {
+		t.Errorf("Check() without audiences for the host = %v, want %v", got, codes.OK)
+	}
+	if got := check("other.example.com"); got != codes.PermissionDenied {
+		t.Errorf("Check() without audiences for another host = %v, want %v", got, codes.PermissionDenied)
+	}
+
+	audiences = []string{"https://other.example.com", "https://api.example.com"}
+	if got := check("other.example.com"); got != codes.OK {
+		t.Errorf("Check() with audiences = %v, want %v", got, codes.OK)
+	}
+
+	audiences = []string{"https://other.example.com"}
+	if got := check("api.example.com"); got != codes.PermissionDenied {
+		t.Errorf("Check() with other audiences = %v, want %v", got, codes.PermissionDenied)
+	}
+}
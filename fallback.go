@@ -0,0 +1,291 @@
+package gcpjwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultCooldown         = 30 * time.Second
+)
+
+var (
+	// ErrCircuitOpen is returned by a FallbackSigner when every backend has an open circuit
+	ErrCircuitOpen = errors.New("gcpjwt: all signing backends are unavailable")
+)
+
+// CircuitState is the state of the circuit breaker kept for each FallbackSigner backend
+type CircuitState int
+
+const (
+	// CircuitClosed means the backend is healthy and will be tried
+	CircuitClosed CircuitState = iota
+	// CircuitOpen means the backend failed too many times in a row and will be skipped until the cooldown expires
+	CircuitOpen
+	// CircuitHalfOpen means the cooldown expired and a single trial call is allowed through
+	CircuitHalfOpen
+)
+
+func (c CircuitState) String() string {
+	switch c {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(c))
+}
+
+// FallbackBackend is a single signing backend used by a FallbackSigner.
+type FallbackBackend struct {
+	// Method is the signing method to use, e.g. SigningMethodIAMJWT or SigningMethodKMSRS256
+	Method jwt.SigningMethod
+
+	// Key is passed to Method.Sign, usually a context.Context carrying an IAMConfig or KMSConfig
+	Key interface{}
+
+	// KeyID is set as the `kid` header of tokens signed by this backend when not empty
+	KeyID string
+
+	// Issuer is set as the `iss` claim of tokens signed by this backend when not empty
+	Issuer string
+}
+
+// NewIAMFallbackBackend returns a FallbackBackend for the IAM signing method hinted by the config's IAMType. Tokens
+// are issued by the configured ServiceAccount.
+func NewIAMFallbackBackend(ctx context.Context, config *IAMConfig) (*FallbackBackend, error) {
+	var method jwt.SigningMethod
+	switch config.IAMType {
+	case IAMBlobType:
+		method = SigningMethodIAMBlob
+	case IAMJwtType:
+		method = SigningMethodIAMJWT
+	default:
+		return nil, fmt.Errorf("gcpjwt: unknown iam type `%v` provided", config.IAMType)
+	}
+
+	return &FallbackBackend{
+		Method: method,
+		Key:    NewIAMContext(ctx, config),
+		Issuer: config.ServiceAccount,
+	}, nil
+}
+
+// NewKMSFallbackBackend returns a FallbackBackend for the provided KMS signing method. Tokens will carry the config's
+// KeyID as the `kid` header and the provided issuer, if any.
+func NewKMSFallbackBackend(ctx context.Context, method *SigningMethodKMS, config *KMSConfig, issuer string) *FallbackBackend {
+	return &FallbackBackend{
+		Method: method,
+		Key:    NewKMSContext(ctx, config),
+		KeyID:  config.KeyID(),
+		Issuer: issuer,
+	}
+}
+
+type circuitBreaker struct {
+	sync.Mutex
+
+	failures int
+	openedAt time.Time
+	state    CircuitState
+}
+
+// allow reports whether a call should be attempted, moving an open circuit to half-open once the cooldown expired.
+// Only a single trial call is let through while half-open.
+func (c *circuitBreaker) allow(cooldown time.Duration) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	switch c.state {
+	case CircuitOpen:
+		if time.Since(c.openedAt) < cooldown {
+			return false
+		}
+		c.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		return false
+	}
+	return true
+}
+
+func (c *circuitBreaker) success() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.failures = 0
+	c.state = CircuitClosed
+}
+
+func (c *circuitBreaker) failure(threshold int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.failures++
+	if c.state == CircuitHalfOpen || c.failures >= threshold {
+		c.state = CircuitOpen
+		c.openedAt = time.Now()
+	}
+}
+
+func (c *circuitBreaker) current() CircuitState {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.state
+}
+
+// FallbackSigner signs tokens with the first available backend of an ordered list, tripping a circuit breaker per
+// backend after consecutive failures so a degraded backend does not make every request wait for a timeout. Tokens
+// carry the `kid` header and `iss` claim of whichever backend signed them; pair with MultiVerifyKeyfunc to verify.
+type FallbackSigner struct {
+	// FailureThreshold is the number of consecutive failures that opens a backend's circuit. Defaults to 3.
+	FailureThreshold int
+
+	// Cooldown is how long an open circuit skips its backend before allowing a trial call. Defaults to 30 seconds.
+	Cooldown time.Duration
+
+	backends []*FallbackBackend
+	breakers []*circuitBreaker
+}
+
+// NewFallbackSigner returns a FallbackSigner trying the provided backends in order.
+func NewFallbackSigner(backends ...*FallbackBackend) *FallbackSigner {
+	f := &FallbackSigner{
+		backends: backends,
+		breakers: make([]*circuitBreaker, len(backends)),
+	}
+	for i := range f.breakers {
+		f.breakers[i] = &circuitBreaker{}
+	}
+
+	return f
+}
+
+// States returns the current circuit state of every backend, in order.
+func (f *FallbackSigner) States() []CircuitState {
+	states := make([]CircuitState, len(f.breakers))
+	for i, b := range f.breakers {
+		states[i] = b.current()
+	}
+
+	return states
+}
+
+// SignedString signs the claims with the first backend available, returning the complete token.
+func (f *FallbackSigner) SignedString(claims jwt.MapClaims) (string, error) {
+	threshold := f.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+	cooldown := f.Cooldown
+	if cooldown <= 0 {
+		cooldown = defaultCooldown
+	}
+
+	var lastErr error
+	for i, backend := range f.backends {
+		breaker := f.breakers[i]
+		if !breaker.allow(cooldown) {
+			continue
+		}
+
+		token, err := backend.signedString(claims)
+		if err != nil {
+			breaker.failure(threshold)
+			lastErr = err
+			continue
+		}
+
+		breaker.success()
+		return token, nil
+	}
+
+	if lastErr == nil {
+		return "", ErrCircuitOpen
+	}
+
+	return "", fmt.Errorf("gcpjwt: all signing backends failed, last error: %v", lastErr)
+}
+
+func (b *FallbackBackend) signedString(claims jwt.MapClaims) (string, error) {
+	backendClaims := make(jwt.MapClaims, len(claims)+1)
+	for k, v := range claims {
+		backendClaims[k] = v
+	}
+	if b.Issuer != "" {
+		backendClaims["iss"] = b.Issuer
+	}
+
+	token := jwt.NewWithClaims(b.Method, backendClaims)
+	if b.KeyID != "" {
+		token.Header["kid"] = b.KeyID
+	}
+
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := b.Method.Sign(signingString, b.Key)
+	if err != nil {
+		return "", err
+	}
+
+	// The signJwt API returns the complete token with its own header
+	if method, ok := b.Method.(*SigningMethodIAM); ok && method.returnsJWT() {
+		return sig, nil
+	}
+
+	return strings.Join([]string{signingString, sig}, "."), nil
+}
+
+// MultiVerifyKeyfunc returns a jwt.Keyfunc that selects the jwt.Keyfunc to use based on the `iss` claim of the token,
+// e.g. to verify tokens signed by a FallbackSigner.
+func MultiVerifyKeyfunc(keyfuncs map[string]jwt.Keyfunc) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		iss, err := tokenIssuer(token.Claims)
+		if err != nil {
+			return nil, err
+		}
+
+		keyFunc, ok := keyfuncs[iss]
+		if !ok {
+			return nil, fmt.Errorf("gcpjwt: unknown issuer `%s`", iss)
+		}
+
+		return keyFunc(token)
+	}
+}
+
+func tokenIssuer(claims jwt.Claims) (string, error) {
+	switch c := claims.(type) {
+	case jwt.MapClaims:
+		iss, _ := c["iss"].(string)
+		return iss, nil
+	case *jwt.StandardClaims:
+		return c.Issuer, nil
+	}
+
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	var std jwt.StandardClaims
+	if err := json.Unmarshal(b, &std); err != nil {
+		return "", err
+	}
+
+	return std.Issuer, nil
+}
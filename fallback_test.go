@@ -0,0 +1,115 @@
+package gcpjwt
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type failingMethod struct {
+	calls int
+}
+
+func (f *failingMethod) Alg() string { return "FAIL" }
+
+func (f *failingMethod) Verify(signingString, signature string, key interface{}) error {
+	return errors.New("failing method")
+}
+
+func (f *failingMethod) Sign(signingString string, key interface{}) (string, error) {
+	f.calls++
+	return "", errors.New("failing method")
+}
+
+func TestFallbackSigner_SignedString(t *testing.T) {
+	failing := &failingMethod{}
+	secret := []byte("secret")
+	signer := NewFallbackSigner(
+		&FallbackBackend{Method: failing, Issuer: "primary", KeyID: "a"},
+		&FallbackBackend{Method: jwt.SigningMethodHS256, Key: secret, Issuer: "secondary", KeyID: "b"},
+	)
+	signer.FailureThreshold = 2
+	signer.Cooldown = 50 * time.Millisecond
+
+	keyFunc := MultiVerifyKeyfunc(map[string]jwt.Keyfunc{
+		"secondary": func(token *jwt.Token) (interface{}, error) {
+			return secret, nil
+		},
+	})
+
+	for i := 0; i < 4; i++ {
+		tokenString, err := signer.SignedString(jwt.MapClaims{"sub": "test"})
+		if err != nil {
+			t.Fatalf("FallbackSigner.SignedString() error = %v", err)
+		}
+
+		claims := jwt.MapClaims{}
+		token, err := jwt.ParseWithClaims(tokenString, claims, keyFunc)
+		if err != nil || !token.Valid {
+			t.Fatalf("could not verify token: %v", err)
+		}
+		if token.Header["kid"] != "b" || claims["iss"] != "secondary" || claims["sub"] != "test" {
+			t.Errorf("unexpected token header %v or claims %v", token.Header, claims)
+		}
+	}
+
+	if failing.calls != 2 {
+		t.Errorf("expected the failing backend to be tried %d times, got %d", 2, failing.calls)
+	}
+	if states := signer.States(); states[0] != CircuitOpen || states[1] != CircuitClosed {
+		t.Errorf("unexpected circuit states %v", states)
+	}
+
+	// After the cooldown a single trial call is let through
+	time.Sleep(signer.Cooldown)
+	if _, err := signer.SignedString(jwt.MapClaims{}); err != nil {
+		t.Fatalf("FallbackSigner.SignedString() error = %v", err)
+	}
+	if failing.calls != 3 {
+		t.Errorf("expected a trial call after the cooldown, got %d calls", failing.calls)
+	}
+	if states := signer.States(); states[0] != CircuitOpen {
+		t.Errorf("expected circuit to re-open after a failed trial, got %v", states[0])
+	}
+}
+
+func TestFallbackSigner_AllFailed(t *testing.T) {
+	signer := NewFallbackSigner(&FallbackBackend{Method: &failingMethod{}})
+	signer.FailureThreshold = 1
+
+	if _, err := signer.SignedString(jwt.MapClaims{}); err == nil || err == ErrCircuitOpen {
+		t.Errorf("FallbackSigner.SignedString() expected backend error, got %v", err)
+	}
+	if _, err := signer.SignedString(jwt.MapClaims{}); err != ErrCircuitOpen {
+		t.Errorf("FallbackSigner.SignedString() error = %v, want %v", err, ErrCircuitOpen)
+	}
+}
+
+func TestMultiVerifyKeyfunc(t *testing.T) {
+	keyFunc := MultiVerifyKeyfunc(map[string]jwt.Keyfunc{
+		"known": func(token *jwt.Token) (interface{}, error) {
+			return "key", nil
+		},
+	})
+
+	tests := []struct {
+		name    string
+		claims  jwt.Claims
+		wantErr bool
+	}{
+		{"MapClaims", jwt.MapClaims{"iss": "known"}, false},
+		{"StandardClaims", &jwt.StandardClaims{Issuer: "known"}, false},
+		{"UnknownIssuer", jwt.MapClaims{"iss": "unknown"}, true},
+		{"MissingIssuer", jwt.MapClaims{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keyFunc(&jwt.Token{Claims: tt.claims})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MultiVerifyKeyfunc() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package gcpjwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthInterval = time.Minute
+)
+
+var (
+	// ErrNotReady is returned by HealthChecker.Warm when the keys of a source could not be loaded
+	ErrNotReady = errors.New("gcpjwt: verification keys are not loaded")
+)
+
+// HealthSource is a source of verification keys (certificates, JWK sets or KMS public keys) tracked by a
+// HealthChecker.
+type HealthSource struct {
+	// Name identifies the source in the HealthStatus
+	Name string
+
+	// Refresh loads the keys and returns when the cached keys expire. A zero time means the keys are not cached and
+	// the source is only ready while refreshes succeed.
+	Refresh func(ctx context.Context) (time.Time, error)
+
+	loaded      bool
+	lastRefresh time.Time
+	expires     time.Time
+	err         error
+
+	sync.RWMutex
+}
+
+// IAMHealthSource returns a HealthSource pre-warming the certificates of the config's ServiceAccount used by
+// IAMVerfiyKeyfunc. The config must have EnableCache set for the certificates to be retained.
+func IAMHealthSource(config *IAMConfig) *HealthSource {
+	return &HealthSource{
+		Name: "iam:" + config.ServiceAccount,
+		Refresh: func(ctx context.Context) (time.Time, error) {
+			if _, err := getCertificates(ctx, config); err != nil {
+				return time.Time{}, err
+			}
+			return cachedUntil(config.ServiceAccount), nil
+		},
+	}
+}
+
+// AppEngineHealthSource returns a HealthSource pre-warming the AppEngine certificates used by AppEngineVerfiyKeyfunc
+// with the same arguments.
+func AppEngineHealthSource(enableCache bool, cacheExpiration time.Duration) *HealthSource {
+	config := &IAMConfig{
+		EnableCache:     enableCache,
+		CacheExpiration: cacheExpiration,
+	}
+	return &HealthSource{
+		Name: "appengine",
+		Refresh: func(ctx context.Context) (time.Time, error) {
+			if _, err := getAppEngineCertificates(ctx, config); err != nil {
+				return time.Time{}, err
+			}
+			return cachedUntil(appEngineSvcAcct), nil
+		},
+	}
+}
+
+// IAPHealthSource returns a HealthSource pre-warming the IAP public keys used by IAPVerifyKeyfunc.
+func IAPHealthSource(config *IAPConfig) *HealthSource {
+	return &HealthSource{
+		Name: "iap",
+		Refresh: func(ctx context.Context) (time.Time, error) {
+			if _, err := getIAPKeys(ctx, config); err != nil {
+				return time.Time{}, err
+			}
+			return cachedUntil(iapKeysURL), nil
+		},
+	}
+}
+
+// GoogleIDTokenHealthSource returns a HealthSource pre-warming Google's public keys used by
+// GoogleIDTokenVerifyKeyfunc.
+func GoogleIDTokenHealthSource(config *GoogleIDTokenConfig) *HealthSource {
+	return &HealthSource{
+		Name: "google-id-token",
+		Refresh: func(ctx context.Context) (time.Time, error) {
+			_, err := getJWKSetKeys(ctx, googleOIDCKeysURL, "", config.Client, config.EnableCache, config.CacheExpiration)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return cachedUntil(googleOIDCKeysURL), nil
+		},
+	}
+}
+
+// KMSHealthSource returns a HealthSource pre-warming the public key of the config's KeyPath used by
+// KMSVerfiyKeyfunc. The public key of a key version never changes so it is only retrieved until it succeeds once.
+func KMSHealthSource(config *KMSConfig) *HealthSource {
+	return &HealthSource{
+		Name: "kms:" + config.KeyPath,
+		Refresh: func(ctx context.Context) (time.Time, error) {
+			// Local keys are derived when verifying
+			if local, err := useLocalMode(config.LocalMode); err != nil || local {
+				return time.Time{}, err
+			}
+
+			if _, err := cachedKMSPublicKey(ctx, config); err != nil {
+				return time.Time{}, err
+			}
+			return time.Time{}, nil
+		},
+	}
+}
+
+// refresh calls Refresh and records its outcome
+func (s *HealthSource) refresh(ctx context.Context) error {
+	expires, err := s.Refresh(ctx)
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.err = err
+	if err != nil {
+		return err
+	}
+	s.loaded = true
+	s.lastRefresh = time.Now()
+	s.expires = expires
+	return nil
+}
+
+// SourceStatus is the health of a HealthSource.
+type SourceStatus struct {
+	Name string `json:"name"`
+
+	// Ready is true when the keys are loaded and, if cached, not expired
+	Ready bool `json:"ready"`
+
+	// Stale is true when the last refresh failed but the previously cached keys have not expired
+	Stale bool `json:"stale"`
+
+	// LastRefresh is the time of the last successful refresh
+	LastRefresh time.Time `json:"lastRefresh"`
+
+	// Expires is when the cached keys expire, zero if they are not cached
+	Expires time.Time `json:"expires"`
+
+	// ExpiresIn is the time left until Expires
+	ExpiresIn time.Duration `json:"expiresIn"`
+
+	// Error is the error of the last refresh, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+func (s *HealthSource) status(now time.Time) SourceStatus {
+	s.RLock()
+	defer s.RUnlock()
+
+	status := SourceStatus{
+		Name:        s.Name,
+		LastRefresh: s.lastRefresh,
+		Expires:     s.expires,
+	}
+	if s.err != nil {
+		status.Error = s.err.Error()
+	}
+	if !s.loaded {
+		return status
+	}
+
+	if s.expires.IsZero() {
+		status.Ready = s.err == nil
+	} else if s.expires.After(now) {
+		status.Ready = true
+		status.ExpiresIn = s.expires.Sub(now)
+	}
+	status.Stale = status.Ready && s.err != nil
+
+	return status
+}
+
+// HealthStatus is the health of every source of a HealthChecker, ready only when all of them are.
+type HealthStatus struct {
+	Ready   bool           `json:"ready"`
+	Sources []SourceStatus `json:"sources"`
+}
+
+// HealthChecker pre-warms and periodically refreshes the keys used to verify tokens, reporting whether verification
+// will work. It is an http.Handler responding 200 when every source is ready and 503 otherwise, with the HealthStatus
+// as JSON, meant to be used as a readiness probe.
+type HealthChecker struct {
+	// Sources are the verification key sources to check
+	Sources []*HealthSource
+
+	// Interval between refreshes by Run, defaults to 1 minute. It should be lower than the cache expiration of the
+	// keys for them to be refreshed before they expire.
+	Interval time.Duration
+}
+
+// NewHealthChecker returns a HealthChecker for the sources.
+func NewHealthChecker(sources ...*HealthSource) *HealthChecker {
+	return &HealthChecker{Sources: sources}
+}
+
+// Warm refreshes every source concurrently, returning ErrNotReady along with the first error if any source failed.
+func (h *HealthChecker) Warm(ctx context.Context) error {
+	errs := make([]error, len(h.Sources))
+
+	var wg sync.WaitGroup
+	for i, source := range h.Sources {
+		wg.Add(1)
+		go func(i int, source *HealthSource) {
+			defer wg.Done()
+			errs[i] = source.refresh(ctx)
+		}(i, source)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrNotReady, h.Sources[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// Run warms the sources then refreshes them every Interval until the context is done.
+func (h *HealthChecker) Run(ctx context.Context) {
+	interval := h.Interval
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Failures are reported by Status
+		h.Warm(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status returns the current health of the sources.
+func (h *HealthChecker) Status() *HealthStatus {
+	now := time.Now()
+	status := &HealthStatus{
+		Ready:   true,
+		Sources: make([]SourceStatus, 0, len(h.Sources)),
+	}
+	for _, source := range h.Sources {
+		sourceStatus := source.status(now)
+		status.Ready = status.Ready && sourceStatus.Ready
+		status.Sources = append(status.Sources, sourceStatus)
+	}
+	return status
+}
+
+// Ready reports whether every source has its keys loaded.
+func (h *HealthChecker) Ready() bool {
+	return h.Status().Ready
+}
+
+// ServeHTTP writes the HealthStatus as JSON, with a 503 status code when not ready.
+func (h *HealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := h.Status()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,140 @@
+package gcpjwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+func TestHealthChecker(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int
+	iapConfig := &IAPConfig{
+		EnableCache: true,
+		Client:      iapTestClient(t, map[string]*ecdsa.PrivateKey{"key-1": key}, &fetches),
+	}
+	certsCache.Delete(iapKeysURL)
+	defer certsCache.Delete(iapKeysURL)
+
+	var (
+		refreshErr error
+		expires    time.Time
+	)
+	cached := &HealthSource{
+		Name: "cached",
+		Refresh: func(ctx context.Context) (time.Time, error) {
+			return expires, refreshErr
+		},
+	}
+
+	checker := NewHealthChecker(IAPHealthSource(iapConfig), cached)
+	serve := func() (int, *HealthStatus) {
+		rec := httptest.NewRecorder()
+		checker.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		status := &HealthStatus{}
+		if err := json.NewDecoder(rec.Body).Decode(status); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, status
+	}
+
+	if code, status := serve(); code != http.StatusServiceUnavailable || status.Ready {
+		t.Errorf("ServeHTTP() before warming = %d %+v, want %d", code, status, http.StatusServiceUnavailable)
+	}
+
+	expires = time.Now().Add(time.Hour)
+	if err := checker.Warm(ctx); err != nil {
+		t.Fatalf("Warm() error = %v", err)
+	}
+	if fetches != 1 {
+		t.Errorf("IAP keys fetched %d times, want 1", fetches)
+	}
+
+	code, status := serve()
+	if code != http.StatusOK || !status.Ready || len(status.Sources) != 2 {
+		t.Fatalf("ServeHTTP() = %d %+v, want %d", code, status, http.StatusOK)
+	}
+	iap := status.Sources[0]
+	if !iap.Ready || iap.Stale || iap.LastRefresh.IsZero() || iap.ExpiresIn <= 0 || iap.ExpiresIn > time.Hour {
+		t.Errorf("IAP status = %+v", iap)
+	}
+
+	// The cached keys are still valid after a failed refresh
+	refreshErr = errors.New("unavailable")
+	if err := checker.Warm(ctx); err == nil {
+		t.Fatal("Warm() expected error")
+	}
+	if status := checker.Status(); !status.Ready || !status.Sources[1].Stale || status.Sources[1].Error == "" {
+		t.Errorf("Status() after failed refresh = %+v, want stale", status)
+	}
+
+	// Until they expire
+	cached.expires = time.Now().Add(-time.Second)
+	if code, status := serve(); code != http.StatusServiceUnavailable || status.Sources[1].Ready {
+		t.Errorf("ServeHTTP() after expiry = %d %+v, want %d", code, status, http.StatusServiceUnavailable)
+	}
+
+	// Sources without cache are only ready while refreshes succeed
+	expires = time.Time{}
+	refreshErr = nil
+	checker.Warm(ctx)
+	if !checker.Ready() {
+		t.Errorf("Ready() = false after successful refresh")
+	}
+	refreshErr = errors.New("unavailable")
+	checker.Warm(ctx)
+	if checker.Ready() {
+		t.Errorf("Ready() = true after failed refresh of an uncached source")
+	}
+}
+
+func TestKMSHealthSource(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeKMS(t)
+	fake.addKey(t, "health-key", &kmspb.CryptoKeyVersion{})
+
+	missing := NewHealthChecker(KMSHealthSource(&KMSConfig{KeyPath: "health-missing", KMSClient: client}))
+	if err := missing.Warm(ctx); !errors.Is(err, ErrNotReady) {
+		t.Errorf("Warm() error = %v, want %v", err, ErrNotReady)
+	}
+	if missing.Ready() {
+		t.Errorf("Ready() = true when the public key cannot be retrieved")
+	}
+
+	config := &KMSConfig{KeyPath: "health-key", KMSClient: client}
+	defer certsCache.Delete(kmsCacheKey(config.KeyPath))
+	checker := NewHealthChecker(KMSHealthSource(config))
+	if err := checker.Warm(ctx); err != nil {
+		t.Fatalf("Warm() error = %v", err)
+	}
+	if !checker.Ready() {
+		t.Errorf("Ready() = false after warming")
+	}
+
+	// The keyfunc uses the pre-warmed public key
+	fake.Lock()
+	calls := fake.calls["GetPublicKey"]
+	fake.Unlock()
+	if _, err := KMSVerfiyKeyfunc(ctx, config); err != nil {
+		t.Fatalf("KMSVerfiyKeyfunc() error = %v", err)
+	}
+	fake.Lock()
+	defer fake.Unlock()
+	if fake.calls["GetPublicKey"] != calls {
+		t.Errorf("GetPublicKey called again after warming")
+	}
+}
@@ -12,9 +12,10 @@ import (
 // SigningMethodIAM is the base implementation for the signBlob and signJwt IAM API JWT signing methods. Not to be used on
 // its own!
 type SigningMethodIAM struct {
-	alg      string
-	override string
-	sign     func(ctx context.Context, iamService *iamcredentials.Service, config *IAMConfig, signingString string) (string, error)
+	alg        string
+	defaultAlg string
+	override   string
+	sign       func(ctx context.Context, iamService *iamcredentials.Service, config *IAMConfig, signingString string) (string, error)
 }
 
 // Alg will return the JWT header algorithm identifier this method is configured for.
@@ -30,6 +31,26 @@ func (s *SigningMethodIAM) Override() {
 	})
 }
 
+// Overridden returns a copy of this signing method using the algorithm identifier it would have after calling
+// Override, without modifying this method or the global jwt-go registry. Use it along with a Registry.
+func (s *SigningMethodIAM) Overridden() *SigningMethodIAM {
+	method := *s
+	method.alg = s.override
+	return &method
+}
+
+// Restore will undo Override, resetting this method's algorithm identifier and restoring the default JWT
+// implementation if this method is still the one registered for it.
+func (s *SigningMethodIAM) Restore() {
+	restoreSigningMethod(s.override, s, jwt.SigningMethodRS256)
+	s.alg = s.defaultAlg
+}
+
+// returnsJWT reports whether the sign function returns a complete JWT instead of a signature (signJwt API)
+func (s *SigningMethodIAM) returnsJWT() bool {
+	return s.defaultAlg == SigningMethodIAMJWT.defaultAlg
+}
+
 // Sign implements the Sign method from jwt.SigningMethod. For this signing method, a valid context.Context must be
 // passed as the key containing a IAMConfig value.
 // NOTE: The HEADER IS IGNORED for the signJWT API as the API will add its own
@@ -50,6 +71,25 @@ func (s *SigningMethodIAM) Sign(signingString string, key interface{}) (string,
 		return "", ErrMissingConfig
 	}
 
+	if local, err := useLocalMode(config.LocalMode); err != nil {
+		return "", err
+	} else if local {
+		sig, kid, err := signLocalRS256(config.ServiceAccount, signingString, s.returnsJWT())
+		if err != nil {
+			return "", err
+		}
+
+		config.Lock()
+		defer config.Unlock()
+
+		config.lastKeyID = kid
+		return sig, nil
+	}
+
+	if err := config.RateLimit.wait(ctx, config.ServiceAccount); err != nil {
+		return "", err
+	}
+
 	// Use the user provided IAMService or generate our own
 	iamService := config.IAMService
 	if iamService == nil {
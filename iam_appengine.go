@@ -29,9 +29,10 @@ var (
 func init() {
 	SigningMethodAppEngine = &SigningMethodAppEngineImpl{
 		SigningMethodIAM: &SigningMethodIAM{
-			alg:      "AppEngine",
-			override: jwt.SigningMethodRS256.Alg(),
-			sign:     nil,
+			alg:        "AppEngine",
+			defaultAlg: "AppEngine",
+			override:   jwt.SigningMethodRS256.Alg(),
+			sign:       nil,
 		},
 	}
 	jwt.RegisterSigningMethod(SigningMethodAppEngine.Alg(), func() jwt.SigningMethod {
@@ -39,6 +40,29 @@ func init() {
 	})
 }
 
+// Override will override the default JWT implementation of the signing function with the AppEngine signing method.
+func (s *SigningMethodAppEngineImpl) Override() {
+	s.alg = s.override
+	jwt.RegisterSigningMethod(s.override, func() jwt.SigningMethod {
+		return s
+	})
+}
+
+// Overridden returns a copy of this signing method using the algorithm identifier it would have after calling
+// Override, without modifying this method or the global jwt-go registry. Use it along with a Registry.
+func (s *SigningMethodAppEngineImpl) Overridden() *SigningMethodAppEngineImpl {
+	return &SigningMethodAppEngineImpl{
+		SigningMethodIAM: s.SigningMethodIAM.Overridden(),
+	}
+}
+
+// Restore will undo Override, resetting this method's algorithm identifier and restoring the default JWT
+// implementation if this method is still the one registered for it.
+func (s *SigningMethodAppEngineImpl) Restore() {
+	restoreSigningMethod(s.override, s, jwt.SigningMethodRS256)
+	s.alg = s.defaultAlg
+}
+
 // Sign implements the Sign method from jwt.SigningMethod. For this signing method, a valid AppEngine context.Context
 // must be passed as the key.
 func (s *SigningMethodAppEngineImpl) Sign(signingString string, key interface{}) (string, error) {
@@ -51,6 +75,21 @@ func (s *SigningMethodAppEngineImpl) Sign(signingString string, key interface{})
 		return "", jwt.ErrInvalidKey
 	}
 
+	if local, err := useLocalMode(false); err != nil {
+		return "", err
+	} else if local {
+		sig, kid, err := signLocalRS256(appEngineSvcAcct, signingString, false)
+		if err != nil {
+			return "", err
+		}
+
+		s.Lock()
+		defer s.Unlock()
+
+		s.lastKeyID = kid
+		return sig, nil
+	}
+
 	keyName, signature, err := appengine.SignBytes(ctx, []byte(signingString))
 	if err != nil {
 		return "", err
@@ -94,6 +133,12 @@ func AppEngineVerfiyKeyfunc(ctx context.Context, enableCache bool, cacheExpirati
 }
 
 func getAppEngineCertificates(ctx context.Context, config *IAMConfig) (certificates, error) {
+	if local, err := useLocalMode(false); err != nil {
+		return nil, err
+	} else if local {
+		return localCertificates(appEngineSvcAcct)
+	}
+
 	if config.EnableCache {
 		if certsResp, ok := getCertsFromCache(appEngineSvcAcct); ok {
 			return certsResp, nil
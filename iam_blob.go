@@ -17,9 +17,10 @@ var (
 
 func init() {
 	SigningMethodIAMBlob = &SigningMethodIAM{
-		alg:      "IAMBlob",
-		sign:     signBlob,
-		override: jwt.SigningMethodRS256.Alg(),
+		alg:        "IAMBlob",
+		defaultAlg: "IAMBlob",
+		sign:       signBlob,
+		override:   jwt.SigningMethodRS256.Alg(),
 	}
 	jwt.RegisterSigningMethod(SigningMethodIAMBlob.Alg(), func() jwt.SigningMethod {
 		return SigningMethodIAMBlob
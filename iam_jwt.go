@@ -17,9 +17,10 @@ var (
 
 func init() {
 	SigningMethodIAMJWT = &SigningMethodIAM{
-		alg:      "IAMJWT", // NOT USED
-		sign:     signJwt,
-		override: jwt.SigningMethodRS256.Alg(),
+		alg:        "IAMJWT", // NOT USED
+		defaultAlg: "IAMJWT",
+		sign:       signJwt,
+		override:   jwt.SigningMethodRS256.Alg(),
 	}
 	jwt.RegisterSigningMethod(SigningMethodIAMJWT.Alg(), func() jwt.SigningMethod {
 		return SigningMethodIAMJWT
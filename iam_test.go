@@ -174,12 +174,14 @@ func TestSigningMethodIAM_Override(t *testing.T) {
 	}
 
 	SigningMethodIAMJWT.Override()
+	t.Cleanup(SigningMethodIAMJWT.Restore)
 	method = jwt.GetSigningMethod("RS256")
 	if method != SigningMethodIAMJWT {
 		t.Errorf("Expected method == `%T`, got `%T` instead", SigningMethodIAMJWT, method)
 	}
 
 	SigningMethodIAMBlob.Override()
+	t.Cleanup(SigningMethodIAMBlob.Restore)
 	method = jwt.GetSigningMethod("RS256")
 	if method != SigningMethodIAMBlob {
 		t.Errorf("Expected method == `%T`, got `%T` instead", SigningMethodIAMBlob, method)
@@ -0,0 +1,159 @@
+package gcpjwt
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// IAPHeader is the request header carrying the signed IAP assertion
+	IAPHeader = "x-goog-iap-jwt-assertion"
+
+	iapIssuer  = "https://cloud.google.com/iap"
+	iapKeysURL = "https://www.gstatic.com/iap/verify/public_key-jwk"
+)
+
+var (
+	// ErrInvalidIAPClaims is returned when a valid IAP assertion has an unexpected audience or issuer
+	ErrInvalidIAPClaims = errors.New("gcpjwt: unexpected IAP assertion audience or issuer")
+
+	// IAP assertions are always ES256, resolved without the global (possibly overridden) jwt-go registry
+	iapMethods = NewRegistry(jwt.SigningMethodES256)
+)
+
+// publicKeys is a map of key id -> public keys
+type publicKeys map[string]crypto.PublicKey
+
+// IAPConfig is used to verify the signed headers of requests behind Identity-Aware Proxy.
+// https://cloud.google.com/iap/docs/signed-headers-howto
+type IAPConfig struct {
+	// Audience is the expected `aud` claim, see IAPBackendServiceAudience and IAPAppEngineAudience
+	Audience string
+
+	// EnableCache will enable the in-memory caching of the IAP public keys.
+	// The cache will expire keys when an expiration is known or fallback to the configured CacheExpiration
+	EnableCache bool
+
+	// CacheExpiration is the default time to keep the keys in cache if no expiration time is provided
+	// Use a value of 0 to disable the expiration time fallback.
+	CacheExpiration time.Duration
+
+	// Client is a user provided *http.Client to use, http.DefaultClient is used otherwise
+	Client *http.Client
+}
+
+// IAPClaims are the claims of an IAP assertion.
+type IAPClaims struct {
+	jwt.StandardClaims
+	Email        string `json:"email"`
+	HostedDomain string `json:"hd,omitempty"`
+}
+
+// IAPBackendServiceAudience returns the audience of assertions for a Compute Engine or GKE backend service.
+func IAPBackendServiceAudience(projectNumber, backendServiceID string) string {
+	return fmt.Sprintf("/projects/%s/global/backendServices/%s", projectNumber, backendServiceID)
+}
+
+// IAPAppEngineAudience returns the audience of assertions for an App Engine app.
+func IAPAppEngineAudience(projectNumber, projectID string) string {
+	return fmt.Sprintf("/projects/%s/apps/%s", projectNumber, projectID)
+}
+
+func getIAPKeys(ctx context.Context, config *IAPConfig) (publicKeys, error) {
+	return getJWKSetKeys(ctx, iapKeysURL, "", config.Client, config.EnableCache, config.CacheExpiration)
+}
+
+// getJWKSetKeys gets the keys of the JWK set at the url, only those with the use if not empty, caching them when
+// enabled
+func getJWKSetKeys(ctx context.Context, url, use string, client *http.Client, enableCache bool, cacheExpiration time.Duration) (publicKeys, error) {
+	cacheKey := url
+	if use != "" {
+		cacheKey = url + "#" + use
+	}
+	if enableCache {
+		if keys, ok := getKeysFromCache(cacheKey); ok {
+			return keys, nil
+		}
+	}
+
+	if client == nil {
+		client = getDefaultClient(ctx)
+	}
+
+	b, expires, err := fetchKeys(client, url, cacheExpiration)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &JWKSet{}
+	if err := json.Unmarshal(b, set); err != nil {
+		return nil, err
+	}
+
+	keys := make(publicKeys)
+	for _, jwk := range set.Keys {
+		if use != "" && jwk.Use != use {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	if enableCache && !expires.IsZero() {
+		updateCache(cacheKey, keys, expires)
+	}
+
+	return keys, nil
+}
+
+// IAPVerifyKeyfunc is a helper that returns a jwt.Keyfunc selecting the IAP public key to verify an assertion with,
+// caching the keys when enabled.
+func IAPVerifyKeyfunc(ctx context.Context, config *IAPConfig) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodES256 {
+			return nil, fmt.Errorf("gcpjwt: unexpected signing method: %v", token.Header["alg"])
+		}
+
+		keys, err := getIAPKeys(ctx, config)
+		if err != nil {
+			return nil, fmt.Errorf("gcpjwt: could not get IAP keys: %v", err)
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("gcpjwt: could not find IAP key for key id `%s`", kid)
+		}
+
+		return key, nil
+	}
+}
+
+// VerifyIAPAssertion verifies an IAP assertion (see IAPHeader) and returns its claims. ErrInvalidIAPClaims is
+// returned for a valid assertion with an unexpected audience or issuer.
+func VerifyIAPAssertion(ctx context.Context, config *IAPConfig, assertion string) (*IAPClaims, error) {
+	claims := &IAPClaims{}
+	token, err := iapMethods.ParseWithClaims(assertion, claims, IAPVerifyKeyfunc(ctx, config))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("gcpjwt: invalid IAP assertion")
+	}
+
+	if !claims.VerifyAudience(config.Audience, true) || !claims.VerifyIssuer(iapIssuer, true) {
+		return nil, ErrInvalidIAPClaims
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,126 @@
+package gcpjwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// iapTestClient serves the JWK set of the keys for the IAP keys URL and counts requests
+func iapTestClient(t *testing.T, keys map[string]*ecdsa.PrivateKey, fetches *int) *http.Client {
+	set := &JWKSet{}
+	for kid, key := range keys {
+		jwk, err := NewJWK(&key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwk.KeyID = kid
+		set.Keys = append(set.Keys, jwk)
+	}
+	b, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.String() != iapKeysURL {
+			t.Errorf("unexpected request to %v", r.URL)
+		}
+		*fetches++
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Cache-Control": {"public, max-age=3600"}},
+			Body:       ioutil.NopCloser(strings.NewReader(string(b))),
+			Request:    r,
+		}, nil
+	})}
+}
+
+func TestVerifyIAPAssertion(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int
+	audience := IAPBackendServiceAudience("123", "456")
+	config := &IAPConfig{
+		Audience:    audience,
+		EnableCache: true,
+		Client:      iapTestClient(t, map[string]*ecdsa.PrivateKey{"key-1": key}, &fetches),
+	}
+	certsCache.Delete(iapKeysURL)
+	defer certsCache.Delete(iapKeysURL)
+
+	now := time.Now()
+	assertion := func(kid string, signingKey *ecdsa.PrivateKey, aud, iss string, exp time.Time) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, &IAPClaims{
+			StandardClaims: jwt.StandardClaims{
+				Audience:  aud,
+				Issuer:    iss,
+				Subject:   "accounts.google.com:1234",
+				IssuedAt:  now.Unix(),
+				ExpiresAt: exp.Unix(),
+			},
+			Email:        "user@example.com",
+			HostedDomain: "example.com",
+		})
+		token.Header["kid"] = kid
+		s, err := token.SignedString(signingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name      string
+		assertion string
+		wantErr   error
+		anyErr    bool
+	}{
+		{"Valid", assertion("key-1", key, audience, iapIssuer, now.Add(time.Minute)), nil, false},
+		{"WrongAudience", assertion("key-1", key, IAPAppEngineAudience("123", "app"), iapIssuer, now.Add(time.Minute)), ErrInvalidIAPClaims, true},
+		{"WrongIssuer", assertion("key-1", key, audience, "https://example.com", now.Add(time.Minute)), ErrInvalidIAPClaims, true},
+		{"Expired", assertion("key-1", key, audience, iapIssuer, now.Add(-time.Minute)), nil, true},
+		{"UnknownKeyID", assertion("key-2", key, audience, iapIssuer, now.Add(time.Minute)), nil, true},
+		{"WrongKey", assertion("key-1", unknownKey, audience, iapIssuer, now.Add(time.Minute)), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := VerifyIAPAssertion(context.Background(), config, tt.assertion)
+			if (err != nil) != tt.anyErr {
+				t.Fatalf("VerifyIAPAssertion() error = %v, wantErr %v", err, tt.anyErr)
+			}
+			if tt.wantErr != nil && err != tt.wantErr {
+				t.Fatalf("VerifyIAPAssertion() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (claims.Email != "user@example.com" || claims.HostedDomain != "example.com") {
+				t.Errorf("VerifyIAPAssertion() claims = %+v", claims)
+			}
+		})
+	}
+
+	if fetches != 1 {
+		t.Errorf("IAP keys fetched %d times, want 1 (cached)", fetches)
+	}
+}
@@ -0,0 +1,271 @@
+package gcpjwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	// ErrUnsupportedKey is returned when a public key or JWK is not an RSA, EC (P-256/P-384/P-521) or Ed25519 key
+	ErrUnsupportedKey = errors.New("gcpjwt: unsupported public key type")
+)
+
+// JWK is a JSON Web Key holding a public key.
+// https://tools.ietf.org/html/rfc7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+
+	// EC and OKP keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set.
+type JWKSet struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// Key returns the key with the provided key id, if any.
+func (s *JWKSet) Key(keyID string) *JWK {
+	for _, key := range s.Keys {
+		if key.KeyID == keyID {
+			return key
+		}
+	}
+	return nil
+}
+
+// NewJWK returns the JWK for an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+func NewJWK(publicKey crypto.PublicKey) (*JWK, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			KeyType: "RSA",
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return &JWK{
+			KeyType: "EC",
+			Curve:   key.Curve.Params().Name,
+			X:       base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			Y:       base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return &JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(key),
+		}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// PublicKey returns the *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey of the JWK.
+func (j *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("gcpjwt: invalid RSA exponent in JWK")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("gcpjwt: invalid EC point in JWK")
+		}
+		return key, nil
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, ErrUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("gcpjwt: invalid Ed25519 key size in JWK")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// Thumbprint returns the base64url encoded SHA-256 JWK Thumbprint of the key.
+// https://tools.ietf.org/html/rfc7638
+func (j *JWK) Thumbprint() (string, error) {
+	// Only the required members, in lexicographic order
+	var members interface{}
+	switch j.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.KeyType, j.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{j.Curve, j.KeyType, j.X, j.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Curve, j.KeyType, j.X}
+	default:
+		return "", ErrUnsupportedKey
+	}
+
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// KMSJWK returns the JWK of the configured KMS key version for the signing method, with its key id set to
+// config.KeyID(), e.g. to publish it in a JWK Set.
+func KMSJWK(ctx context.Context, config *KMSConfig, method *SigningMethodKMS) (*JWK, error) {
+	var publicKey crypto.PublicKey
+	if local, err := useLocalMode(config.LocalMode); err != nil {
+		return nil, err
+	} else if local {
+		key, err := localKey(method.override.Alg(), config.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		publicKey = key.Public()
+	} else if publicKey, err = KMSPublicKey(ctx, config); err != nil {
+		return nil, err
+	}
+
+	jwk, err := NewJWK(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	jwk.KeyID = config.KeyID()
+	jwk.Use = "sig"
+	jwk.Algorithm = method.override.Alg()
+	return jwk, nil
+}
+
+// IAMJWKSet returns the JWK Set of the public keys of the configured ServiceAccount, the keys tokens signed with the
+// IAM API are verified with, caching them when enabled.
+func IAMJWKSet(ctx context.Context, config *IAMConfig) (*JWKSet, error) {
+	certs, err := getCertificates(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &JWKSet{Keys: make([]*JWK, 0, len(certs))}
+	for kid, publicKey := range certs {
+		jwk, err := NewJWK(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		jwk.KeyID = kid
+		jwk.Use = "sig"
+		jwk.Algorithm = jwt.SigningMethodRS256.Alg()
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set, nil
+}
+
+// JWKSetConfig is used to verify tokens signed with the keys of a JWK Set published at a URL.
+type JWKSetConfig struct {
+	// URL of the JWK Set
+	URL string
+
+	// Use, if not empty, restricts the keys to those with the `use` parameter, e.g. "sig"
+	Use string
+
+	// EnableCache will enable the in-memory caching of the keys.
+	// The cache will expire keys when an expiration is known or fallback to the configured CacheExpiration
+	EnableCache bool
+
+	// CacheExpiration is the default time to keep the keys in cache if no expiration time is provided
+	// Use a value of 0 to disable the expiration time fallback.
+	CacheExpiration time.Duration
+
+	// Client is a user provided *http.Client to use, http.DefaultClient is used otherwise
+	Client *http.Client
+}
+
+// JWKSetVerifyKeyfunc is a helper that returns a jwt.Keyfunc selecting the key of the JWK Set with the token's `kid`
+// header, caching the keys when enabled. The signing method is not checked, parse tokens with a Registry of the
+// expected methods.
+func JWKSetVerifyKeyfunc(ctx context.Context, config *JWKSetConfig) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		keys, err := getJWKSetKeys(ctx, config.URL, config.Use, config.Client, config.EnableCache, config.CacheExpiration)
+		if err != nil {
+			return nil, fmt.Errorf("gcpjwt: could not get keys of `%s`: %v", config.URL, err)
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("gcpjwt: could not find key for key id `%s`", kid)
+		}
+
+		return key, nil
+	}
+}
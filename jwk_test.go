@@ -0,0 +1,134 @@
+package gcpjwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestJWK_Thumbprint(t *testing.T) {
+	// https://tools.ietf.org/html/rfc7638#section-3.1
+	jwk := &JWK{
+		KeyType: "RSA",
+		KeyID:   "2011-04-29",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknj" +
+			"hMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6q" +
+			"MQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awap" +
+			"JzKnqDKgw",
+		E: "AQAB",
+	}
+
+	got, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatalf("JWK.Thumbprint() error = %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("JWK.Thumbprint() = %v, want %v", got, want)
+	}
+}
+
+func TestNewJWK(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     crypto.PublicKey
+		kty     string
+		wantErr bool
+	}{
+		{"RSA", &rsaKey.PublicKey, "RSA", false},
+		{"EC", &ecKey.PublicKey, "EC", false},
+		{"Ed25519", edKey, "OKP", false},
+		{"Unsupported", "key", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwk, err := NewJWK(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewJWK() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if jwk.KeyType != tt.kty {
+				t.Errorf("NewJWK() kty = %v, want %v", jwk.KeyType, tt.kty)
+			}
+
+			got, err := jwk.PublicKey()
+			if err != nil {
+				t.Fatalf("JWK.PublicKey() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.key) {
+				t.Errorf("JWK.PublicKey() = %v, want %v", got, tt.key)
+			}
+		})
+	}
+}
+
+func TestLocalJWKs(t *testing.T) {
+	ctx := context.Background()
+	for _, env := range productionEnvVars {
+		t.Setenv(env, "")
+	}
+
+	kmsConfig := &KMSConfig{KeyPath: "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1", LocalMode: true}
+	tokenString, err := jwt.NewWithClaims(SigningMethodKMSES256, &jwt.StandardClaims{}).SignedString(NewKMSContext(ctx, kmsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := KMSJWK(ctx, kmsConfig, SigningMethodKMSES256)
+	if err != nil {
+		t.Fatalf("KMSJWK() error = %v", err)
+	}
+	if jwk.KeyID != kmsConfig.KeyID() || jwk.Algorithm != "ES256" || jwk.Use != "sig" {
+		t.Errorf("KMSJWK() = %+v", jwk)
+	}
+	publicKey, err := jwk.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) { return publicKey, nil }); err != nil {
+		t.Errorf("token does not verify with the KMS JWK: %v", err)
+	}
+
+	iamConfig := &IAMConfig{ServiceAccount: "local@project.iam.gserviceaccount.com", LocalMode: true}
+	set, err := IAMJWKSet(ctx, iamConfig)
+	if err != nil {
+		t.Fatalf("IAMJWKSet() error = %v", err)
+	}
+	certs, err := getCertificates(ctx, iamConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != len(certs) {
+		t.Fatalf("IAMJWKSet() has %d keys, want %d", len(set.Keys), len(certs))
+	}
+	for kid, cert := range certs {
+		key := set.Key(kid)
+		if key == nil || key.Algorithm != "RS256" {
+			t.Fatalf("IAMJWKSet() key `%s` = %+v", kid, key)
+		}
+		if publicKey, err := key.PublicKey(); err != nil || !reflect.DeepEqual(publicKey, cert) {
+			t.Errorf("IAMJWKSet() key `%s` does not match the certificate: %v", kid, err)
+		}
+	}
+}
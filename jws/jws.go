@@ -0,0 +1,227 @@
+// Package jws produces and verifies documents in the General JWS JSON Serialization (RFC 7515 section 7.2.1), so a
+// payload can carry several signatures made by independent keys, e.g. an IAM service account and a KMS HSM key.
+package jws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+var (
+	// ErrInvalidDocument is returned when a document is not a well formed General JWS JSON Serialization
+	ErrInvalidDocument = errors.New("gcpjwt/jws: invalid JWS JSON document")
+	// ErrPolicyNotSatisfied is returned when fewer distinct keys than required by the Policy verified
+	ErrPolicyNotSatisfied = errors.New("gcpjwt/jws: signature policy not satisfied")
+)
+
+// Policy is the number of distinct keys whose signatures must verify for a document to be valid. Any positive value k
+// requires signatures of at least k keys (k-of-n), a document with fewer signatures never satisfies it. Keys are told
+// apart by the `kid` protected header, or by keyfunc when there is one per signature: signatures without a `kid`
+// verified with a single keyfunc count as a single key.
+type Policy int
+
+const (
+	// RequireAll requires the signatures of every configured keyfunc and every signature of the document to verify,
+	// with distinct keys. NOTE: a single keyfunc cannot tell that signatures were removed from the document, provide
+	// one keyfunc per expected signature or require the expected number of keys with Policy(k).
+	RequireAll Policy = 0
+	// RequireAny requires at least one signature of the document to verify
+	RequireAny Policy = 1
+)
+
+// Signature is a signature entry of a General JWS JSON Serialization.
+type Signature struct {
+	Protected string                 `json:"protected"`
+	Header    map[string]interface{} `json:"header,omitempty"`
+	Signature string                 `json:"signature"`
+}
+
+// Document is a General JWS JSON Serialization.
+type Document struct {
+	Payload    string       `json:"payload"`
+	Signatures []*Signature `json:"signatures"`
+}
+
+// Signer produces one signature entry of a document with one of the package's signing methods (or any
+// jwt.SigningMethod). Methods returning complete JWTs, like SigningMethodIAMJWT, cannot sign arbitrary payloads.
+type Signer struct {
+	Method jwt.SigningMethod
+	Key    interface{}
+
+	// KeyID is set as the `kid` protected header if not empty
+	KeyID string
+}
+
+// NewIAMSigner returns a Signer using the signBlob IAM API with the service account configured in the IAMConfig.
+func NewIAMSigner(ctx context.Context, config *gcpjwt.IAMConfig) *Signer {
+	return &Signer{
+		Method: gcpjwt.SigningMethodIAMBlob,
+		Key:    gcpjwt.NewIAMContext(ctx, config),
+	}
+}
+
+// NewKMSSigner returns a Signer for the provided KMS signing method, with the config's KeyID as the `kid` header.
+func NewKMSSigner(ctx context.Context, method *gcpjwt.SigningMethodKMS, config *gcpjwt.KMSConfig) *Signer {
+	return &Signer{
+		Method: method,
+		Key:    gcpjwt.NewKMSContext(ctx, config),
+		KeyID:  config.KeyID(),
+	}
+}
+
+// Sign returns the payload signed by every signer as a General JWS JSON Serialization document.
+func Sign(payload []byte, signers ...*Signer) ([]byte, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("gcpjwt/jws: at least one signer is required")
+	}
+
+	doc := &Document{
+		Payload:    jwt.EncodeSegment(payload),
+		Signatures: make([]*Signature, 0, len(signers)),
+	}
+	for i, signer := range signers {
+		sig, err := signer.sign(doc.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("gcpjwt/jws: could not sign with signer %d: %v", i, err)
+		}
+		doc.Signatures = append(doc.Signatures, sig)
+	}
+
+	return json.Marshal(doc)
+}
+
+func (s *Signer) sign(payload string) (*Signature, error) {
+	header := map[string]interface{}{"alg": s.Method.Alg()}
+	if s.KeyID != "" {
+		header["kid"] = s.KeyID
+	}
+	b, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	protected := jwt.EncodeSegment(b)
+
+	sig, err := s.Method.Sign(strings.Join([]string{protected, payload}, "."), s.Key)
+	if err != nil {
+		return nil, err
+	}
+	// Signatures are base64url encoded and never contain a '.', complete JWTs do
+	if strings.Contains(sig, ".") {
+		return nil, fmt.Errorf("signing method `%s` does not produce detached signatures", s.Method.Alg())
+	}
+
+	return &Signature{Protected: protected, Signature: sig}, nil
+}
+
+// Verify verifies the signatures of a General JWS JSON Serialization document and returns its payload if enough of
+// them verified to satisfy the policy. keyFuncs[i] provides the key for the i-th signature, a single keyFunc is used
+// for all of them. The keyfuncs receive a *jwt.Token with the (merged protected and unprotected) header of the
+// signature and its signing method, so the package's keyfuncs (and Registry.Keyfunc) can be used.
+func Verify(document []byte, policy Policy, keyFuncs ...jwt.Keyfunc) ([]byte, error) {
+	doc := &Document{}
+	if err := json.Unmarshal(document, doc); err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidDocument, err)
+	}
+	if len(doc.Signatures) == 0 {
+		return nil, ErrInvalidDocument
+	}
+	if len(keyFuncs) != 1 && len(keyFuncs) != len(doc.Signatures) {
+		return nil, fmt.Errorf("gcpjwt/jws: got %d keyfuncs for %d signatures", len(keyFuncs), len(doc.Signatures))
+	}
+
+	payload, err := jwt.DecodeSegment(doc.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidDocument, err)
+	}
+
+	required := int(policy)
+	if required <= 0 {
+		required = len(doc.Signatures)
+		if len(keyFuncs) > required {
+			required = len(keyFuncs)
+		}
+	}
+	if required > len(doc.Signatures) {
+		return nil, fmt.Errorf("%w: %d keys required, the document has %d signatures", ErrPolicyNotSatisfied,
+			required, len(doc.Signatures))
+	}
+
+	// Duplicated signatures must not count twice
+	keys := make(map[string]bool, len(doc.Signatures))
+	var errs []string
+	for i, sig := range doc.Signatures {
+		keyFunc, keyID := keyFuncs[0], ""
+		if len(keyFuncs) > 1 {
+			keyFunc, keyID = keyFuncs[i], fmt.Sprintf("keyfunc:%d", i)
+		}
+		kid, err := verifySignature(doc.Payload, sig, keyFunc)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("signature %d: %v", i, err))
+			continue
+		}
+		if kid != "" {
+			keyID = "kid:" + kid
+		}
+		keys[keyID] = true
+	}
+
+	if len(keys) < required {
+		return nil, fmt.Errorf("%w: %d distinct keys of %d signatures verified, %d required (%s)", ErrPolicyNotSatisfied,
+			len(keys), len(doc.Signatures), required, strings.Join(errs, "; "))
+	}
+
+	return payload, nil
+}
+
+// verifySignature verifies a signature entry and returns its protected `kid` header, if any
+func verifySignature(payload string, sig *Signature, keyFunc jwt.Keyfunc) (string, error) {
+	b, err := jwt.DecodeSegment(sig.Protected)
+	if err != nil {
+		return "", err
+	}
+	protected := make(map[string]interface{})
+	if err := json.Unmarshal(b, &protected); err != nil {
+		return "", err
+	}
+
+	// The protected and unprotected header parameter names must be disjoint
+	header := make(map[string]interface{}, len(protected)+len(sig.Header))
+	for k, v := range protected {
+		header[k] = v
+	}
+	for k, v := range sig.Header {
+		if _, ok := header[k]; ok {
+			return "", fmt.Errorf("duplicate header parameter `%s`", k)
+		}
+		header[k] = v
+	}
+
+	alg, ok := protected["alg"].(string)
+	if !ok {
+		return "", errors.New("missing protected `alg` header")
+	}
+
+	token := &jwt.Token{
+		Header:    header,
+		Method:    jwt.GetSigningMethod(alg),
+		Signature: sig.Signature,
+	}
+	key, err := keyFunc(token)
+	if err != nil {
+		return "", err
+	}
+	// The keyfunc may resolve the signing method, see Registry.Keyfunc
+	if token.Method == nil {
+		return "", fmt.Errorf("unknown signing method `%s`", alg)
+	}
+
+	kid, _ := protected["kid"].(string)
+	return kid, token.Method.Verify(strings.Join([]string{sig.Protected, payload}, "."), sig.Signature, key)
+}
@@ -0,0 +1,162 @@
+package jws
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+func TestSignVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"approval":"wire","amount":1000000}`)
+	doc, err := Sign(payload,
+		&Signer{Method: jwt.SigningMethodRS256, Key: rsaKey, KeyID: "rsa"},
+		&Signer{Method: jwt.SigningMethodES256, Key: ecKey, KeyID: "ec"},
+		&Signer{Method: jwt.SigningMethodES256, Key: otherKey, KeyID: "other"},
+	)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	keys := map[string]interface{}{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}
+	byKeyID := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := keys[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown kid `%s`", kid)
+	}
+	key := func(key interface{}) jwt.Keyfunc {
+		return func(*jwt.Token) (interface{}, error) { return key, nil }
+	}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		keyFuncs []jwt.Keyfunc
+		wantErr  bool
+	}{
+		{"All", RequireAll, []jwt.Keyfunc{byKeyID}, true},
+		{"Any", RequireAny, []jwt.Keyfunc{byKeyID}, false},
+		{"TwoOfThree", Policy(2), []jwt.Keyfunc{byKeyID}, false},
+		{"ThreeOfThree", Policy(3), []jwt.Keyfunc{byKeyID}, true},
+		{"FourOfThree", Policy(4), []jwt.Keyfunc{key(&rsaKey.PublicKey), key(&ecKey.PublicKey), key(&otherKey.PublicKey)}, true},
+		{"AllRespective", RequireAll, []jwt.Keyfunc{key(&rsaKey.PublicKey), key(&ecKey.PublicKey), key(&otherKey.PublicKey)}, false},
+		{"AllWrongOrder", RequireAny, []jwt.Keyfunc{key(&ecKey.PublicKey), key(&otherKey.PublicKey), key(&rsaKey.PublicKey)}, true},
+		{"KeyfuncCount", RequireAny, []jwt.Keyfunc{byKeyID, byKeyID}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(doc, tt.policy, tt.keyFuncs...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != string(payload) {
+				t.Errorf("Verify() = %s, want %s", got, payload)
+			}
+		})
+	}
+
+	t.Run("Tampered", func(t *testing.T) {
+		d := &Document{}
+		if err := json.Unmarshal(doc, d); err != nil {
+			t.Fatal(err)
+		}
+		d.Payload = jwt.EncodeSegment([]byte(`{"approval":"wire","amount":9000000}`))
+		b, _ := json.Marshal(d)
+		if _, err := Verify(b, RequireAny, byKeyID); err == nil {
+			t.Errorf("Verify() expected error for tampered payload")
+		}
+	})
+
+	t.Run("DuplicateHeader", func(t *testing.T) {
+		d := &Document{}
+		if err := json.Unmarshal(doc, d); err != nil {
+			t.Fatal(err)
+		}
+		d.Signatures[0].Header = map[string]interface{}{"kid": "ec"}
+		d.Signatures = d.Signatures[:1]
+		b, _ := json.Marshal(d)
+		if _, err := Verify(b, RequireAll, byKeyID); err == nil {
+			t.Errorf("Verify() expected error for duplicate header parameter")
+		}
+	})
+
+	modified := func(modify func(d *Document)) []byte {
+		d := &Document{}
+		if err := json.Unmarshal(doc, d); err != nil {
+			t.Fatal(err)
+		}
+		modify(d)
+		b, err := json.Marshal(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	t.Run("Stripped", func(t *testing.T) {
+		stripped := modified(func(d *Document) {
+			d.Signatures = d.Signatures[:1]
+		})
+		if _, err := Verify(stripped, Policy(2), byKeyID); !errors.Is(err, ErrPolicyNotSatisfied) {
+			t.Errorf("Verify() error = %v, want %v", err, ErrPolicyNotSatisfied)
+		}
+		if _, err := Verify(stripped, RequireAll, key(&rsaKey.PublicKey), key(&ecKey.PublicKey), key(&otherKey.PublicKey)); err == nil {
+			t.Errorf("Verify() expected error with a keyfunc per expected signature")
+		}
+	})
+
+	t.Run("Duplicated", func(t *testing.T) {
+		duplicated := modified(func(d *Document) {
+			d.Signatures = []*Signature{d.Signatures[0], d.Signatures[0]}
+		})
+		if _, err := Verify(duplicated, Policy(2), byKeyID); !errors.Is(err, ErrPolicyNotSatisfied) {
+			t.Errorf("Verify() error = %v, want %v", err, ErrPolicyNotSatisfied)
+		}
+		if _, err := Verify(duplicated, RequireAll, byKeyID); !errors.Is(err, ErrPolicyNotSatisfied) {
+			t.Errorf("Verify() error = %v, want %v", err, ErrPolicyNotSatisfied)
+		}
+		if _, err := Verify(duplicated, RequireAll, key(&rsaKey.PublicKey), key(&rsaKey.PublicKey)); !errors.Is(err, ErrPolicyNotSatisfied) {
+			t.Errorf("Verify() error = %v, want %v", err, ErrPolicyNotSatisfied)
+		}
+	})
+
+	t.Run("Registry", func(t *testing.T) {
+		registry := gcpjwt.NewRegistry(jwt.SigningMethodES256)
+		// RS256 is not registered so only the EC signature can verify
+		if _, err := Verify(doc, Policy(2), registry.Keyfunc(byKeyID)); err == nil {
+			t.Errorf("Verify() expected error with RS256 missing from the registry")
+		}
+		if _, err := Verify(doc, RequireAny, registry.Keyfunc(byKeyID)); err != nil {
+			t.Errorf("Verify() error = %v", err)
+		}
+	})
+
+	t.Run("NoSigners", func(t *testing.T) {
+		if _, err := Sign(payload); err == nil {
+			t.Errorf("Sign() expected error without signers")
+		}
+	})
+}
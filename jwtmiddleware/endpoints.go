@@ -0,0 +1,112 @@
+package jwtmiddleware
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+const (
+	// EndpointUserInfoHeader is the header ESPv2 (Cloud Endpoints) and API Gateway forward the verified claims in
+	EndpointUserInfoHeader = "X-Endpoint-API-UserInfo"
+	// ForwardedAuthorizationHeader is the header ESPv2 and API Gateway forward the original Authorization header in
+	ForwardedAuthorizationHeader = "X-Forwarded-Authorization"
+)
+
+// WithForwardedIdentity trusts the identity forwarded by Cloud Endpoints (ESPv2) or API Gateway, which verified the
+// token before proxying the request: the claims are decoded from the X-Endpoint-API-UserInfo header instead of
+// verifying the Authorization header, which the proxy replaces. When reverify is true, the original token forwarded
+// in the X-Forwarded-Authorization header is verified again with the keyfunc instead.
+//
+// The issuer is still checked against the ServiceAccount, the audience only when provided to NewHandler as the host
+// is the proxy's. Without reverify, the service MUST only be reachable through the proxy as the headers are
+// otherwise trivially forged. Combined with WithDPoP, every request is rejected with 500.
+func WithForwardedIdentity(reverify bool) Option {
+	return func(o *options) {
+		o.forwardedIdentity = true
+		o.reverify = reverify
+	}
+}
+
+func forwardedIdentityHandler(keyFunc jwt.Keyfunc, config *gcpjwt.IAMConfig, audience string, o *options) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var (
+				claims jwt.MapClaims
+				err    error
+			)
+			if o.reverify {
+				claims, err = verifyForwardedAuthorization(r, keyFunc)
+			} else {
+				claims, err = decodeEndpointUserInfo(r.Header.Get(EndpointUserInfoHeader))
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			if (audience != "" && !claims.VerifyAudience(audience, true)) || !claims.VerifyIssuer(config.ServiceAccount, true) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			if !checkRevocation(w, r, o.revocations, claims) {
+				return
+			}
+
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+		})
+	}
+}
+
+// verifyForwardedAuthorization verifies the bearer token of the X-Forwarded-Authorization header
+func verifyForwardedAuthorization(r *http.Request, keyFunc jwt.Keyfunc) (jwt.MapClaims, error) {
+	authorization := r.Header.Get(ForwardedAuthorizationHeader)
+	if len(authorization) <= 7 || !strings.EqualFold(authorization[:7], "bearer ") {
+		return nil, fmt.Errorf("gcpjwt/jwtmiddleware: missing bearer token in %s", ForwardedAuthorizationHeader)
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(authorization[7:], claims, keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("gcpjwt/jwtmiddleware: invalid forwarded token")
+	}
+	return claims, nil
+}
+
+// decodeEndpointUserInfo decodes the claims of the X-Endpoint-API-UserInfo header, the base64url encoded JWT payload
+// for ESPv2 and API Gateway (ESP used padded standard base64)
+func decodeEndpointUserInfo(userInfo string) (jwt.MapClaims, error) {
+	if userInfo == "" {
+		return nil, fmt.Errorf("gcpjwt/jwtmiddleware: missing %s header", EndpointUserInfoHeader)
+	}
+
+	var (
+		payload []byte
+		err     error
+	)
+	for _, encoding := range []*base64.Encoding{base64.RawURLEncoding, base64.URLEncoding, base64.StdEncoding, base64.RawStdEncoding} {
+		if payload, err = encoding.DecodeString(userInfo); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gcpjwt/jwtmiddleware: could not decode %s header: %v", EndpointUserInfoHeader, err)
+	}
+
+	claims := jwt.MapClaims{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("gcpjwt/jwtmiddleware: could not decode %s header: %v", EndpointUserInfoHeader, err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,120 @@
+package jwtmiddleware
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+func TestForwardedIdentity(t *testing.T) {
+	ctx := context.Background()
+	const account = "caller@project.iam.gserviceaccount.com"
+	config := &gcpjwt.IAMConfig{ServiceAccount: account, LocalMode: true}
+
+	sign := func(claims *jwt.StandardClaims) string {
+		tokenString, err := jwt.NewWithClaims(gcpjwt.SigningMethodIAMBlob, claims).SignedString(gcpjwt.NewIAMContext(ctx, config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	validToken := sign(&jwt.StandardClaims{Issuer: account, Audience: "https://api.example.com", Subject: "user", ExpiresAt: exp})
+	otherToken := sign(&jwt.StandardClaims{Issuer: "other@project.iam.gserviceaccount.com", Audience: "https://api.example.com", ExpiresAt: exp})
+	userInfo := func(tokenString string) string {
+		return strings.Split(tokenString, ".")[1]
+	}
+
+	tests := []struct {
+		name       string
+		reverify   bool
+		audience   string
+		userInfo   string
+		forwarded  string
+		wantStatus int
+	}{
+		{"UserInfo", false, "", userInfo(validToken), "", http.StatusOK},
+		{"UserInfoPadded", false, "", base64.StdEncoding.EncodeToString([]byte(`{"iss":"` + account + `","sub":"user"}`)), "", http.StatusOK},
+		{"UserInfoAudience", false, "https://api.example.com", userInfo(validToken), "", http.StatusOK},
+		{"UserInfoWrongAudience", false, "https://other.example.com", userInfo(validToken), "", http.StatusForbidden},
+		{"UserInfoWrongIssuer", false, "", userInfo(otherToken), "", http.StatusForbidden},
+		{"UserInfoMissing", false, "", "", "Bearer " + validToken, http.StatusUnauthorized},
+		{"UserInfoInvalid", false, "", "not base64!", "", http.StatusUnauthorized},
+		{"Reverify", true, "", "", "Bearer " + validToken, http.StatusOK},
+		{"ReverifyIgnoresUserInfo", true, "", userInfo(validToken), "", http.StatusUnauthorized},
+		{"ReverifyTampered", true, "", "", "Bearer " + strings.Replace(validToken, ".", "."+userInfo(otherToken)[:4], 1), http.StatusUnauthorized},
+		{"ReverifyWrongIssuer", true, "", "", "Bearer " + otherToken, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims jwt.MapClaims
+			handler := NewHandler(ctx, config, tt.audience, WithForwardedIdentity(tt.reverify))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims, _ = ClaimsFromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "https://backend.run.app/", nil)
+			if tt.userInfo != "" {
+				r.Header.Set(EndpointUserInfoHeader, tt.userInfo)
+			}
+			if tt.forwarded != "" {
+				r.Header.Set(ForwardedAuthorizationHeader, tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && claims["sub"] != "user" {
+				t.Errorf("ClaimsFromContext() = %v", claims)
+			}
+		})
+	}
+}
+
+func TestWithRevocation(t *testing.T) {
+	ctx := context.Background()
+	const account = "caller@project.iam.gserviceaccount.com"
+	config := &gcpjwt.IAMConfig{ServiceAccount: account, LocalMode: true}
+
+	sign := func(id string) string {
+		tokenString, err := jwt.NewWithClaims(gcpjwt.SigningMethodIAMBlob, &jwt.StandardClaims{Id: id, Issuer: account, Audience: "https://api.example.com"}).
+			SignedString(gcpjwt.NewIAMContext(ctx, config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+	revocations := gcpjwt.NewMemoryRevocations(&gcpjwt.RevocationList{IDs: []string{"revoked"}})
+
+	tests := []struct {
+		name       string
+		checker    gcpjwt.RevocationChecker
+		id         string
+		wantStatus int
+	}{
+		{"Valid", revocations, "valid", http.StatusOK},
+		{"Revoked", revocations, "revoked", http.StatusUnauthorized},
+		{"Unavailable", gcpjwt.NewPollingRevocations("http://localhost/", nil, nil), "valid", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		handler := NewHandler(ctx, config, "https://api.example.com", WithRevocation(tt.checker))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		r := httptest.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+		r.Header.Set("Authorization", "Bearer "+sign(tt.id))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+	}
+}
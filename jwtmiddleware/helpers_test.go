@@ -166,6 +166,7 @@ func TestHelpers(t *testing.T) {
 		}
 		// Required for this to work
 		gcpjwt.SigningMethodIAMJWT.Override()
+		t.Cleanup(gcpjwt.SigningMethodIAMJWT.Restore)
 		for _, test := range tests {
 			t.Run(test.name, func(t *testing.T) {
 				w := httptest.NewRecorder()
@@ -0,0 +1,43 @@
+package jwtmiddleware
+
+import (
+	"context"
+	"net/http"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+type iapClaimsKey struct{}
+
+// NewIAPHandler will return a middleware that validates the signed header Identity-Aware Proxy adds to incoming HTTP
+// requests, checking the audience configured in the IAPConfig. The claims (email, sub, hd) are available to the
+// handler with IAPClaimsFromContext.
+func NewIAPHandler(ctx context.Context, config *gcpjwt.IAPConfig) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assertion := r.Header.Get(gcpjwt.IAPHeader)
+			if assertion == "" {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := gcpjwt.VerifyIAPAssertion(ctx, config, assertion)
+			if err == gcpjwt.ErrInvalidIAPClaims {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), iapClaimsKey{}, claims)))
+		})
+	}
+}
+
+// IAPClaimsFromContext returns the IAP claims of a request validated by the NewIAPHandler middleware.
+func IAPClaimsFromContext(ctx context.Context) (*gcpjwt.IAPClaims, bool) {
+	claims, ok := ctx.Value(iapClaimsKey{}).(*gcpjwt.IAPClaims)
+	return claims, ok
+}
@@ -0,0 +1,102 @@
+package jwtmiddleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestIAPHandler(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := gcpjwt.NewJWK(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk.KeyID = "key-1"
+	b, err := json.Marshal(&gcpjwt.JWKSet{Keys: []*gcpjwt.JWK{jwk}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audience := gcpjwt.IAPAppEngineAudience("123", "app")
+	config := &gcpjwt.IAPConfig{
+		Audience: audience,
+		Client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader(string(b))),
+				Request:    r,
+			}, nil
+		})},
+	}
+
+	assertion := func(aud string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, &gcpjwt.IAPClaims{
+			StandardClaims: jwt.StandardClaims{
+				Audience:  aud,
+				Issuer:    "https://cloud.google.com/iap",
+				Subject:   "accounts.google.com:1234",
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			},
+			Email: "user@example.com",
+		})
+		token.Header["kid"] = "key-1"
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	handler := NewIAPHandler(context.Background(), config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := IAPClaimsFromContext(r.Context())
+		if !ok || claims.Email != "user@example.com" || claims.Subject != "accounts.google.com:1234" {
+			t.Errorf("IAPClaimsFromContext() = %+v, %v", claims, ok)
+		}
+	}))
+
+	tests := []struct {
+		name      string
+		assertion string
+		want      int
+	}{
+		{"Valid", assertion(audience), http.StatusOK},
+		{"WrongAudience", assertion("/projects/123/apps/other"), http.StatusForbidden},
+		{"Missing", "", http.StatusUnauthorized},
+		{"Invalid", "invalid", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.assertion != "" {
+				req.Header.Set(gcpjwt.IAPHeader, tt.assertion)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("NewIAPHandler() status = %v, want %v", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -2,33 +2,102 @@ package jwtmiddleware
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/golang-jwt/jwt/request"
 
 	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+	"github.com/csmadhu/gcp-jwt-go/dpop"
 )
 
+// Option configures the middleware returned by NewHandler.
+type Option func(*options)
+
+type options struct {
+	dpop *dpop.Verifier
+
+	forwardedIdentity bool
+	reverify          bool
+
+	revocations gcpjwt.RevocationChecker
+}
+
+// WithRevocation rejects tokens revoked by the checker with 401, and all tokens with 503 when the checker fails (e.g.
+// when its revocation list is unavailable).
+func WithRevocation(checker gcpjwt.RevocationChecker) Option {
+	return func(o *options) {
+		o.revocations = checker
+	}
+}
+
+// WithDPoP requires DPoP-bound access tokens: the token is expected with the DPoP scheme in the Authorization header,
+// along with a DPoP proof verified by the Verifier whose key must match the `cnf.jkt` claim of the token.
+// Combined with WithForwardedIdentity, every request is rejected with 500 as proxies do not forward the proofs.
+func WithDPoP(verifier *dpop.Verifier) Option {
+	return func(o *options) {
+		o.dpop = verifier
+	}
+}
+
+// dpopExtractor extracts access tokens sent with the DPoP Authorization scheme
+type dpopExtractor struct{}
+
+func (dpopExtractor) ExtractToken(r *http.Request) (string, error) {
+	token, ok := dpop.AccessToken(r)
+	if !ok {
+		return "", request.ErrNoTokenInRequest
+	}
+	return token, nil
+}
+
 // NewHandler will return a middleware that will try and validate tokens in incoming HTTP requests.
 // The token is expected as a Bearer token in the Authorization header and expected to have an Issuer
 // claim equal to the ServiceAccount the provided IAMConfig is configured for. This will also validate the
 // Audience claim to the one provided, or use https:// + request.Host if blank. NOTE: If using the signJwt method,
 // you MUST call gcpjwt.SigningMethodIAMJWT.Override().
 //
+// The verified claims are available to the handler with ClaimsFromContext.
+//
 // Complimentary to https://github.com/someone1/gcp-jwt-go/oauth2
-func NewHandler(ctx context.Context, config *gcpjwt.IAMConfig, audience string) func(http.Handler) http.Handler {
+func NewHandler(ctx context.Context, config *gcpjwt.IAMConfig, audience string, opts ...Option) func(http.Handler) http.Handler {
 	ctx = gcpjwt.NewIAMContext(ctx, config)
 
 	keyFunc := gcpjwt.IAMVerfiyKeyfunc(ctx, config)
 
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var extractor request.Extractor = request.AuthorizationHeaderExtractor
+	if o.dpop != nil {
+		extractor = dpopExtractor{}
+	}
+
+	if o.forwardedIdentity {
+		// The proxy only forwards bearer tokens, without the proof binding them to the client's key
+		if o.dpop != nil {
+			return func(h http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				})
+			}
+		}
+		return forwardedIdentityHandler(keyFunc, config, audience, o)
+	}
+
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			claims := &jwt.StandardClaims{}
+			claims := &dpop.BoundClaims{}
 
-			token, err := request.ParseFromRequest(r, request.AuthorizationHeaderExtractor, keyFunc, request.WithClaims(claims))
+			token, err := request.ParseFromRequest(r, extractor, keyFunc, request.WithClaims(claims))
 			if err != nil || !token.Valid {
+				dpopChallenge(w, o.dpop, "invalid_token")
 				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 				return
 			}
@@ -43,7 +112,89 @@ func NewHandler(ctx context.Context, config *gcpjwt.IAMConfig, audience string)
 				return
 			}
 
-			h.ServeHTTP(w, r)
+			mapClaims, err := tokenMapClaims(token)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			if !checkRevocation(w, r, o.revocations, mapClaims) {
+				return
+			}
+
+			// The proof is verified last as it is recorded in the replay cache
+			if o.dpop != nil {
+				jkt, err := o.dpop.VerifyRequest(r, token.Raw)
+				if err != nil {
+					if errors.Is(err, dpop.ErrInvalidNonce) {
+						dpopChallenge(w, o.dpop, "use_dpop_nonce")
+					} else {
+						dpopChallenge(w, o.dpop, "invalid_dpop_proof")
+					}
+					http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+					return
+				}
+
+				if claims.Confirmation == nil || claims.Confirmation.JWKThumbprint != jkt {
+					dpopChallenge(w, o.dpop, "invalid_token")
+					http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+					return
+				}
+			}
+
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, mapClaims)))
 		})
 	}
 }
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of a request validated by the NewHandler middleware, whether the token was
+// verified directly or forwarded by Cloud Endpoints / API Gateway.
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(jwt.MapClaims)
+	return claims, ok
+}
+
+// checkRevocation writes the error response and returns false if the token is revoked or the check failed
+func checkRevocation(w http.ResponseWriter, r *http.Request, checker gcpjwt.RevocationChecker, claims jwt.MapClaims) bool {
+	err := gcpjwt.CheckRevocation(r.Context(), checker, claims)
+	switch {
+	case err == gcpjwt.ErrTokenRevoked:
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// tokenMapClaims decodes all the claims of a verified token, regardless of the jwt.Claims it was parsed with
+func tokenMapClaims(token *jwt.Token) (jwt.MapClaims, error) {
+	parts := strings.Split(token.Raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("gcpjwt/jwtmiddleware: expected a 3 part token, got %d parts", len(parts))
+	}
+	payload, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// dpopChallenge sets the WWW-Authenticate (and DPoP-Nonce) headers of an error response when DPoP is required
+func dpopChallenge(w http.ResponseWriter, verifier *dpop.Verifier, code string) {
+	if verifier == nil {
+		return
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`%s error="%s"`, dpop.AuthorizationScheme, code))
+	if verifier.Nonce != nil {
+		w.Header().Set(dpop.NonceHeaderName, verifier.Nonce())
+	}
+}
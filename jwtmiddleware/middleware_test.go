@@ -0,0 +1,124 @@
+package jwtmiddleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+	"github.com/csmadhu/gcp-jwt-go/dpop"
+)
+
+// countingReplayCache records the proofs checked for replay
+type countingReplayCache struct {
+	dpop.ReplayCache
+	seen int
+}
+
+func (c *countingReplayCache) Seen(jti string, expires time.Time) bool {
+	c.seen++
+	return c.ReplayCache.Seen(jti, expires)
+}
+
+func TestDPoP(t *testing.T) {
+	ctx := context.Background()
+	const (
+		account  = "caller@project.iam.gserviceaccount.com"
+		audience = "https://api.example.com"
+		uri      = "https://api.example.com/resource"
+	)
+	config := &gcpjwt.IAMConfig{ServiceAccount: account, LocalMode: true}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := gcpjwt.NewJWK(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jkt, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var proofs int
+	proof := func(accessToken string) string {
+		proofs++
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, &dpop.ProofClaims{
+			ID:              strconv.Itoa(proofs),
+			HTTPMethod:      http.MethodGet,
+			HTTPURI:         uri,
+			IssuedAt:        time.Now().Unix(),
+			AccessTokenHash: dpop.AccessTokenHash(accessToken),
+		})
+		token.Header["typ"] = "dpop+jwt"
+		token.Header["jwk"] = jwk
+		p, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	accessToken := func(aud string) string {
+		tokenString, err := jwt.NewWithClaims(gcpjwt.SigningMethodIAMBlob, &dpop.BoundClaims{
+			StandardClaims: jwt.StandardClaims{Issuer: account, Audience: aud, ExpiresAt: time.Now().Add(time.Hour).Unix()},
+			Confirmation:   &dpop.Confirmation{JWKThumbprint: jkt},
+		}).SignedString(gcpjwt.NewIAMContext(ctx, config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+
+	cache := &countingReplayCache{ReplayCache: dpop.NewMemoryReplayCache()}
+	handler := NewHandler(ctx, config, audience, WithDPoP(&dpop.Verifier{ReplayCache: cache}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(token, proof string) int {
+		r := httptest.NewRequest(http.MethodGet, uri, nil)
+		r.Header.Set("Authorization", dpop.AuthorizationScheme+" "+token)
+		r.Header.Set(dpop.HeaderName, proof)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	wrongAudience := accessToken("https://other.example.com")
+	if code := serve(wrongAudience, proof(wrongAudience)); code != http.StatusForbidden {
+		t.Errorf("wrong audience status = %d, want %d", code, http.StatusForbidden)
+	}
+	if cache.seen != 0 {
+		t.Errorf("the proof of a token for the wrong audience was recorded in the replay cache")
+	}
+
+	valid := accessToken(audience)
+	validProof := proof(valid)
+	if code := serve(valid, validProof); code != http.StatusOK {
+		t.Errorf("valid status = %d, want %d", code, http.StatusOK)
+	}
+	if code := serve(valid, validProof); code != http.StatusUnauthorized {
+		t.Errorf("replayed proof status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestDPoPForwardedIdentity(t *testing.T) {
+	config := &gcpjwt.IAMConfig{ServiceAccount: "caller@project.iam.gserviceaccount.com", LocalMode: true}
+	handler := NewHandler(context.Background(), config, "", WithDPoP(&dpop.Verifier{}), WithForwardedIdentity(false))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("handler called without DPoP")
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "https://api.example.com/resource", nil)
+	r.Header.Set(EndpointUserInfoHeader, "eyJpc3MiOiJjYWxsZXJAcHJvamVjdC5pYW0uZ3NlcnZpY2VhY2NvdW50LmNvbSJ9")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,65 @@
+package jwtmiddleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/request"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+type pushClaimsKey struct{}
+
+// NewPushHandler will return a middleware that authenticates Pub/Sub, Cloud Tasks and Cloud Scheduler push requests.
+// The Google-signed OIDC token is expected as a Bearer token in the Authorization header, with an Audience claim
+// equal to the one provided, or https:// + request.Host + request.RequestURI if blank (the push endpoint URL), and a
+// verified email claim equal to one of the invoker service accounts.
+//
+// Invalid tokens are rejected with 401 and tokens for another audience or account with 403, both are retried by
+// Pub/Sub.
+func NewPushHandler(ctx context.Context, config *gcpjwt.GoogleIDTokenConfig, audience string, serviceAccounts ...string) func(http.Handler) http.Handler {
+	accounts := make(map[string]bool, len(serviceAccounts))
+	for _, account := range serviceAccounts {
+		accounts[account] = true
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idToken, err := request.AuthorizationHeaderExtractor.ExtractToken(r)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			aud := audience
+			if aud == "" {
+				aud = fmt.Sprintf("https://%s%s", r.Host, r.URL.RequestURI())
+			}
+
+			claims, err := gcpjwt.VerifyGoogleIDToken(ctx, config, idToken, aud)
+			if err == gcpjwt.ErrInvalidGoogleIDTokenClaims {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			if !claims.EmailVerified || !accounts[claims.Email] {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pushClaimsKey{}, claims)))
+		})
+	}
+}
+
+// PushClaimsFromContext returns the ID token claims of a push request validated by the NewPushHandler middleware.
+func PushClaimsFromContext(ctx context.Context) (*gcpjwt.GoogleIDTokenClaims, bool) {
+	claims, ok := ctx.Value(pushClaimsKey{}).(*gcpjwt.GoogleIDTokenClaims)
+	return claims, ok
+}
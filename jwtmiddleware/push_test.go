@@ -0,0 +1,101 @@
+package jwtmiddleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+func TestPushHandler(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := gcpjwt.NewJWK(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk.KeyID = "google-1"
+	b, err := json.Marshal(&gcpjwt.JWKSet{Keys: []*gcpjwt.JWK{jwk}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &gcpjwt.GoogleIDTokenConfig{
+		Client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader(string(b))),
+				Request:    r,
+			}, nil
+		})},
+	}
+
+	const invoker = "push@project.iam.gserviceaccount.com"
+	const endpoint = "https://example.com/push?token=1"
+	idToken := func(aud, iss, email string, verified bool) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, &gcpjwt.GoogleIDTokenClaims{
+			StandardClaims: jwt.StandardClaims{
+				Audience:  aud,
+				Issuer:    iss,
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			},
+			Email:         email,
+			EmailVerified: verified,
+		})
+		token.Header["kid"] = "google-1"
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := PushClaimsFromContext(r.Context()); !ok || claims.Email != invoker {
+			t.Errorf("PushClaimsFromContext() = %+v, %v", claims, ok)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		audience string
+		token    string
+		want     int
+	}{
+		{"Valid", "", idToken(endpoint, "https://accounts.google.com", invoker, true), http.StatusOK},
+		{"ValidIssuer", "aud", idToken("aud", "accounts.google.com", invoker, true), http.StatusOK},
+		{"WrongAudience", "", idToken("https://example.com/other", "https://accounts.google.com", invoker, true), http.StatusForbidden},
+		{"WrongIssuer", "aud", idToken("aud", "https://example.com", invoker, true), http.StatusForbidden},
+		{"WrongAccount", "aud", idToken("aud", "https://accounts.google.com", "other@project.iam.gserviceaccount.com", true), http.StatusForbidden},
+		{"Unverified", "aud", idToken("aud", "https://accounts.google.com", invoker, false), http.StatusForbidden},
+		{"Invalid", "aud", "invalid", http.StatusUnauthorized},
+		{"Missing", "aud", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewPushHandler(context.Background(), config, tt.audience, invoker)(next)
+
+			req := httptest.NewRequest(http.MethodPost, endpoint, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("NewPushHandler() status = %v, want %v", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -39,6 +39,9 @@ var (
 	// SigningMethodKMSES384 leverages Cloud KMS for the ES256 algorithm, use with:
 	// EC_SIGN_P384_SHA384
 	SigningMethodKMSES384 *SigningMethodKMS
+	// SigningMethodKMSEdDSA leverages Cloud KMS for the EdDSA algorithm, use with:
+	// EC_SIGN_ED25519
+	SigningMethodKMSEdDSA *SigningMethodKMS
 )
 
 func init() {
@@ -81,6 +84,16 @@ func init() {
 	jwt.RegisterSigningMethod(SigningMethodKMSES384.Alg(), func() jwt.SigningMethod {
 		return SigningMethodKMSES384
 	})
+
+	// EdDSA - Ed25519 signs the data itself rather than a digest
+	SigningMethodKMSEdDSA = &SigningMethodKMS{
+		"KMSEdDSA",
+		jwt.SigningMethodEdDSA,
+		0,
+	}
+	jwt.RegisterSigningMethod(SigningMethodKMSEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodKMSEdDSA
+	})
 }
 
 // Alg will return the JWT header algorithm identifier this method is configured for.
@@ -96,7 +109,22 @@ func (s *SigningMethodKMS) Override() {
 	})
 }
 
-// Hash will return the crypto.Hash used for this signing method
+// Overridden returns a copy of this signing method using the algorithm identifier it would have after calling
+// Override, without modifying this method or the global jwt-go registry. Use it along with a Registry.
+func (s *SigningMethodKMS) Overridden() *SigningMethodKMS {
+	method := *s
+	method.alg = s.override.Alg()
+	return &method
+}
+
+// Restore will undo Override, resetting this method's algorithm identifier and restoring the default JWT
+// implementation if this method is still the one registered for it.
+func (s *SigningMethodKMS) Restore() {
+	restoreSigningMethod(s.override.Alg(), s, s.override)
+	s.alg = "KMS" + s.override.Alg()
+}
+
+// Hash will return the crypto.Hash used for this signing method, 0 if the data is signed without being hashed first
 func (s *SigningMethodKMS) Hash() crypto.Hash {
 	return s.hasher
 }
@@ -121,6 +149,26 @@ func (s *SigningMethodKMS) Sign(signingString string, key interface{}) (string,
 		return "", ErrMissingConfig
 	}
 
+	if local, err := useLocalMode(config.LocalMode); err != nil {
+		return "", err
+	} else if local {
+		key, err := localKey(s.override.Alg(), config.KeyPath)
+		if err != nil {
+			return "", err
+		}
+		return s.override.Sign(signingString, key)
+	}
+
+	if err := config.Preflight.check(ctx, config, s); err != nil {
+		return "", err
+	}
+
+	asymmetricSignRequest := &kmspb.AsymmetricSignRequest{}
+	if s.hasher == 0 {
+		asymmetricSignRequest.Data = []byte(signingString)
+		return signKMS(ctx, config, asymmetricSignRequest, nil)
+	}
+
 	if !s.hasher.Available() {
 		return "", jwt.ErrHashUnavailable
 	}
@@ -131,7 +179,6 @@ func (s *SigningMethodKMS) Sign(signingString string, key interface{}) (string,
 		return "", err
 	}
 
-	asymmetricSignRequest := &kmspb.AsymmetricSignRequest{}
 	switch s.hasher {
 	case crypto.SHA256:
 		asymmetricSignRequest.Digest = &kmspb.Digest{
@@ -160,14 +207,93 @@ func (s *SigningMethodKMS) Sign(signingString string, key interface{}) (string,
 }
 
 // KMSVerfiyKeyfunc is a helper meant that returns a jwt.Keyfunc. It will handle pulling and selecting the certificates
-// to verify signatures with, caching the public key in memory. It is not valid to modify the KMSConfig provided after
-// calling this function, you must call this again if changes to the config's KeyPath are made. Note that the public key
-// is retrieved when creating the key func and returned for each call to the returned jwt.Keyfunc.
+// to verify signatures with, caching the public key in memory for the KeyPath (see KMSHealthSource to pre-warm it).
+// It is not valid to modify the KMSConfig provided after calling this function, you must call this again if changes to
+// the config's KeyPath are made. Note that the public key is retrieved when creating the key func and returned for
+// each call to the returned jwt.Keyfunc. Use a Verifier to change the trusted key paths at runtime.
 // https://cloud.google.com/kms/docs/retrieve-public-key#kms-howto-retrieve-public-key-go
 func KMSVerfiyKeyfunc(ctx context.Context, config *KMSConfig) (jwt.Keyfunc, error) {
 	// The Public Key is static for the key version, so grab it now and re-use it as needed
-	var publicKey interface{}
 	keyVersion := config.KeyID()
+
+	// Local keys depend on the signing method of the token
+	if local, err := useLocalMode(config.LocalMode); err != nil {
+		return nil, err
+	} else if local {
+		return func(token *jwt.Token) (interface{}, error) {
+			method, ok := token.Method.(*SigningMethodKMS)
+			if !ok {
+				return nil, fmt.Errorf("gcpjwt: unexpected signing method: %v", token.Header["alg"])
+			}
+			if kid, ok := token.Header["kid"].(string); ok && kid != keyVersion {
+				return nil, fmt.Errorf("gcpjwt: unknown kid `%s` found in header", kid)
+			}
+
+			key, err := localKey(method.override.Alg(), config.KeyPath)
+			if err != nil {
+				return nil, err
+			}
+			return key.Public(), nil
+		}, nil
+	}
+	publicKey, err := cachedKMSPublicKey(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(token *jwt.Token) (interface{}, error) {
+		// Make sure we have the proper header alg
+		if _, ok := token.Method.(*SigningMethodKMS); !ok {
+			return nil, fmt.Errorf("gcpjwt: unexpected signing method: %v", token.Header["alg"])
+		}
+
+		if kid, ok := token.Header["kid"].(string); ok {
+			if kid != keyVersion {
+				return nil, fmt.Errorf("gcpjwt: unknown kid `%s` found in header", kid)
+			}
+		}
+
+		return publicKey, nil
+	}, nil
+}
+
+// KMSPublicKey retrieves and parses the public key of the configured KeyPath, returning an *rsa.PublicKey,
+// *ecdsa.PublicKey or ed25519.PublicKey depending on the key's algorithm.
+// https://cloud.google.com/kms/docs/retrieve-public-key#kms-howto-retrieve-public-key-go
+func KMSPublicKey(ctx context.Context, config *KMSConfig) (crypto.PublicKey, error) {
+	if local, err := useLocalMode(config.LocalMode); err != nil {
+		return nil, err
+	} else if local {
+		return nil, ErrLocalModePublicKey
+	}
+
+	if err := config.Attestation.check(ctx, config); err != nil {
+		return nil, err
+	}
+
+	return getKMSPublicKey(ctx, config)
+}
+
+// cachedKMSPublicKey returns the public key of the configured KeyPath like KMSPublicKey, only retrieving it the first
+// time. The attestation is still checked on every call as it depends on the config.
+func cachedKMSPublicKey(ctx context.Context, config *KMSConfig) (crypto.PublicKey, error) {
+	if err := config.Attestation.check(ctx, config); err != nil {
+		return nil, err
+	}
+	if publicKey, ok := getKMSPublicKeyFromCache(config.KeyPath); ok {
+		return publicKey, nil
+	}
+
+	publicKey, err := getKMSPublicKey(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	cacheKMSPublicKey(config.KeyPath, publicKey)
+	return publicKey, nil
+}
+
+// getKMSPublicKey retrieves and parses the public key of the configured KeyPath, without checking its attestation
+func getKMSPublicKey(ctx context.Context, config *KMSConfig) (crypto.PublicKey, error) {
 	client := config.KMSClient
 	if client == nil {
 		c, err := kms.NewKeyManagementClient(ctx)
@@ -187,25 +313,12 @@ func KMSVerfiyKeyfunc(ctx context.Context, config *KMSConfig) (jwt.Keyfunc, erro
 	if block == nil {
 		return nil, fmt.Errorf("gcpjwt: could not parse certificate from response")
 	}
-	publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse public key: %+v", err)
 	}
 
-	return func(token *jwt.Token) (interface{}, error) {
-		// Make sure we have the proper header alg
-		if _, ok := token.Method.(*SigningMethodKMS); !ok {
-			return nil, fmt.Errorf("gcpjwt: unexpected signing method: %v", token.Header["alg"])
-		}
-
-		if kid, ok := token.Header["kid"].(string); ok {
-			if kid != keyVersion {
-				return nil, fmt.Errorf("gcpjwt: unknown kid `%s` found in header", kid)
-			}
-		}
-
-		return publicKey, nil
-	}, nil
+	return publicKey, nil
 }
 
 // Verify does a pass-thru to the appropriate jwt.SigningMethod for this signing algorithm and expects the same key
@@ -216,6 +329,14 @@ func (s *SigningMethodKMS) Verify(signingString, signature string, key interface
 }
 
 func signKMS(ctx context.Context, config *KMSConfig, request *kmspb.AsymmetricSignRequest, ecdsaMethod *jwt.SigningMethodECDSA) (string, error) {
+	if err := config.RateLimit.wait(ctx, config.KeyPath); err != nil {
+		return "", err
+	}
+
+	if err := config.Attestation.check(ctx, config); err != nil {
+		return "", err
+	}
+
 	client := config.KMSClient
 	if client == nil {
 		c, err := kms.NewKeyManagementClient(ctx)
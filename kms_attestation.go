@@ -0,0 +1,295 @@
+package gcpjwt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"embed"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"math/big"
+	"sync"
+
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+var (
+	// ErrKeyNotHSM is returned when attestation is required and the key version is not protected by an HSM
+	ErrKeyNotHSM = errors.New("gcpjwt: key version is not HSM protected")
+	// ErrInvalidAttestation is returned when the attestation of an HSM key version cannot be verified
+	ErrInvalidAttestation = errors.New("gcpjwt: invalid key attestation")
+)
+
+// KMSAttestation requires the KMS key version to be HSM protected, verifying its attestation statement and
+// certificate chains offline against the provided roots before signing or verifying with it, and that the attested
+// key is the key version's public key. Successful verifications are remembered per KeyPath.
+//
+// The roots are published by the HSM manufacturer and Google, see https://cloud.google.com/kms/docs/attest-key, and
+// bundled with the package for DefaultKMSAttestation:
+//   - manufacturer: https://www.marvell.com/content/dam/marvell/en/public-collateral/security-solutions/liquid_security_certificate.zip
+//   - Google: https://www.gstatic.com/cloudhsm/roots/global_1498867200.pem
+type KMSAttestation struct {
+	// ManufacturerRoots verify the manufacturer (Cavium/Marvell) certificate chain
+	ManufacturerRoots *x509.CertPool
+
+	// GoogleRoots verify the Google card and partition certificate chains
+	GoogleRoots *x509.CertPool
+
+	verified sync.Map
+}
+
+const (
+	manufacturerRootsFile = "attestation_roots/manufacturer.pem"
+	googleRootsFile       = "attestation_roots/google.pem"
+)
+
+//go:embed attestation_roots
+var attestationRoots embed.FS
+
+// DefaultKMSAttestation returns a KMSAttestation with the manufacturer and Google root certificates bundled with the
+// package. Use NewKMSAttestation to verify against other roots.
+func DefaultKMSAttestation() (*KMSAttestation, error) {
+	return newKMSAttestationFS(attestationRoots)
+}
+
+// newKMSAttestationFS returns a KMSAttestation with the roots read from the bundled root files of fsys
+func newKMSAttestationFS(fsys fs.FS) (*KMSAttestation, error) {
+	manufacturerRootsPEM, err := fs.ReadFile(fsys, manufacturerRootsFile)
+	if err != nil {
+		return nil, fmt.Errorf("gcpjwt: could not read the bundled manufacturer roots: %w", err)
+	}
+	googleRootsPEM, err := fs.ReadFile(fsys, googleRootsFile)
+	if err != nil {
+		return nil, fmt.Errorf("gcpjwt: could not read the bundled Google roots: %w", err)
+	}
+	return NewKMSAttestation(manufacturerRootsPEM, googleRootsPEM)
+}
+
+// NewKMSAttestation returns a KMSAttestation with the roots parsed from the PEM encoded manufacturer and Google root
+// certificates.
+func NewKMSAttestation(manufacturerRootsPEM, googleRootsPEM []byte) (*KMSAttestation, error) {
+	manufacturer := x509.NewCertPool()
+	if !manufacturer.AppendCertsFromPEM(manufacturerRootsPEM) {
+		return nil, errors.New("gcpjwt: no manufacturer root certificate found")
+	}
+	google := x509.NewCertPool()
+	if !google.AppendCertsFromPEM(googleRootsPEM) {
+		return nil, errors.New("gcpjwt: no Google root certificate found")
+	}
+
+	return &KMSAttestation{ManufacturerRoots: manufacturer, GoogleRoots: google}, nil
+}
+
+// check verifies the key version of the config unless previously verified, a nil KMSAttestation checks nothing
+func (a *KMSAttestation) check(ctx context.Context, config *KMSConfig) error {
+	if a == nil {
+		return nil
+	}
+	if _, ok := a.verified.Load(config.KeyPath); ok {
+		return nil
+	}
+
+	version, err := getKMSKeyVersion(ctx, config)
+	if err != nil {
+		return err
+	}
+	publicKey, err := getKMSPublicKey(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	if err := a.Verify(version, publicKey); err != nil {
+		return err
+	}
+
+	a.verified.Store(config.KeyPath, true)
+	return nil
+}
+
+// Verify checks the key version is HSM protected, its attestation is signed by the HSM partition key certified by
+// both the manufacturer and Google, and the attested key is the key version's public key, as returned by
+// KMSPublicKey.
+func (a *KMSAttestation) Verify(version *kmspb.CryptoKeyVersion, publicKey crypto.PublicKey) error {
+	if version.ProtectionLevel != kmspb.ProtectionLevel_HSM {
+		return ErrKeyNotHSM
+	}
+
+	attestation := version.Attestation
+	if attestation == nil || attestation.CertChains == nil || len(attestation.Content) == 0 {
+		return fmt.Errorf("%v: missing attestation", ErrInvalidAttestation)
+	}
+	chains := attestation.CertChains
+
+	manufacturerLeaf, err := verifyChain(chains.CaviumCerts, a.ManufacturerRoots)
+	if err != nil {
+		return fmt.Errorf("%v: manufacturer chain: %v", ErrInvalidAttestation, err)
+	}
+	if _, err := verifyChain(chains.GoogleCardCerts, a.GoogleRoots); err != nil {
+		return fmt.Errorf("%v: Google card chain: %v", ErrInvalidAttestation, err)
+	}
+	partitionLeaf, err := verifyChain(chains.GooglePartitionCerts, a.GoogleRoots)
+	if err != nil {
+		return fmt.Errorf("%v: Google partition chain: %v", ErrInvalidAttestation, err)
+	}
+
+	content, err := decompressAttestation(attestation)
+	if err != nil {
+		return fmt.Errorf("%v: %v", ErrInvalidAttestation, err)
+	}
+
+	var attested []byte
+	for _, leaf := range []*x509.Certificate{manufacturerLeaf, partitionLeaf} {
+		if attested, err = verifyAttestationSignature(content, leaf); err != nil {
+			return fmt.Errorf("%v: %v", ErrInvalidAttestation, err)
+		}
+	}
+
+	if err := checkAttestedPublicKey(attested, publicKey); err != nil {
+		return fmt.Errorf("%v: %v", ErrInvalidAttestation, err)
+	}
+
+	return nil
+}
+
+// verifyChain verifies the PEM encoded certificate chain (leaf first) up to one of the roots and returns the leaf
+func verifyChain(chain []string, roots *x509.CertPool) (*x509.Certificate, error) {
+	if roots == nil {
+		return nil, errors.New("no roots configured")
+	}
+
+	var certs []*x509.Certificate
+	for _, c := range chain {
+		rest := []byte(c)
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		}
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("empty certificate chain")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, err
+	}
+
+	return certs[0], nil
+}
+
+func decompressAttestation(attestation *kmspb.KeyOperationAttestation) ([]byte, error) {
+	switch attestation.Format {
+	case kmspb.KeyOperationAttestation_CAVIUM_V1_COMPRESSED, kmspb.KeyOperationAttestation_CAVIUM_V2_COMPRESSED:
+	default:
+		return nil, fmt.Errorf("unsupported attestation format %v", attestation.Format)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(attestation.Content))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// verifyAttestationSignature verifies the attestation, which ends with its RSA PKCS#1 v1.5 SHA-256 signature by the
+// partition key, and returns the attested data
+func verifyAttestationSignature(content []byte, leaf *x509.Certificate) ([]byte, error) {
+	publicKey, ok := leaf.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("partition certificate does not hold an RSA key")
+	}
+
+	size := publicKey.Size()
+	if len(content) <= size {
+		return nil, errors.New("attestation too short")
+	}
+	data, signature := content[:len(content)-size], content[len(content)-size:]
+
+	digest := sha256.Sum256(data)
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// PKCS#11 attributes holding the public key in the attested key attributes
+const (
+	attributeModulus        = 0x120
+	attributePublicExponent = 0x122
+	attributeECPoint        = 0x181
+)
+
+// checkAttestedPublicKey checks the attested data holds the public key attributes of the public key, each encoded as
+// a big-endian 4 byte type, 4 byte length and value
+func checkAttestedPublicKey(attested []byte, publicKey crypto.PublicKey) error {
+	var attributes map[uint32][][]byte
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		attributes = map[uint32][][]byte{
+			attributeModulus:        {k.N.Bytes()},
+			attributePublicExponent: {big.NewInt(int64(k.E)).Bytes()},
+		}
+	case *ecdsa.PublicKey:
+		point := elliptic.Marshal(k.Curve, k.X, k.Y)
+		attributes = map[uint32][][]byte{attributeECPoint: {point, derOctetString(point)}}
+	case ed25519.PublicKey:
+		attributes = map[uint32][][]byte{attributeECPoint: {k, derOctetString(k)}}
+	default:
+		return fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+
+	for attribute, values := range attributes {
+		if !hasAttribute(attested, attribute, values) {
+			return fmt.Errorf("attested key does not match the key version's public key (attribute %#x)", attribute)
+		}
+	}
+	return nil
+}
+
+// hasAttribute reports whether the attested data holds the attribute with one of the values
+func hasAttribute(attested []byte, attribute uint32, values [][]byte) bool {
+	for _, value := range values {
+		tlv := make([]byte, 8, 8+len(value))
+		binary.BigEndian.PutUint32(tlv, attribute)
+		binary.BigEndian.PutUint32(tlv[4:], uint32(len(value)))
+		if bytes.Contains(attested, append(tlv, value...)) {
+			return true
+		}
+	}
+	return false
+}
+
+// derOctetString encodes the value as a DER OCTET STRING, the PKCS#11 encoding of EC points
+func derOctetString(value []byte) []byte {
+	b, _ := asn1.Marshal(value)
+	return b
+}
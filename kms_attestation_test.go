@@ -0,0 +1,326 @@
+package gcpjwt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/api/option"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// fakeKMS is a Cloud KMS server holding EC_SIGN_P256_SHA256 key versions by name
+type fakeKMS struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+
+	sync.Mutex
+	keys     map[string]*ecdsa.PrivateKey
+	versions map[string]*kmspb.CryptoKeyVersion
+	calls    map[string]int
+}
+
+func (f *fakeKMS) call(method, name string) (*ecdsa.PrivateKey, *kmspb.CryptoKeyVersion, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.calls[method]++
+	key, ok := f.keys[name]
+	if !ok {
+		return nil, nil, status.Errorf(codes.NotFound, "key version %s not found", name)
+	}
+	return key, f.versions[name], nil
+}
+
+func (f *fakeKMS) GetCryptoKeyVersion(ctx context.Context, req *kmspb.GetCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	_, version, err := f.call("GetCryptoKeyVersion", req.Name)
+	return version, err
+}
+
+func (f *fakeKMS) GetPublicKey(ctx context.Context, req *kmspb.GetPublicKeyRequest) (*kmspb.PublicKey, error) {
+	key, _, err := f.call("GetPublicKey", req.Name)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.PublicKey{
+		Pem:       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+		Name:      req.Name,
+	}, nil
+}
+
+func (f *fakeKMS) AsymmetricSign(ctx context.Context, req *kmspb.AsymmetricSignRequest) (*kmspb.AsymmetricSignResponse, error) {
+	key, _, err := f.call("AsymmetricSign", req.Name)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, key, req.GetDigest().GetSha256())
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.AsymmetricSignResponse{Signature: sig, Name: req.Name}, nil
+}
+
+// newFakeKMS starts a fakeKMS and returns a client connected to it
+func newFakeKMS(t *testing.T) (*fakeKMS, *kms.KeyManagementClient) {
+	fake := &fakeKMS{
+		keys:     make(map[string]*ecdsa.PrivateKey),
+		versions: make(map[string]*kmspb.CryptoKeyVersion),
+		calls:    make(map[string]int),
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	kmspb.RegisterKeyManagementServiceServer(server, fake)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	client, err := kms.NewKeyManagementClient(context.Background(),
+		option.WithEndpoint(lis.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return fake, client
+}
+
+func (f *fakeKMS) addKey(t *testing.T, name string, version *kmspb.CryptoKeyVersion) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.setKey(name, key, version)
+}
+
+func (f *fakeKMS) setKey(name string, key *ecdsa.PrivateKey, version *kmspb.CryptoKeyVersion) {
+	f.Lock()
+	defer f.Unlock()
+	version.Name = name
+	f.keys[name] = key
+	f.versions[name] = version
+}
+
+// attestationFixture issues test manufacturer and Google roots certifying an HSM partition key
+type attestationFixture struct {
+	manufacturerRoot, googleRoot *x509.Certificate
+	manufacturerKey, googleKey   *ecdsa.PrivateKey
+	partitionKey                 *rsa.PrivateKey
+	serial                       int64
+}
+
+func newAttestationFixture(t *testing.T) *attestationFixture {
+	f := &attestationFixture{}
+	var err error
+	if f.partitionKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	f.manufacturerRoot, f.manufacturerKey = f.root(t, "Test HSM Manufacturer Root")
+	f.googleRoot, f.googleKey = f.root(t, "Test Google HSM Root")
+	return f
+}
+
+func (f *attestationFixture) template(cn string, ca bool) *x509.Certificate {
+	f.serial++
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(f.serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+}
+
+func (f *attestationFixture) root(t *testing.T, cn string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := f.template(cn, true)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// issue returns the PEM certificate for the public key signed by the parent
+func (f *attestationFixture) issue(t *testing.T, cn string, publicKey crypto.PublicKey, parent *x509.Certificate, parentKey crypto.Signer) string {
+	der, err := x509.CreateCertificate(rand.Reader, f.template(cn, false), parent, publicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func (f *attestationFixture) pem(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// attestation returns a compressed attestation of the attested key signed by the signer and certificate chains for
+// the partition key
+func (f *attestationFixture) attestation(t *testing.T, signer *rsa.PrivateKey, attested *ecdsa.PublicKey) *kmspb.KeyOperationAttestation {
+	point := derOctetString(elliptic.Marshal(attested.Curve, attested.X, attested.Y))
+	data := []byte("attested key attributes")
+	data = binary.BigEndian.AppendUint32(data, attributeECPoint)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(point)))
+	data = append(data, point...)
+	digest := sha256.Sum256(data)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, signer, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(append(data, sig...))
+	w.Close()
+
+	cardKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &kmspb.KeyOperationAttestation{
+		Format:  kmspb.KeyOperationAttestation_CAVIUM_V2_COMPRESSED,
+		Content: buf.Bytes(),
+		CertChains: &kmspb.KeyOperationAttestation_CertificateChains{
+			CaviumCerts:          []string{f.issue(t, "partition", &f.partitionKey.PublicKey, f.manufacturerRoot, f.manufacturerKey)},
+			GoogleCardCerts:      []string{f.issue(t, "card", &cardKey.PublicKey, f.googleRoot, f.googleKey)},
+			GooglePartitionCerts: []string{f.issue(t, "partition", &f.partitionKey.PublicKey, f.googleRoot, f.googleKey)},
+		},
+	}
+}
+
+func TestKMSAttestation(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeKMS(t)
+	fixture := newAttestationFixture(t)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A chain issued by an unknown root
+	otherFixture := newAttestationFixture(t)
+	otherFixture.partitionKey = fixture.partitionKey
+
+	// Adds an HSM key version with an attestation made by the signer for the attested key, the key version's own
+	// key if nil
+	addAttestedKey := func(name string, fixture *attestationFixture, signer *rsa.PrivateKey, attested *ecdsa.PublicKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attested == nil {
+			attested = &key.PublicKey
+		}
+		fake.setKey(name, key, &kmspb.CryptoKeyVersion{
+			ProtectionLevel: kmspb.ProtectionLevel_HSM,
+			Attestation:     fixture.attestation(t, signer, attested),
+		})
+	}
+	otherECKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addAttestedKey("hsm", fixture, fixture.partitionKey, nil)
+	fake.addKey(t, "software", &kmspb.CryptoKeyVersion{ProtectionLevel: kmspb.ProtectionLevel_SOFTWARE})
+	fake.addKey(t, "missing", &kmspb.CryptoKeyVersion{ProtectionLevel: kmspb.ProtectionLevel_HSM})
+	addAttestedKey("badsignature", fixture, otherKey, nil)
+	addAttestedKey("untrusted", otherFixture, fixture.partitionKey, nil)
+	addAttestedKey("otherkey", fixture, fixture.partitionKey, &otherECKey.PublicKey)
+
+	// The fixture's roots bundled in place of the published ones
+	attestation, err := newKMSAttestationFS(fstest.MapFS{
+		manufacturerRootsFile: {Data: fixture.pem(fixture.manufacturerRoot)},
+		googleRootsFile:       {Data: fixture.pem(fixture.googleRoot)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newKMSAttestationFS(fstest.MapFS{googleRootsFile: {Data: fixture.pem(fixture.googleRoot)}}); err == nil {
+		t.Errorf("newKMSAttestationFS() expected error without the manufacturer roots")
+	}
+
+	tests := []struct {
+		name    string
+		keyPath string
+		wantErr error
+	}{
+		{"HSM", "hsm", nil},
+		{"Software", "software", ErrKeyNotHSM},
+		{"MissingAttestation", "missing", ErrInvalidAttestation},
+		{"BadSignature", "badsignature", ErrInvalidAttestation},
+		{"UntrustedChain", "untrusted", ErrInvalidAttestation},
+		{"OtherKeyAttested", "otherkey", ErrInvalidAttestation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &KMSConfig{KeyPath: tt.keyPath, KMSClient: client, Attestation: attestation}
+
+			_, signErr := jwt.New(SigningMethodKMSES256).SignedString(NewKMSContext(ctx, config))
+			_, keyfuncErr := KMSVerfiyKeyfunc(ctx, config)
+			for _, err := range []error{signErr, keyfuncErr} {
+				if tt.wantErr == nil && err != nil {
+					t.Fatalf("unexpected error = %v", err)
+				}
+				if tt.wantErr != nil && (err == nil || !bytes.HasPrefix([]byte(err.Error()), []byte(tt.wantErr.Error()))) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			}
+		})
+	}
+
+	// Verified key versions are only fetched once
+	fake.Lock()
+	calls := fake.calls["GetCryptoKeyVersion"]
+	fake.Unlock()
+	config := &KMSConfig{KeyPath: "hsm", KMSClient: client, Attestation: attestation}
+	if _, err := jwt.New(SigningMethodKMSES256).SignedString(NewKMSContext(ctx, config)); err != nil {
+		t.Fatal(err)
+	}
+	fake.Lock()
+	defer fake.Unlock()
+	if fake.calls["GetCryptoKeyVersion"] != calls {
+		t.Errorf("GetCryptoKeyVersion called again for a verified key version")
+	}
+}
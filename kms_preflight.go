@@ -0,0 +1,221 @@
+package gcpjwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+const (
+	defaultPreflightTTL = 5 * time.Minute
+
+	// kmsAlgorithmEd25519 is EC_SIGN_ED25519, which is newer than the KMS protos this package is built with
+	kmsAlgorithmEd25519 kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm = 40
+)
+
+var (
+	// ErrKeyVersionDisabled is returned when the KMS key version is disabled
+	ErrKeyVersionDisabled = errors.New("gcpjwt: KMS key version is disabled")
+	// ErrKeyVersionDestroyed is returned when the KMS key version is destroyed or scheduled for destruction
+	ErrKeyVersionDestroyed = errors.New("gcpjwt: KMS key version is destroyed or scheduled for destruction")
+	// ErrKeyVersionUnavailable is returned when the KMS key version is not enabled for another reason, e.g. pending
+	// generation or import
+	ErrKeyVersionUnavailable = errors.New("gcpjwt: KMS key version is not enabled")
+	// ErrKeyAlgorithmMismatch is returned when the KMS key version's purpose or algorithm does not match the signing
+	// method
+	ErrKeyAlgorithmMismatch = errors.New("gcpjwt: KMS key version algorithm does not match the signing method")
+
+	// Asymmetric signing algorithms accepted for each signing method, by the algorithm it overrides
+	kmsAlgorithms = map[string][]string{
+		"RS256": {"RSA_SIGN_PKCS1_2048_SHA256", "RSA_SIGN_PKCS1_3072_SHA256", "RSA_SIGN_PKCS1_4096_SHA256"},
+		"PS256": {"RSA_SIGN_PSS_2048_SHA256", "RSA_SIGN_PSS_3072_SHA256", "RSA_SIGN_PSS_4096_SHA256"},
+		"ES256": {"EC_SIGN_P256_SHA256"},
+		"ES384": {"EC_SIGN_P384_SHA384"},
+		"EdDSA": {"EC_SIGN_ED25519"},
+	}
+)
+
+// KeyVersionError is returned by the KMS pre-flight check, Err is one of the ErrKeyVersion* or
+// ErrKeyAlgorithmMismatch errors.
+type KeyVersionError struct {
+	KeyPath   string
+	State     kmspb.CryptoKeyVersion_CryptoKeyVersionState
+	Algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+
+	// DestroyTime is when a version scheduled for destruction will be destroyed
+	DestroyTime time.Time
+
+	Err error
+}
+
+func (e *KeyVersionError) Error() string {
+	switch e.Err {
+	case ErrKeyAlgorithmMismatch:
+		return fmt.Sprintf("%v: `%s` is %v", e.Err, e.KeyPath, e.Algorithm)
+	case ErrKeyVersionDestroyed:
+		if e.State == kmspb.CryptoKeyVersion_DESTROY_SCHEDULED {
+			return fmt.Sprintf("%v: `%s` will be destroyed at %v", e.Err, e.KeyPath, e.DestroyTime)
+		}
+	}
+	return fmt.Sprintf("%v: `%s` is %v", e.Err, e.KeyPath, e.State)
+}
+
+// Unwrap returns the underlying error.
+func (e *KeyVersionError) Unwrap() error {
+	return e.Err
+}
+
+// KMSPreflight checks the state, purpose and algorithm of the KMS key version before signing, so unusable versions are
+// refused with a KeyVersionError rather than the gRPC error of AsymmetricSign. Results are cached for the TTL. The
+// caller needs the cloudkms.cryptoKeyVersions.get permission (e.g. roles/cloudkms.viewer) in addition to signing.
+type KMSPreflight struct {
+	// TTL is how long the result of a check is cached, defaults to 5 minutes
+	TTL time.Duration
+
+	results sync.Map
+}
+
+type preflightResult struct {
+	err     error
+	expires time.Time
+}
+
+// check verifies the key version of the config can sign with the method, a nil KMSPreflight checks nothing
+func (p *KMSPreflight) check(ctx context.Context, config *KMSConfig, method *SigningMethodKMS) error {
+	if p == nil {
+		return nil
+	}
+
+	cacheKey := config.KeyPath + "\x00" + method.override.Alg()
+	if v, ok := p.results.Load(cacheKey); ok {
+		if result := v.(*preflightResult); time.Now().Before(result.expires) {
+			return result.err
+		}
+	}
+
+	version, err := getKMSKeyVersion(ctx, config)
+	if err != nil {
+		// Transient errors are not cached
+		return err
+	}
+
+	err = checkKeyVersion(config.KeyPath, version, method)
+
+	ttl := p.TTL
+	if ttl <= 0 {
+		ttl = defaultPreflightTTL
+	}
+	p.results.Store(cacheKey, &preflightResult{err: err, expires: time.Now().Add(ttl)})
+
+	return err
+}
+
+// checkKeyVersion returns a *KeyVersionError if the version is not enabled or, if the method is not nil, does not
+// match the method's algorithm
+func checkKeyVersion(keyPath string, version *kmspb.CryptoKeyVersion, method *SigningMethodKMS) error {
+	versionErr := &KeyVersionError{
+		KeyPath:   keyPath,
+		State:     version.State,
+		Algorithm: version.Algorithm,
+	}
+
+	switch version.State {
+	case kmspb.CryptoKeyVersion_ENABLED:
+	case kmspb.CryptoKeyVersion_DISABLED:
+		versionErr.Err = ErrKeyVersionDisabled
+		return versionErr
+	case kmspb.CryptoKeyVersion_DESTROY_SCHEDULED, kmspb.CryptoKeyVersion_DESTROYED:
+		versionErr.Err = ErrKeyVersionDestroyed
+		if version.DestroyTime != nil {
+			versionErr.DestroyTime = version.DestroyTime.AsTime()
+		}
+		return versionErr
+	default:
+		versionErr.Err = ErrKeyVersionUnavailable
+		return versionErr
+	}
+
+	if method == nil {
+		return nil
+	}
+
+	name, known := kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm_name[int32(version.Algorithm)]
+	if !known && version.Algorithm == kmsAlgorithmEd25519 {
+		name = "EC_SIGN_ED25519"
+	}
+	for _, alg := range kmsAlgorithms[method.override.Alg()] {
+		if alg == name {
+			return nil
+		}
+	}
+
+	versionErr.Err = ErrKeyAlgorithmMismatch
+	return versionErr
+}
+
+func getKMSKeyVersion(ctx context.Context, config *KMSConfig) (*kmspb.CryptoKeyVersion, error) {
+	client := config.KMSClient
+	if client == nil {
+		c, err := kms.NewKeyManagementClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		client = c
+	}
+
+	return client.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{Name: config.KeyPath})
+}
+
+// WatchKMSKeyVersions checks the key versions of the configs every interval until the context is done, calling warn
+// with a *KeyVersionError when a version is scheduled for destruction, destroyed or otherwise not enabled, or with the
+// error of the API call if a version could not be retrieved.
+func WatchKMSKeyVersions(ctx context.Context, interval time.Duration, warn func(config *KMSConfig, err error), configs ...*KMSConfig) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Configs without a KMSClient share one for every tick
+	var shared *kms.KeyManagementClient
+	defer func() {
+		if shared != nil {
+			shared.Close()
+		}
+	}()
+	getKeyVersion := func(config *KMSConfig) (*kmspb.CryptoKeyVersion, error) {
+		client := config.KMSClient
+		if client == nil {
+			if shared == nil {
+				c, err := kms.NewKeyManagementClient(ctx)
+				if err != nil {
+					return nil, err
+				}
+				shared = c
+			}
+			client = shared
+		}
+		return client.GetCryptoKeyVersion(ctx, &kmspb.GetCryptoKeyVersionRequest{Name: config.KeyPath})
+	}
+
+	for {
+		for _, config := range configs {
+			version, err := getKeyVersion(config)
+			if err == nil {
+				err = checkKeyVersion(config.KeyPath, version, nil)
+			}
+			if err != nil && ctx.Err() == nil {
+				warn(config, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
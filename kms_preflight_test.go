@@ -0,0 +1,152 @@
+package gcpjwt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestKMSPreflight(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeKMS(t)
+
+	destroyTime := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	fake.addKey(t, "enabled", &kmspb.CryptoKeyVersion{
+		State:     kmspb.CryptoKeyVersion_ENABLED,
+		Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+	})
+	fake.addKey(t, "disabled", &kmspb.CryptoKeyVersion{
+		State:     kmspb.CryptoKeyVersion_DISABLED,
+		Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+	})
+	fake.addKey(t, "scheduled", &kmspb.CryptoKeyVersion{
+		State:       kmspb.CryptoKeyVersion_DESTROY_SCHEDULED,
+		Algorithm:   kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+		DestroyTime: timestamppb.New(destroyTime),
+	})
+	fake.addKey(t, "pending", &kmspb.CryptoKeyVersion{
+		State:     kmspb.CryptoKeyVersion_PENDING_GENERATION,
+		Algorithm: kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+	})
+
+	tests := []struct {
+		name    string
+		keyPath string
+		method  *SigningMethodKMS
+		wantErr error
+	}{
+		{"Enabled", "enabled", SigningMethodKMSES256, nil},
+		{"AlgorithmMismatch", "enabled", SigningMethodKMSES384, ErrKeyAlgorithmMismatch},
+		{"PurposeMismatch", "enabled", SigningMethodKMSRS256, ErrKeyAlgorithmMismatch},
+		{"Disabled", "disabled", SigningMethodKMSES256, ErrKeyVersionDisabled},
+		{"DestroyScheduled", "scheduled", SigningMethodKMSES256, ErrKeyVersionDestroyed},
+		{"Pending", "pending", SigningMethodKMSES256, ErrKeyVersionUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &KMSConfig{KeyPath: tt.keyPath, KMSClient: client, Preflight: &KMSPreflight{}}
+			_, err := jwt.New(tt.method).SignedString(NewKMSContext(ctx, config))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("SignedString() error = %v", err)
+				}
+				return
+			}
+
+			var versionErr *KeyVersionError
+			if !errors.As(err, &versionErr) || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SignedString() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == ErrKeyVersionDestroyed && !versionErr.DestroyTime.Equal(destroyTime) {
+				t.Errorf("KeyVersionError.DestroyTime = %v, want %v", versionErr.DestroyTime, destroyTime)
+			}
+		})
+	}
+
+	t.Run("Cached", func(t *testing.T) {
+		config := &KMSConfig{KeyPath: "disabled", KMSClient: client, Preflight: &KMSPreflight{TTL: time.Hour}}
+		fake.Lock()
+		calls := fake.calls["GetCryptoKeyVersion"]
+		fake.Unlock()
+
+		for i := 0; i < 3; i++ {
+			if _, err := jwt.New(SigningMethodKMSES256).SignedString(NewKMSContext(ctx, config)); err == nil {
+				t.Fatal("SignedString() expected error")
+			}
+		}
+
+		fake.Lock()
+		defer fake.Unlock()
+		if got := fake.calls["GetCryptoKeyVersion"] - calls; got != 1 {
+			t.Errorf("GetCryptoKeyVersion called %d times, want 1", got)
+		}
+		if fake.calls["AsymmetricSign"] != 1 {
+			t.Errorf("AsymmetricSign called %d times, want 1 (enabled key only)", fake.calls["AsymmetricSign"])
+		}
+	})
+}
+
+func Test_checkKeyVersionEd25519(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+		method    *SigningMethodKMS
+		wantErr   error
+	}{
+		{"Ed25519", kmsAlgorithmEd25519, SigningMethodKMSEdDSA, nil},
+		{"UnknownAlgorithm", kmsAlgorithmEd25519 + 1, SigningMethodKMSEdDSA, ErrKeyAlgorithmMismatch},
+		{"P256", kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256, SigningMethodKMSEdDSA, ErrKeyAlgorithmMismatch},
+		{"Ed25519WithES256", kmsAlgorithmEd25519, SigningMethodKMSES256, ErrKeyAlgorithmMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version := &kmspb.CryptoKeyVersion{State: kmspb.CryptoKeyVersion_ENABLED, Algorithm: tt.algorithm}
+			if err := checkKeyVersion("key", version, tt.method); !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkKeyVersion() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWatchKMSKeyVersions(t *testing.T) {
+	fake, client := newFakeKMS(t)
+	fake.addKey(t, "enabled", &kmspb.CryptoKeyVersion{State: kmspb.CryptoKeyVersion_ENABLED})
+	fake.addKey(t, "scheduled", &kmspb.CryptoKeyVersion{State: kmspb.CryptoKeyVersion_DESTROY_SCHEDULED})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	warnings := make(map[string]error)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		WatchKMSKeyVersions(ctx, 10*time.Millisecond, func(config *KMSConfig, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			warnings[config.KeyPath] = err
+			if len(warnings) > 0 {
+				cancel()
+			}
+		}, &KMSConfig{KeyPath: "enabled", KMSClient: client}, &KMSConfig{KeyPath: "scheduled", KMSClient: client})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchKMSKeyVersions did not return after the context was canceled")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := warnings["enabled"]; ok {
+		t.Errorf("unexpected warning for an enabled key version")
+	}
+	if err := warnings["scheduled"]; !errors.Is(err, ErrKeyVersionDestroyed) {
+		t.Errorf("warning = %v, want %v", err, ErrKeyVersionDestroyed)
+	}
+}
@@ -50,6 +50,8 @@ func algToMethod(alg string) jwt.SigningMethod {
 		return SigningMethodKMSES256
 	case "ES384":
 		return SigningMethodKMSES384
+	case "EdDSA":
+		return SigningMethodKMSEdDSA
 	}
 	return nil
 }
@@ -191,6 +193,10 @@ func TestSigningMethodKMS_Override(t *testing.T) {
 			"ES384",
 			SigningMethodKMSES384,
 		},
+		{
+			"EdDSA",
+			SigningMethodKMSEdDSA,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -199,6 +205,7 @@ func TestSigningMethodKMS_Override(t *testing.T) {
 				t.Errorf("method = `%v`, expected `%v'", method, tt.s)
 			}
 			tt.s.Override()
+			t.Cleanup(tt.s.Restore)
 			method = jwt.GetSigningMethod(tt.s.override.Alg())
 			if method != tt.s {
 				t.Errorf("method = `%v`, expected `%v'", method, tt.s)
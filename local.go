@@ -0,0 +1,324 @@
+package gcpjwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	localRSABits = 2048
+	localRSAExp  = 65537
+
+	localModeWarning = "gcpjwt: WARNING: LOCAL MODE ENABLED - tokens are signed with deterministic, publicly derivable " +
+		"keys instead of IAM, KMS or AppEngine and MUST NOT be trusted outside of local development"
+)
+
+var (
+	// ErrLocalModeInProduction is returned when local mode is enabled in what looks like a Google Cloud runtime or a
+	// Kubernetes cluster
+	ErrLocalModeInProduction = errors.New("gcpjwt: local mode cannot be enabled in a production environment")
+	// ErrLocalModePublicKey is returned by KMSPublicKey in local mode, as the type of local KMS keys depends on the
+	// signing method
+	ErrLocalModePublicKey = errors.New("gcpjwt: the public key of a local mode KMS key depends on the signing method")
+
+	// Environment variables set by the Google Cloud runtimes (Cloud Run, Cloud Functions, AppEngine) and Kubernetes
+	// (GKE or any other cluster)
+	productionEnvVars = []string{"K_SERVICE", "CLOUD_RUN_JOB", "FUNCTION_TARGET", "GAE_APPLICATION", "GAE_SERVICE",
+		"KUBERNETES_SERVICE_HOST"}
+
+	// onGCE reports whether the metadata server is reachable, i.e. on Compute Engine, GKE or any other Google Cloud
+	// runtime, regardless of the environment variables set
+	onGCE = metadata.OnGCE
+
+	localMode struct {
+		sync.RWMutex
+		enabled bool
+	}
+	localModeWarnOnce sync.Once
+
+	// generated keys by algorithm and seed
+	localKeys sync.Map
+)
+
+// EnableLocalMode makes every signing method (IAM, KMS and AppEngine) sign with deterministic keys generated locally
+// from the service account or key path, and the corresponding keyfuncs verify with them, so services can run without
+// Google Cloud credentials. The keys are derivable by anyone: it must only be used for local development and is
+// refused with ErrLocalModeInProduction in Google Cloud runtimes (including Compute Engine) and Kubernetes clusters.
+// See IAMConfig.LocalMode and KMSConfig.LocalMode to enable it per config.
+func EnableLocalMode() error {
+	if err := checkLocalModeEnvironment(); err != nil {
+		return err
+	}
+
+	localMode.Lock()
+	defer localMode.Unlock()
+
+	localMode.enabled = true
+	log.Print(localModeWarning)
+
+	return nil
+}
+
+// DisableLocalMode disables the local mode enabled by EnableLocalMode.
+func DisableLocalMode() {
+	localMode.Lock()
+	defer localMode.Unlock()
+
+	localMode.enabled = false
+}
+
+// LocalModeEnabled reports whether local mode was enabled with EnableLocalMode.
+func LocalModeEnabled() bool {
+	localMode.RLock()
+	defer localMode.RUnlock()
+
+	return localMode.enabled
+}
+
+// useLocalMode reports whether local keys must be used given a config's LocalMode, refusing it in production
+func useLocalMode(configLocalMode bool) (bool, error) {
+	if !configLocalMode && !LocalModeEnabled() {
+		return false, nil
+	}
+	if err := checkLocalModeEnvironment(); err != nil {
+		return false, err
+	}
+
+	localModeWarnOnce.Do(func() {
+		log.Print(localModeWarning)
+	})
+
+	return true, nil
+}
+
+func checkLocalModeEnvironment() error {
+	for _, env := range productionEnvVars {
+		if os.Getenv(env) != "" {
+			return fmt.Errorf("%w: %s is set", ErrLocalModeInProduction, env)
+		}
+	}
+	if onGCE() {
+		return fmt.Errorf("%w: the metadata server is reachable", ErrLocalModeInProduction)
+	}
+	return nil
+}
+
+// localKey returns the deterministic private key for the algorithm the signing method overrides (RS256, PS256, ES256,
+// ES384 or EdDSA) and the seed
+func localKey(alg, seed string) (crypto.Signer, error) {
+	// RSA keys do not depend on the padding so RS256 and PS256 share them
+	if alg == "PS256" {
+		alg = "RS256"
+	}
+
+	cacheKey := alg + "\x00" + seed
+	if key, ok := localKeys.Load(cacheKey); ok {
+		return key.(crypto.Signer), nil
+	}
+
+	r := newLocalKeyStream(alg, seed)
+	var key crypto.Signer
+	switch alg {
+	case "RS256":
+		rsaKey, err := deterministicRSAKey(r, localRSABits)
+		if err != nil {
+			return nil, err
+		}
+		key = rsaKey
+	case "ES256":
+		key = deterministicECKey(r, elliptic.P256())
+	case "ES384":
+		key = deterministicECKey(r, elliptic.P384())
+	case "EdDSA":
+		seedBytes := make([]byte, ed25519.SeedSize)
+		if _, err := io.ReadFull(r, seedBytes); err != nil {
+			return nil, err
+		}
+		key = ed25519.NewKeyFromSeed(seedBytes)
+	default:
+		return nil, fmt.Errorf("gcpjwt: local mode does not support algorithm `%s`", alg)
+	}
+
+	actual, _ := localKeys.LoadOrStore(cacheKey, key)
+	return actual.(crypto.Signer), nil
+}
+
+// localKeyID returns the JWK thumbprint of the public key, used as the key id of local keys
+func localKeyID(key crypto.Signer) (string, error) {
+	jwk, err := NewJWK(key.Public())
+	if err != nil {
+		return "", err
+	}
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		return "", err
+	}
+	return "local-" + thumbprint, nil
+}
+
+// localCertificates returns the certificates holding the local RSA key for the seed, as returned by the IAM and
+// AppEngine certificate endpoints
+func localCertificates(seed string) (certificates, error) {
+	key, err := localKey("RS256", seed)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := localKeyID(key)
+	if err != nil {
+		return nil, err
+	}
+	return certificates{kid: key.Public().(*rsa.PublicKey)}, nil
+}
+
+// signLocalRS256 signs with the local RSA key for the seed, returning the signature (or the complete JWT with its own
+// header when jwtOut, like the signJwt API) and the key id
+func signLocalRS256(seed, signingString string, jwtOut bool) (string, string, error) {
+	key, err := localKey("RS256", seed)
+	if err != nil {
+		return "", "", err
+	}
+	kid, err := localKeyID(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	if jwtOut {
+		parts := strings.Split(signingString, ".")
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("gcpjwt: expected a 2 part string to sign, got %d parts", len(parts))
+		}
+		header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+		if err != nil {
+			return "", "", err
+		}
+		signingString = jwt.EncodeSegment(header) + "." + parts[1]
+	}
+
+	sig, err := jwt.SigningMethodRS256.Sign(signingString, key)
+	if err != nil {
+		return "", "", err
+	}
+	if jwtOut {
+		return signingString + "." + sig, kid, nil
+	}
+	return sig, kid, nil
+}
+
+// localKeyStream is a deterministic stream of bytes: SHA-256 in counter mode over the algorithm and seed
+type localKeyStream struct {
+	prefix  []byte
+	counter uint64
+	buf     []byte
+}
+
+func newLocalKeyStream(alg, seed string) *localKeyStream {
+	return &localKeyStream{prefix: []byte("gcpjwt local mode\x00" + alg + "\x00" + seed + "\x00")}
+}
+
+func (s *localKeyStream) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(s.buf) == 0 {
+			block := make([]byte, len(s.prefix)+8)
+			copy(block, s.prefix)
+			binary.BigEndian.PutUint64(block[len(s.prefix):], s.counter)
+			s.counter++
+			sum := sha256.Sum256(block)
+			s.buf = sum[:]
+		}
+		c := copy(p[n:], s.buf)
+		s.buf = s.buf[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// deterministicRSAKey generates an RSA key from the stream. rsa.GenerateKey is not deterministic for a given reader.
+func deterministicRSAKey(r io.Reader, bits int) (*rsa.PrivateKey, error) {
+	e := big.NewInt(localRSAExp)
+	one := big.NewInt(1)
+	for {
+		p, err := deterministicPrime(r, bits/2)
+		if err != nil {
+			return nil, err
+		}
+		q, err := deterministicPrime(r, bits/2)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+
+		n := new(big.Int).Mul(p, q)
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		d := new(big.Int).ModInverse(e, phi)
+		if n.BitLen() != bits || d == nil {
+			continue
+		}
+
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: localRSAExp},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		key.Precompute()
+		if err := key.Validate(); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+}
+
+// deterministicPrime returns the first prime from a random odd starting point with its top two bits set, so the
+// product of two such primes has exactly twice the bits
+func deterministicPrime(r io.Reader, bits int) (*big.Int, error) {
+	b := make([]byte, bits/8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	b[0] |= 0xc0
+	b[len(b)-1] |= 1
+
+	two := big.NewInt(2)
+	for p := new(big.Int).SetBytes(b); p.BitLen() == bits; p.Add(p, two) {
+		if p.ProbablyPrime(20) {
+			return p, nil
+		}
+	}
+	return deterministicPrime(r, bits)
+}
+
+// deterministicECKey derives the private scalar from the stream
+func deterministicECKey(r io.Reader, curve elliptic.Curve) *ecdsa.PrivateKey {
+	params := curve.Params()
+	b := make([]byte, (params.BitSize+7)/8+8)
+	io.ReadFull(r, b)
+
+	// d in [1, N-1]
+	nMinusOne := new(big.Int).Sub(params.N, big.NewInt(1))
+	d := new(big.Int).Mod(new(big.Int).SetBytes(b), nMinusOne)
+	d.Add(d, big.NewInt(1))
+
+	key := &ecdsa.PrivateKey{D: d}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d.FillBytes(make([]byte, (params.BitSize+7)/8)))
+	return key
+}
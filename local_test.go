@@ -0,0 +1,188 @@
+package gcpjwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/golang-jwt/jwt"
+)
+
+func TestLocalMode(t *testing.T) {
+	ctx := context.Background()
+	for _, env := range productionEnvVars {
+		t.Setenv(env, "")
+	}
+
+	if err := EnableLocalMode(); err != nil {
+		t.Fatalf("EnableLocalMode() error = %v", err)
+	}
+	defer DisableLocalMode()
+
+	t.Run("IAM", func(t *testing.T) {
+		for _, method := range []*SigningMethodIAM{SigningMethodIAMBlob, SigningMethodIAMJWT} {
+			config := &IAMConfig{ServiceAccount: "local@project.iam.gserviceaccount.com"}
+			keyFunc := IAMVerfiyKeyfunc(ctx, config)
+
+			token := jwt.NewWithClaims(method, &jwt.StandardClaims{Subject: "local"})
+			signingString, err := token.SigningString()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := method.Sign(signingString, NewIAMContext(ctx, config))
+			if err != nil {
+				t.Fatalf("%s: Sign() error = %v", method.defaultAlg, err)
+			}
+
+			tokenString := signingString + "." + sig
+			if method.returnsJWT() {
+				tokenString = sig
+			}
+			// Tokens carry RS256 in their header once signed by the IAM API (or its local emulation)
+			registry := NewRegistry(method, method.Overridden())
+			parsed, err := registry.Parse(tokenString, keyFunc)
+			if err != nil || !parsed.Valid {
+				t.Fatalf("%s: Parse() error = %v", method.defaultAlg, err)
+			}
+			if kid, _ := parsed.Header["kid"].(string); method.returnsJWT() && kid != config.KeyID() {
+				t.Errorf("%s: kid = %v, want %v", method.defaultAlg, kid, config.KeyID())
+			}
+		}
+	})
+
+	t.Run("KMS", func(t *testing.T) {
+		tests := []struct {
+			method  *SigningMethodKMS
+			keyType interface{}
+		}{
+			{SigningMethodKMSRS256, &rsa.PublicKey{}},
+			{SigningMethodKMSPS256, &rsa.PublicKey{}},
+			{SigningMethodKMSES256, &ecdsa.PublicKey{}},
+			{SigningMethodKMSES384, &ecdsa.PublicKey{}},
+			{SigningMethodKMSEdDSA, ed25519.PublicKey{}},
+		}
+		for _, tt := range tests {
+			config := &KMSConfig{KeyPath: "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"}
+			keyFunc, err := KMSVerfiyKeyfunc(ctx, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token := jwt.NewWithClaims(tt.method, &jwt.StandardClaims{Subject: "local"})
+			token.Header["kid"] = config.KeyID()
+			tokenString, err := token.SignedString(NewKMSContext(ctx, config))
+			if err != nil {
+				t.Fatalf("%s: SignedString() error = %v", tt.method.Alg(), err)
+			}
+			parsed, err := jwt.Parse(tokenString, keyFunc)
+			if err != nil || !parsed.Valid {
+				t.Fatalf("%s: Parse() error = %v", tt.method.Alg(), err)
+			}
+
+			key, err := keyFunc(parsed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reflect.TypeOf(key) != reflect.TypeOf(tt.keyType) {
+				t.Errorf("%s: key type = %T, want %T", tt.method.Alg(), key, tt.keyType)
+			}
+		}
+	})
+
+	t.Run("AppEngine", func(t *testing.T) {
+		keyFunc := AppEngineVerfiyKeyfunc(ctx, false, 0)
+		tokenString, err := jwt.New(SigningMethodAppEngine).SignedString(ctx)
+		if err != nil {
+			t.Fatalf("SignedString() error = %v", err)
+		}
+		if _, err := jwt.Parse(tokenString, keyFunc); err != nil {
+			t.Errorf("Parse() error = %v", err)
+		}
+	})
+
+	t.Run("Deterministic", func(t *testing.T) {
+		for _, alg := range []string{"RS256", "ES256", "ES384", "EdDSA"} {
+			key, err := localKey(alg, "seed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			localKeys.Delete(alg + "\x00seed")
+			again, err := localKey(alg, "seed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(key.Public(), again.Public()) {
+				t.Errorf("%s: local key is not deterministic", alg)
+			}
+
+			other, err := localKey(alg, "other seed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reflect.DeepEqual(key.Public(), other.Public()) {
+				t.Errorf("%s: local keys do not depend on the seed", alg)
+			}
+		}
+	})
+
+	production := map[string]func(t *testing.T){
+		"CloudRun": func(t *testing.T) {
+			t.Setenv("K_SERVICE", "service")
+		},
+		"Kubernetes": func(t *testing.T) {
+			t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+		},
+		"ComputeEngine": func(t *testing.T) {
+			onGCE = func() bool { return true }
+			t.Cleanup(func() { onGCE = metadata.OnGCE })
+		},
+	}
+	for name, setup := range production {
+		t.Run(name, func(t *testing.T) {
+			setup(t)
+
+			if err := EnableLocalMode(); !errors.Is(err, ErrLocalModeInProduction) {
+				t.Errorf("EnableLocalMode() error = %v, want %v", err, ErrLocalModeInProduction)
+			}
+
+			config := &KMSConfig{KeyPath: "key", LocalMode: true}
+			if _, err := jwt.New(SigningMethodKMSES256).SignedString(NewKMSContext(ctx, config)); err == nil {
+				t.Errorf("SignedString() expected error in production")
+			}
+		})
+	}
+}
+
+func TestLocalModeConfig(t *testing.T) {
+	ctx := context.Background()
+	for _, env := range productionEnvVars {
+		t.Setenv(env, "")
+	}
+
+	config := &IAMConfig{ServiceAccount: "local@project.iam.gserviceaccount.com", LocalMode: true}
+	token := jwt.New(SigningMethodIAMBlob)
+	signingString, err := token.SigningString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := SigningMethodIAMBlob.Sign(signingString, NewIAMContext(ctx, config))
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if err := SigningMethodIAMBlob.Verify(signingString, sig, mustKeys(t, IAMVerfiyKeyfunc(ctx, config), token)); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+}
+
+func mustKeys(t *testing.T, keyFunc jwt.Keyfunc, token *jwt.Token) interface{} {
+	key, err := keyFunc(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
@@ -0,0 +1,167 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+const (
+	// ClientAssertionType is the client assertion type of the private_key_jwt client authentication method
+	// https://tools.ietf.org/html/rfc7523#section-2.2
+	ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	defaultAssertionLifetime = 2 * time.Minute
+)
+
+// ClientAssertion signs the JWTs a client authenticates with to an OAuth 2.0 server using the private_key_jwt
+// method (RFC 7523), through the IAM API for the IAMConfig or through Cloud KMS for the KMSConfig, so no key file is
+// needed. The server must trust the matching public keys, for a service account the JWK Set at
+// https://www.googleapis.com/service_accounts/v1/jwk/<service account> which has the `kid` of the assertions.
+//
+// https://tools.ietf.org/html/rfc7523
+type ClientAssertion struct {
+	// ClientID is the `iss` and `sub` claims of the assertions
+	ClientID string
+
+	// Audience is the `aud` claim of the assertions, the URL of the token endpoint
+	Audience string
+
+	// Lifetime of the assertions, defaults to 2 minutes
+	Lifetime time.Duration
+
+	// IAMConfig signs the assertions with the IAM API, either it or KMSConfig must be set. Only the signJwt API
+	// (gcpjwt.IAMJwtType) is supported: signBlob only returns the key id after signing, so the `kid` header the
+	// server selects the key with cannot be set.
+	IAMConfig *gcpjwt.IAMConfig
+
+	// KMSConfig and KMSMethod sign the assertions with Cloud KMS, the `alg` header is the standard algorithm of the
+	// method, e.g. ES256 for gcpjwt.SigningMethodKMSES256
+	KMSConfig *gcpjwt.KMSConfig
+	KMSMethod *gcpjwt.SigningMethodKMS
+
+	// KeyID, if not empty, is the `kid` header of KMS signed assertions instead of KMSConfig.KeyID()
+	KeyID string
+}
+
+// Sign returns a new client assertion with a random `jti` claim.
+func (a *ClientAssertion) Sign(ctx context.Context) (string, error) {
+	if a.ClientID == "" || a.Audience == "" {
+		return "", errors.New("gcpjwt/oauth2: client assertion requires a client id and audience")
+	}
+
+	lifetime := a.Lifetime
+	if lifetime <= 0 {
+		lifetime = defaultAssertionLifetime
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &jwt.StandardClaims{
+		Issuer:    a.ClientID,
+		Subject:   a.ClientID,
+		Audience:  a.Audience,
+		Id:        base64.RawURLEncoding.EncodeToString(jti),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(lifetime).Unix(),
+	}
+
+	switch {
+	case a.IAMConfig != nil:
+		return signIAM(gcpjwt.NewIAMContext(ctx, a.IAMConfig), a.IAMConfig, claims)
+	case a.KMSConfig != nil && a.KMSMethod != nil:
+		token := jwt.NewWithClaims(a.KMSMethod.Overridden(), claims)
+		token.Header["kid"] = a.KMSConfig.KeyID()
+		if a.KeyID != "" {
+			token.Header["kid"] = a.KeyID
+		}
+		assertion, err := token.SignedString(gcpjwt.NewKMSContext(ctx, a.KMSConfig))
+		if err != nil {
+			return "", fmt.Errorf("gcpjwt/oauth2: could not sign client assertion: %v", err)
+		}
+		return assertion, nil
+	}
+	return "", errors.New("gcpjwt/oauth2: client assertion requires an IAMConfig or a KMSConfig and KMSMethod")
+}
+
+// signIAM signs the claims with the signJwt API, as a RS256 JWT with the `kid` of the signing key
+func signIAM(ctx context.Context, config *gcpjwt.IAMConfig, claims jwt.Claims) (string, error) {
+	if config.IAMType != gcpjwt.IAMJwtType {
+		return "", fmt.Errorf("gcpjwt/oauth2: client assertions require the signJwt token type, `%v` provided", config.IAMType)
+	}
+
+	method := gcpjwt.SigningMethodIAMJWT.Overridden()
+	token := jwt.NewWithClaims(method, claims)
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	// signJwt returns the complete JWT
+	assertion, err := method.Sign(signingString, ctx)
+	if err != nil {
+		return "", fmt.Errorf("gcpjwt/oauth2: could not sign client assertion: %v", err)
+	}
+	return assertion, nil
+}
+
+// ClientCredentialsTokenSource returns a TokenSource getting tokens with the client credentials grant of the config,
+// authenticating with a new client assertion for each token instead of the config's ClientSecret. The assertion's
+// ClientID and Audience default to the config's ClientID and TokenURL.
+func ClientCredentialsTokenSource(ctx context.Context, config *clientcredentials.Config, assertion *ClientAssertion) oauth2.TokenSource {
+	a := *assertion
+	if a.ClientID == "" {
+		a.ClientID = config.ClientID
+	}
+	if a.Audience == "" {
+		a.Audience = config.TokenURL
+	}
+
+	return oauth2.ReuseTokenSource(nil, &clientAssertionTokenSource{
+		ctx:       ctx,
+		config:    config,
+		assertion: &a,
+	})
+}
+
+type clientAssertionTokenSource struct {
+	ctx       context.Context
+	config    *clientcredentials.Config
+	assertion *ClientAssertion
+}
+
+func (ts *clientAssertionTokenSource) Token() (*oauth2.Token, error) {
+	assertion, err := ts.assertion.Sign(ts.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	for k, v := range ts.config.EndpointParams {
+		params[k] = v
+	}
+	params.Set("client_assertion_type", ClientAssertionType)
+	params.Set("client_assertion", assertion)
+
+	config := &clientcredentials.Config{
+		ClientID:       ts.config.ClientID,
+		TokenURL:       ts.config.TokenURL,
+		Scopes:         ts.config.Scopes,
+		EndpointParams: params,
+		AuthStyle:      oauth2.AuthStyleInParams,
+	}
+	return config.Token(ts.ctx)
+}
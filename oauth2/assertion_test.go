@@ -0,0 +1,86 @@
+package oauth2
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2/clientcredentials"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+	"github.com/csmadhu/gcp-jwt-go/oauth2server"
+)
+
+func TestClientCredentialsTokenSource(t *testing.T) {
+	ctx := context.Background()
+	const (
+		iamClient = "client@project.iam.gserviceaccount.com"
+		kmsClient = "kms-client"
+	)
+	iamConfig := &gcpjwt.IAMConfig{ServiceAccount: iamClient, IAMType: gcpjwt.IAMJwtType, LocalMode: true}
+	kmsConfig := &gcpjwt.KMSConfig{KeyPath: "projects/p/locations/l/keyRings/r/cryptoKeys/client/cryptoKeyVersions/1", LocalMode: true}
+	kmsKeyfunc, err := gcpjwt.KMSVerfiyKeyfunc(ctx, kmsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := oauth2server.NewTokenHandler(jwt.SigningMethodHS256, []byte("secret"), "https://auth.example.com",
+		&oauth2server.Client{ID: iamClient, Keyfunc: gcpjwt.IAMVerfiyKeyfunc(ctx, iamConfig), Scopes: []string{"read"}},
+		&oauth2server.Client{ID: kmsClient, Keyfunc: kmsKeyfunc, Scopes: []string{"read"}},
+	)
+	// The assertions use the standard algorithms
+	handler.Registry = gcpjwt.NewRegistry(gcpjwt.SigningMethodIAMJWT.Overridden(), gcpjwt.SigningMethodKMSES256.Overridden())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	handler.TokenURL = server.URL
+
+	tests := []struct {
+		name      string
+		clientID  string
+		assertion *ClientAssertion
+		wantAlg   string
+	}{
+		{"IAM", iamClient, &ClientAssertion{IAMConfig: iamConfig}, "RS256"},
+		{"KMS", kmsClient, &ClientAssertion{KMSConfig: kmsConfig, KMSMethod: gcpjwt.SigningMethodKMSES256}, "ES256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &clientcredentials.Config{ClientID: tt.clientID, TokenURL: server.URL, Scopes: []string{"read"}}
+			ts := ClientCredentialsTokenSource(ctx, config, tt.assertion)
+			tok, err := ts.Token()
+			if err != nil {
+				t.Fatalf("Token() error = %v", err)
+			}
+			if !tok.Valid() || tok.Extra("scope") != "read" {
+				t.Errorf("Token() = %+v", tok)
+			}
+
+			// The assertion claims
+			assertion := *tt.assertion
+			assertion.ClientID, assertion.Audience = tt.clientID, server.URL
+			assertionString, err := assertion.Sign(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims := &jwt.StandardClaims{}
+			token, _, err := new(jwt.Parser).ParseUnverified(assertionString, claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Header["alg"] != tt.wantAlg || token.Header["kid"] == nil || claims.Issuer != tt.clientID || claims.Subject != tt.clientID ||
+				claims.Audience != server.URL || claims.Id == "" || claims.ExpiresAt > time.Now().Add(defaultAssertionLifetime).Unix() {
+				t.Errorf("unexpected assertion %v %+v", token.Header, claims)
+			}
+		})
+	}
+
+	if _, err := (&ClientAssertion{ClientID: "client", Audience: "aud"}).Sign(ctx); err == nil {
+		t.Errorf("Sign() without signer expected an error")
+	}
+	blob := &ClientAssertion{ClientID: iamClient, Audience: "aud", IAMConfig: &gcpjwt.IAMConfig{ServiceAccount: iamClient, IAMType: gcpjwt.IAMBlobType, LocalMode: true}}
+	if _, err := blob.Sign(ctx); err == nil {
+		t.Errorf("Sign() with the signBlob type expected an error")
+	}
+}
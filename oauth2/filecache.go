@@ -0,0 +1,197 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+const (
+	defaultMinValidity = 5 * time.Minute
+	fileCacheVersion   = 1
+)
+
+// FileCache persists tokens across process restarts, encrypted with AES-256-GCM in one file per service account,
+// audience and claim set. Access to each file is serialized with a file lock so concurrent processes reuse the token
+// signed by the first one.
+type FileCache struct {
+	// Dir is the directory the tokens are stored in, created with 0700 permissions if needed
+	Dir string
+
+	// MinValidity is the time a cached token must still be valid for to be reused, defaults to 5 minutes
+	MinValidity time.Duration
+
+	aead cipher.AEAD
+}
+
+// NewFileCache returns a FileCache storing tokens in dir, or in the gcp-jwt-go directory of the user's cache
+// directory if blank, encrypted with the 32 bytes key. The key should come from a secret store or the OS keychain
+// rather than be stored next to the cache.
+func NewFileCache(dir string, key []byte) (*FileCache, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("gcpjwt/oauth2: file cache key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(userDir, "gcp-jwt-go")
+	}
+
+	return &FileCache{Dir: dir, aead: aead}, nil
+}
+
+// CachedJWTAccessTokenSource is like JWTAccessTokenSource but reuses the token persisted in the FileCache by a
+// previous run while it is valid for at least the cache's MinValidity, signing (and persisting) a new one otherwise.
+func CachedJWTAccessTokenSource(ctx context.Context, config *gcpjwt.IAMConfig, audience string, cache *FileCache) (oauth2.TokenSource, error) {
+	ctx = gcpjwt.NewIAMContext(ctx, config)
+	ts := &fileCacheTokenSource{
+		cache: cache,
+		key:   fileCacheKey(config, audience),
+		source: &jwtAccessTokenSource{
+			ctx:       ctx,
+			audience:  audience,
+			jwtConfig: config,
+		},
+	}
+	tok, err := ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.ReuseTokenSource(tok, ts), nil
+}
+
+// fileCacheKey identifies the claim set of the tokens of a jwtAccessTokenSource
+func fileCacheKey(config *gcpjwt.IAMConfig, audience string) string {
+	b, _ := json.Marshal([]interface{}{config.ServiceAccount, config.IAMType, audience})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+type fileCacheTokenSource struct {
+	cache  *FileCache
+	key    string
+	source oauth2.TokenSource
+}
+
+type cachedToken struct {
+	Version int           `json:"version"`
+	Key     string        `json:"key"`
+	Token   *oauth2.Token `json:"token"`
+}
+
+func (ts *fileCacheTokenSource) Token() (*oauth2.Token, error) {
+	if err := os.MkdirAll(ts.cache.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("gcpjwt/oauth2: could not create cache directory: %v", err)
+	}
+	path := filepath.Join(ts.cache.Dir, ts.key)
+
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("gcpjwt/oauth2: could not lock cache file: %v", err)
+	}
+	defer unlock()
+
+	// A missing, unreadable or undecryptable (e.g. after a key change) cache file is replaced
+	if tok, err := ts.cache.read(path, ts.key); err == nil && ts.cache.valid(tok) {
+		return tok, nil
+	}
+
+	tok, err := ts.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ts.cache.write(path, ts.key, tok); err != nil {
+		return nil, fmt.Errorf("gcpjwt/oauth2: could not write cache file: %v", err)
+	}
+	return tok, nil
+}
+
+func (c *FileCache) valid(tok *oauth2.Token) bool {
+	minValidity := c.MinValidity
+	if minValidity <= 0 {
+		minValidity = defaultMinValidity
+	}
+	return tok.AccessToken != "" && time.Now().Add(minValidity).Before(tok.Expiry)
+}
+
+func (c *FileCache) read(path, key string) (*oauth2.Token, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := c.aead.NonceSize()
+	if len(b) < nonceSize {
+		return nil, errors.New("cache file too short")
+	}
+	// The key is authenticated so files cannot be swapped between claim sets
+	plaintext, err := c.aead.Open(nil, b[:nonceSize], b[nonceSize:], []byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	cached := &cachedToken{}
+	if err := json.Unmarshal(plaintext, cached); err != nil {
+		return nil, err
+	}
+	if cached.Version != fileCacheVersion || cached.Key != key || cached.Token == nil {
+		return nil, errors.New("unexpected cache file content")
+	}
+	return cached.Token, nil
+}
+
+// write replaces the cache file atomically
+func (c *FileCache) write(path, key string, tok *oauth2.Token) error {
+	plaintext, err := json.Marshal(&cachedToken{Version: fileCacheVersion, Key: key, Token: tok})
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	ciphertext := c.aead.Seal(nonce, nonce, plaintext, []byte(key))
+
+	f, err := ioutil.TempFile(c.Dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(ciphertext); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
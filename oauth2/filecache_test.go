@@ -0,0 +1,145 @@
+package oauth2
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+type countingSource struct {
+	sync.Mutex
+	calls  int
+	expiry time.Duration
+}
+
+func (s *countingSource) Token() (*oauth2.Token, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.calls++
+	return &oauth2.Token{AccessToken: "token", TokenType: "Bearer", Expiry: time.Now().Add(s.expiry)}, nil
+}
+
+func TestFileCache(t *testing.T) {
+	dir := t.TempDir()
+	key := make([]byte, 32)
+	cache, err := NewFileCache(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &countingSource{expiry: time.Hour}
+	newSource := func(cache *FileCache, key string) *fileCacheTokenSource {
+		return &fileCacheTokenSource{cache: cache, key: key, source: source}
+	}
+
+	// Concurrent processes only sign once
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := newSource(cache, "a").Token(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if source.calls != 1 {
+		t.Errorf("source called %d times, want 1", source.calls)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) == "" || bytes.Contains(b, []byte("token")) {
+		t.Errorf("cache file is not encrypted: %q", b)
+	}
+
+	tests := []struct {
+		name      string
+		cache     func() *FileCache
+		key       string
+		wantCalls int
+	}{
+		{"Reused", func() *FileCache { return cache }, "a", 0},
+		{"OtherClaimSet", func() *FileCache { return cache }, "b", 1},
+		{"OtherEncryptionKey", func() *FileCache {
+			other, err := NewFileCache(dir, append(make([]byte, 31), 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return other
+		}, "a", 1},
+		{"NearExpiry", func() *FileCache {
+			return &FileCache{Dir: dir, MinValidity: 2 * time.Hour, aead: cache.aead}
+		}, "b", 1},
+	}
+	for _, tt := range tests {
+		calls := source.calls
+		if _, err := newSource(tt.cache(), tt.key).Token(); err != nil {
+			t.Fatalf("%s: Token() error = %v", tt.name, err)
+		}
+		if got := source.calls - calls; got != tt.wantCalls {
+			t.Errorf("%s: source called %d times, want %d", tt.name, got, tt.wantCalls)
+		}
+	}
+
+	if _, err := NewFileCache(dir, []byte("short")); err == nil {
+		t.Errorf("NewFileCache() expected error for a short key")
+	}
+}
+
+func TestCachedJWTAccessTokenSource(t *testing.T) {
+	ctx := context.Background()
+	config := &gcpjwt.IAMConfig{
+		ServiceAccount: "local@project.iam.gserviceaccount.com",
+		IAMType:        gcpjwt.IAMBlobType,
+		LocalMode:      true,
+	}
+	cache, err := NewFileCache(t.TempDir(), make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := CachedJWTAccessTokenSource(ctx, config, "https://api.example.com", cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstToken, err := first.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new process reuses the token
+	second, err := CachedJWTAccessTokenSource(ctx, config, "https://api.example.com", cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondToken, err := second.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstToken.AccessToken != secondToken.AccessToken || !firstToken.Expiry.Equal(secondToken.Expiry) {
+		t.Errorf("cached token not reused")
+	}
+
+	other, err := CachedJWTAccessTokenSource(ctx, config, "https://other.example.com", cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := other.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherToken.AccessToken == firstToken.AccessToken {
+		t.Errorf("token reused for another audience")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"golang.org/x/oauth2"
 
 	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+	"github.com/csmadhu/gcp-jwt-go/dpop"
 )
 
 // JWTAccessTokenSource returns a TokenSource that uses the IAM API to sign tokens.
@@ -36,22 +37,47 @@ func JWTAccessTokenSource(ctx context.Context, config *gcpjwt.IAMConfig, audienc
 	return oauth2.ReuseTokenSource(tok, ts), nil
 }
 
+// DPoPJWTAccessTokenSource is like JWTAccessTokenSource but the tokens are bound to the DPoP key with the provided JWK
+// thumbprint (see dpop.Signer.Thumbprint) using the `cnf.jkt` claim. Use it as the Source of a dpop.Transport.
+func DPoPJWTAccessTokenSource(ctx context.Context, config *gcpjwt.IAMConfig, audience, jkt string) (oauth2.TokenSource, error) {
+	ctx = gcpjwt.NewIAMContext(ctx, config)
+	ts := &jwtAccessTokenSource{
+		ctx:       ctx,
+		audience:  audience,
+		jwtConfig: config,
+		jkt:       jkt,
+	}
+	tok, err := ts.Token()
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.ReuseTokenSource(tok, ts), nil
+}
+
 type jwtAccessTokenSource struct {
 	ctx       context.Context
 	audience  string
 	jwtConfig *gcpjwt.IAMConfig
+	jkt       string
 }
 
 func (ts *jwtAccessTokenSource) Token() (*oauth2.Token, error) {
 	iat := time.Now()
 	exp := iat.Add(time.Hour)
-	claims := &jwt.StandardClaims{
-		Issuer:    ts.jwtConfig.ServiceAccount,
-		Subject:   ts.jwtConfig.ServiceAccount,
-		IssuedAt:  iat.Unix(),
-		NotBefore: iat.Unix(),
-		ExpiresAt: exp.Unix(),
-		Audience:  ts.audience,
+	claims := &dpop.BoundClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    ts.jwtConfig.ServiceAccount,
+			Subject:   ts.jwtConfig.ServiceAccount,
+			IssuedAt:  iat.Unix(),
+			NotBefore: iat.Unix(),
+			ExpiresAt: exp.Unix(),
+			Audience:  ts.audience,
+		},
+	}
+	tokenType := "Bearer"
+	if ts.jkt != "" {
+		claims.Confirmation = &dpop.Confirmation{JWKThumbprint: ts.jkt}
+		tokenType = dpop.AuthorizationScheme
 	}
 
 	var token *jwt.Token
@@ -80,5 +106,5 @@ func (ts *jwtAccessTokenSource) Token() (*oauth2.Token, error) {
 		at = strings.Join([]string{signingString, at}, ".")
 	}
 
-	return &oauth2.Token{AccessToken: at, TokenType: "Bearer", Expiry: exp}, nil
+	return &oauth2.Token{AccessToken: at, TokenType: tokenType, Expiry: exp}, nil
 }
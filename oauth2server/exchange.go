@@ -0,0 +1,328 @@
+package oauth2server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+// Token exchange grant type and token type identifiers
+// https://tools.ietf.org/html/rfc8693#section-3
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+	TokenTypeIDToken       = "urn:ietf:params:oauth:token-type:id_token"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+
+	defaultExchangeTTL = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidTarget is returned by an ExchangePolicy refusing the requested audience or resource
+	ErrInvalidTarget = errors.New("gcpjwt/oauth2server: requested audience or resource not allowed")
+	// ErrInvalidScope is returned by an ExchangePolicy refusing the requested scopes
+	ErrInvalidScope = errors.New("gcpjwt/oauth2server: requested scope not allowed")
+)
+
+// ExchangeRequest is a verified token exchange request.
+type ExchangeRequest struct {
+	// Request is the HTTP request
+	Request *http.Request
+
+	// SubjectClaims are the claims of the verified subject token
+	SubjectClaims jwt.MapClaims
+
+	// SubjectTokenType is the `subject_token_type` parameter
+	SubjectTokenType string
+
+	// ActorClaims are the claims of the verified actor token, nil if none was provided
+	ActorClaims jwt.MapClaims
+
+	// Audiences, Resources and Scopes are the requested `audience`, `resource` and `scope` parameters
+	Audiences []string
+	Resources []string
+	Scopes    []string
+
+	// RequestedTokenType is the `requested_token_type` parameter, TokenTypeAccessToken if not provided
+	RequestedTokenType string
+}
+
+// ExchangePolicy maps a token exchange request to the claims of the token to issue. Returning ErrInvalidTarget or
+// ErrInvalidScope refuses the request with the matching OAuth 2.0 error, other errors with `invalid_request`.
+// The `iss`, `iat`, `exp` and `jti` claims are set by the TokenExchangeHandler.
+type ExchangePolicy func(req *ExchangeRequest) (jwt.MapClaims, error)
+
+// DownscopePolicy is an ExchangePolicy issuing tokens for the subject of the subject token and exactly one of the
+// audiences, any audience if none is provided. The requested scopes must be a subset of the subject token's `scope`
+// claim, which is kept when no scope is requested. The actor, if any, is recorded in the `act` claim.
+func DownscopePolicy(audiences ...string) ExchangePolicy {
+	return func(req *ExchangeRequest) (jwt.MapClaims, error) {
+		if len(req.Audiences) != 1 || (len(audiences) > 0 && !contains(audiences, req.Audiences[0])) {
+			return nil, ErrInvalidTarget
+		}
+
+		sub, _ := req.SubjectClaims["sub"].(string)
+		if sub == "" {
+			return nil, errors.New("gcpjwt/oauth2server: subject token without subject")
+		}
+		claims := jwt.MapClaims{"sub": sub, "aud": req.Audiences[0]}
+
+		subjectScope, _ := req.SubjectClaims["scope"].(string)
+		if len(req.Scopes) > 0 {
+			granted := strings.Fields(subjectScope)
+			for _, scope := range req.Scopes {
+				if !contains(granted, scope) {
+					return nil, ErrInvalidScope
+				}
+			}
+			claims["scope"] = strings.Join(req.Scopes, " ")
+		} else if subjectScope != "" {
+			claims["scope"] = subjectScope
+		}
+
+		if req.ActorClaims != nil {
+			actor, _ := req.ActorClaims["sub"].(string)
+			claims["act"] = map[string]interface{}{"sub": actor}
+		}
+
+		return claims, nil
+	}
+}
+
+// TokenExchangeHandler is an http.Handler implementing the OAuth 2.0 Token Exchange grant (RFC 8693): the caller
+// POSTs a subject token verified with the Keyfunc and gets back a short-lived token with the claims returned by the
+// Policy, signed with Cloud KMS. Use gcpjwt.MultiVerifyKeyfunc to accept subject tokens of several issuers, e.g.
+// Google ID tokens along with tokens signed by the IAM API for a service account.
+//
+// https://tools.ietf.org/html/rfc8693
+type TokenExchangeHandler struct {
+	// Authenticate, if set, authenticates the client calling the endpoint
+	Authenticate Authenticator
+
+	// Keyfunc selects the key to verify subject and actor tokens with
+	Keyfunc jwt.Keyfunc
+
+	// Registry, if set, resolves the signing method of subject and actor tokens instead of the global jwt-go registry
+	Registry *gcpjwt.Registry
+
+	// Audiences are the accepted `aud` claims of subject tokens, required so tokens issued for other services cannot
+	// be exchanged
+	Audiences []string
+
+	// Issuers, if not empty, are the accepted `iss` claims of subject tokens
+	Issuers []string
+
+	// Revocations, if set, rejects revoked subject and actor tokens
+	Revocations gcpjwt.RevocationChecker
+
+	// Policy maps requests to the claims of the issued tokens, required
+	Policy ExchangePolicy
+
+	// Method and KMSConfig sign the issued tokens, their `kid` header is KMSConfig.KeyID() and their `alg` header the
+	// standard one of the Method, as published by a JWKSHandler
+	Method    *gcpjwt.SigningMethodKMS
+	KMSConfig *gcpjwt.KMSConfig
+
+	// Issuer is the `iss` claim of the issued tokens
+	Issuer string
+
+	// TTL is the lifetime of the issued tokens, defaults to 5 minutes. Issued tokens never outlive the subject token.
+	TTL time.Duration
+}
+
+// NewTokenExchangeHandler returns a TokenExchangeHandler verifying subject tokens for one of the audiences with the
+// keyFunc and issuing tokens with the claims of the policy, signed with the method and KMS key for the issuer.
+func NewTokenExchangeHandler(keyFunc jwt.Keyfunc, audiences []string, policy ExchangePolicy, method *gcpjwt.SigningMethodKMS, config *gcpjwt.KMSConfig, issuer string) *TokenExchangeHandler {
+	return &TokenExchangeHandler{
+		Keyfunc:   keyFunc,
+		Audiences: audiences,
+		Policy:    policy,
+		Method:    method,
+		KMSConfig: config,
+		Issuer:    issuer,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *TokenExchangeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(h.Audiences) == 0 {
+		writeError(w, http.StatusInternalServerError, "server_error", "no accepted subject token audiences configured")
+		return
+	}
+
+	if h.Authenticate != nil && h.Authenticate(r) != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token", Bearer`)
+		writeError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "could not parse form")
+		return
+	}
+	if r.PostForm.Get("grant_type") != GrantTypeTokenExchange {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	req := &ExchangeRequest{
+		Request:            r,
+		SubjectTokenType:   r.PostForm.Get("subject_token_type"),
+		Audiences:          r.PostForm["audience"],
+		Resources:          r.PostForm["resource"],
+		Scopes:             strings.Fields(r.PostForm.Get("scope")),
+		RequestedTokenType: r.PostForm.Get("requested_token_type"),
+	}
+	if req.RequestedTokenType == "" {
+		req.RequestedTokenType = TokenTypeAccessToken
+	}
+	if req.RequestedTokenType != TokenTypeAccessToken && req.RequestedTokenType != TokenTypeJWT {
+		writeError(w, http.StatusBadRequest, "invalid_request", "unsupported requested_token_type")
+		return
+	}
+
+	subjectToken := r.PostForm.Get("subject_token")
+	if subjectToken == "" || !supportedTokenType(req.SubjectTokenType) {
+		writeError(w, http.StatusBadRequest, "invalid_request", "missing or unsupported subject_token")
+		return
+	}
+	var err error
+	if req.SubjectClaims, err = h.verify(subjectToken, true); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid subject_token")
+		return
+	}
+
+	if actorToken := r.PostForm.Get("actor_token"); actorToken != "" {
+		if !supportedTokenType(r.PostForm.Get("actor_token_type")) {
+			writeError(w, http.StatusBadRequest, "invalid_request", "unsupported actor_token_type")
+			return
+		}
+		if req.ActorClaims, err = h.verify(actorToken, false); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "invalid actor_token")
+			return
+		}
+	}
+
+	claims, err := h.Policy(req)
+	switch err {
+	case nil:
+	case ErrInvalidTarget:
+		writeError(w, http.StatusBadRequest, "invalid_target", "")
+		return
+	case ErrInvalidScope:
+		writeError(w, http.StatusBadRequest, "invalid_scope", "")
+		return
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_request", "request refused by policy")
+		return
+	}
+
+	tokenString, expires, err := h.issue(r, claims, req.SubjectClaims)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	response := map[string]interface{}{
+		"access_token":      tokenString,
+		"issued_token_type": req.RequestedTokenType,
+		"token_type":        "Bearer",
+		"expires_in":        int64(time.Until(expires).Seconds()),
+	}
+	if req.RequestedTokenType != TokenTypeAccessToken {
+		response["token_type"] = "N_A"
+	}
+	if scope, ok := claims["scope"].(string); ok && scope != "" {
+		response["scope"] = scope
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *TokenExchangeHandler) verify(tokenString string, subject bool) (jwt.MapClaims, error) {
+	keyFunc := h.Keyfunc
+	if h.Registry != nil {
+		keyFunc = h.Registry.Keyfunc(keyFunc)
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("gcpjwt/oauth2server: invalid token")
+	}
+
+	if subject {
+		if err := verifyClaimsPolicy(claims, h.Audiences, h.Issuers); err != nil {
+			return nil, err
+		}
+	}
+	if err := gcpjwt.CheckRevocation(context.Background(), h.Revocations, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// issue signs the token with the claims, expiring after the TTL or with the subject token
+func (h *TokenExchangeHandler) issue(r *http.Request, claims, subjectClaims jwt.MapClaims) (string, time.Time, error) {
+	ttl := h.TTL
+	if ttl <= 0 {
+		ttl = defaultExchangeTTL
+	}
+	now := time.Now()
+	expires := now.Add(ttl)
+	if exp, ok := subjectClaims["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(expires) {
+		expires = time.Unix(int64(exp), 0)
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", time.Time{}, err
+	}
+
+	issued := make(jwt.MapClaims, len(claims)+4)
+	for k, v := range claims {
+		issued[k] = v
+	}
+	issued["iss"] = h.Issuer
+	issued["iat"] = now.Unix()
+	issued["exp"] = expires.Unix()
+	issued["jti"] = base64.RawURLEncoding.EncodeToString(jti)
+
+	token := jwt.NewWithClaims(h.Method.Overridden(), issued)
+	token.Header["kid"] = h.KMSConfig.KeyID()
+	tokenString, err := token.SignedString(gcpjwt.NewKMSContext(r.Context(), h.KMSConfig))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return tokenString, expires, nil
+}
+
+func supportedTokenType(tokenType string) bool {
+	return tokenType == TokenTypeJWT || tokenType == TokenTypeIDToken || tokenType == TokenTypeAccessToken
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package oauth2server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+func TestTokenExchangeHandler(t *testing.T) {
+	ctx := context.Background()
+	secret := []byte("secret")
+	config := &gcpjwt.KMSConfig{KeyPath: "projects/p/locations/l/keyRings/r/cryptoKeys/sts/cryptoKeyVersions/1", LocalMode: true}
+	handler := NewTokenExchangeHandler(func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, []string{"https://sts.example.com"}, DownscopePolicy("https://backend.example.com"), gcpjwt.SigningMethodKMSES256, config, "https://sts.example.com")
+	handler.Issuers = []string{"issuer"}
+
+	issuedKeyfunc, err := gcpjwt.KMSVerfiyKeyfunc(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := gcpjwt.KMSJWK(ctx, config, gcpjwt.SigningMethodKMSES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+	subject := sign(jwt.MapClaims{"iss": "issuer", "aud": "https://sts.example.com", "sub": "user", "exp": exp, "scope": "read write"})
+	actor := sign(jwt.MapClaims{"iss": "issuer", "sub": "gateway"})
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		wantStatus int
+		wantError  string
+		wantClaims jwt.MapClaims
+	}{
+		{
+			"Downscoped",
+			url.Values{"grant_type": {GrantTypeTokenExchange}, "subject_token": {subject}, "subject_token_type": {TokenTypeJWT}, "audience": {"https://backend.example.com"}, "scope": {"read"}},
+			http.StatusOK,
+			"",
+			jwt.MapClaims{"iss": "https://sts.example.com", "sub": "user", "aud": "https://backend.example.com", "scope": "read", "exp": float64(exp)},
+		},
+		{
+			"Actor",
+			url.Values{"grant_type": {GrantTypeTokenExchange}, "subject_token": {subject}, "subject_token_type": {TokenTypeAccessToken}, "audience": {"https://backend.example.com"}, "actor_token": {actor}, "actor_token_type": {TokenTypeJWT}},
+			http.StatusOK,
+			"",
+			jwt.MapClaims{"sub": "user", "scope": "read write", "act": map[string]interface{}{"sub": "gateway"}},
+		},
+		{
+			"WrongGrantType",
+			url.Values{"grant_type": {"client_credentials"}, "subject_token": {subject}, "subject_token_type": {TokenTypeJWT}},
+			http.StatusBadRequest,
+			"unsupported_grant_type",
+			nil,
+		},
+		{
+			"UnknownAudience",
+			url.Values{"grant_type": {GrantTypeTokenExchange}, "subject_token": {subject}, "subject_token_type": {TokenTypeJWT}, "audience": {"https://other.example.com"}},
+			http.StatusBadRequest,
+			"invalid_target",
+			nil,
+		},
+		{
+			"ScopeEscalation",
+			url.Values{"grant_type": {GrantTypeTokenExchange}, "subject_token": {subject}, "subject_token_type": {TokenTypeJWT}, "audience": {"https://backend.example.com"}, "scope": {"read admin"}},
+			http.StatusBadRequest,
+			"invalid_scope",
+			nil,
+		},
+		{
+			"WrongSubjectAudience",
+			url.Values{"grant_type": {GrantTypeTokenExchange}, "subject_token": {sign(jwt.MapClaims{"iss": "issuer", "aud": "https://other.example.com", "sub": "user"})}, "subject_token_type": {TokenTypeJWT}, "audience": {"https://backend.example.com"}},
+			http.StatusBadRequest,
+			"invalid_request",
+			nil,
+		},
+		{
+			"UntrustedIssuer",
+			url.Values{"grant_type": {GrantTypeTokenExchange}, "subject_token": {sign(jwt.MapClaims{"iss": "other", "aud": "https://sts.example.com", "sub": "user"})}, "subject_token_type": {TokenTypeJWT}, "audience": {"https://backend.example.com"}},
+			http.StatusBadRequest,
+			"invalid_request",
+			nil,
+		},
+		{
+			"UnsupportedTokenType",
+			url.Values{"grant_type": {GrantTypeTokenExchange}, "subject_token": {subject}, "subject_token_type": {"urn:ietf:params:oauth:token-type:saml2"}, "audience": {"https://backend.example.com"}},
+			http.StatusBadRequest,
+			"invalid_request",
+			nil,
+		},
+		{
+			"InvalidActor",
+			url.Values{"grant_type": {GrantTypeTokenExchange}, "subject_token": {subject}, "subject_token_type": {TokenTypeJWT}, "audience": {"https://backend.example.com"}, "actor_token": {"not.a.token"}, "actor_token_type": {TokenTypeJWT}},
+			http.StatusBadRequest,
+			"invalid_request",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			response := map[string]interface{}{}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if response["error"] != tt.wantError {
+					t.Errorf("error = %v, want %v", response["error"], tt.wantError)
+				}
+				return
+			}
+
+			if response["issued_token_type"] != TokenTypeAccessToken || response["token_type"] != "Bearer" {
+				t.Errorf("unexpected response %v", response)
+			}
+			if expiresIn, _ := response["expires_in"].(float64); expiresIn <= 0 || expiresIn > 60 {
+				t.Errorf("expires_in = %v, want at most the subject token's lifetime", response["expires_in"])
+			}
+
+			tokenString, _ := response["access_token"].(string)
+			claims := jwt.MapClaims{}
+			token, err := gcpjwt.NewRegistry(gcpjwt.SigningMethodKMSES256.Overridden()).ParseWithClaims(tokenString, claims, issuedKeyfunc)
+			if err != nil || !token.Valid {
+				t.Fatalf("issued token invalid: %v", err)
+			}
+			if token.Header["kid"] != config.KeyID() || claims["jti"] == nil {
+				t.Errorf("unexpected issued token %v %v", token.Header, claims)
+			}
+			if token.Header["alg"] != jwk.Algorithm {
+				t.Errorf("alg = %v, want %v as published", token.Header["alg"], jwk.Algorithm)
+			}
+			for k, v := range tt.wantClaims {
+				if b, _ := json.Marshal(claims[k]); string(b) != mustMarshal(t, v) {
+					t.Errorf("claim %s = %v, want %v", k, claims[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenExchangeHandler_NoAudiences(t *testing.T) {
+	config := &gcpjwt.KMSConfig{KeyPath: "projects/p/locations/l/keyRings/r/cryptoKeys/sts/cryptoKeyVersions/1", LocalMode: true}
+	handler := NewTokenExchangeHandler(func(token *jwt.Token) (interface{}, error) {
+		return []byte("secret"), nil
+	}, nil, DownscopePolicy("https://backend.example.com"), gcpjwt.SigningMethodKMSES256, config, "https://sts.example.com")
+
+	subject, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user"}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"grant_type": {GrantTypeTokenExchange}, "subject_token": {subject}, "subject_token_type": {TokenTypeJWT}, "audience": {"https://backend.example.com"}}
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusInternalServerError, w.Body)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
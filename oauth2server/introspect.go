@@ -0,0 +1,244 @@
+// Package oauth2server has OAuth 2.0 authorization server endpoints backed by the gcpjwt signing methods and keyfuncs,
+// for clients that cannot verify tokens themselves or need to obtain them with a standard OAuth 2.0 flow.
+package oauth2server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	cache "github.com/patrickmn/go-cache"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+var (
+	// ErrUnauthenticated is returned by an Authenticator when the caller did not provide valid credentials
+	ErrUnauthenticated = errors.New("gcpjwt/oauth2server: invalid client credentials")
+)
+
+// Authenticator authenticates the caller of an endpoint, returning ErrUnauthenticated (or another error) to reject it.
+type Authenticator func(r *http.Request) error
+
+// BasicAuthenticator authenticates callers with HTTP Basic authentication against the client id -> secret map.
+func BasicAuthenticator(clients map[string]string) Authenticator {
+	return func(r *http.Request) error {
+		id, secret, ok := r.BasicAuth()
+		if !ok {
+			return ErrUnauthenticated
+		}
+		expected, known := clients[id]
+		if !known || subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) != 1 {
+			return ErrUnauthenticated
+		}
+		return nil
+	}
+}
+
+// BearerAuthenticator authenticates callers with a bearer token verified with the keyFunc (e.g.
+// gcpjwt.IAMVerfiyKeyfunc for a calling service account) for the audience.
+func BearerAuthenticator(keyFunc jwt.Keyfunc, audience string) Authenticator {
+	return func(r *http.Request) error {
+		tokenString, ok := bearerToken(r.Header.Get("Authorization"))
+		if !ok {
+			return ErrUnauthenticated
+		}
+		claims := &jwt.StandardClaims{}
+		token, err := jwt.ParseWithClaims(tokenString, claims, keyFunc)
+		if err != nil || !token.Valid || !claims.VerifyAudience(audience, true) {
+			return ErrUnauthenticated
+		}
+		return nil
+	}
+}
+
+// IntrospectionHandler is an http.Handler implementing OAuth 2.0 Token Introspection (RFC 7662): the authenticated
+// caller POSTs a `token` form parameter and gets back whether the token is active along with its claims. Responses
+// for active tokens are cached in memory until the token expires.
+//
+// https://tools.ietf.org/html/rfc7662
+type IntrospectionHandler struct {
+	// Authenticate authenticates the caller of the endpoint, required
+	Authenticate Authenticator
+
+	// Keyfunc selects the key to verify tokens with, e.g. gcpjwt.IAMVerfiyKeyfunc, gcpjwt.KMSVerfiyKeyfunc or the
+	// Keyfunc of a gcpjwt.Verifier
+	Keyfunc jwt.Keyfunc
+
+	// Registry, if set, resolves the signing method of tokens instead of the global jwt-go registry
+	Registry *gcpjwt.Registry
+
+	// Audiences, if not empty, are the accepted `aud` claims
+	Audiences []string
+
+	// Issuers, if not empty, are the accepted `iss` claims
+	Issuers []string
+
+	// Validate, if set, is an additional claims policy, tokens it returns an error for are inactive
+	Validate func(claims jwt.MapClaims) error
+
+	// Revocations, if set, makes revoked tokens inactive, including those with a cached response
+	Revocations gcpjwt.RevocationChecker
+
+	// DisableCache disables the caching of active responses
+	DisableCache bool
+
+	responses *cache.Cache
+}
+
+// NewIntrospectionHandler returns an IntrospectionHandler verifying tokens with the keyFunc for callers authenticated
+// by authenticate.
+func NewIntrospectionHandler(keyFunc jwt.Keyfunc, authenticate Authenticator) *IntrospectionHandler {
+	return &IntrospectionHandler{
+		Authenticate: authenticate,
+		Keyfunc:      keyFunc,
+		responses:    cache.New(0, 10*time.Minute),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *IntrospectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.Authenticate == nil || h.Authenticate(r) != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspection", Bearer`)
+		writeError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	tokenString := r.PostFormValue("token")
+	if tokenString == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "missing token parameter")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.Introspect(tokenString))
+}
+
+// Introspect returns the introspection response for the token: its claims with `active` set to true if it is valid
+// and accepted by the claims policy, or only `active` set to false otherwise.
+func (h *IntrospectionHandler) Introspect(tokenString string) map[string]interface{} {
+	sum := sha256.Sum256([]byte(tokenString))
+	cacheKey := hex.EncodeToString(sum[:])
+	if h.responses != nil && !h.DisableCache {
+		if response, found := h.responses.Get(cacheKey); found {
+			if gcpjwt.CheckRevocation(context.Background(), h.Revocations, jwt.MapClaims(response.(map[string]interface{}))) != nil {
+				return map[string]interface{}{"active": false}
+			}
+			return response.(map[string]interface{})
+		}
+	}
+
+	claims, err := h.verify(tokenString)
+	if err != nil {
+		return map[string]interface{}{"active": false}
+	}
+
+	response := make(map[string]interface{}, len(claims)+2)
+	for k, v := range claims {
+		response[k] = v
+	}
+	response["active"] = true
+	if _, ok := response["token_type"]; !ok {
+		response["token_type"] = "Bearer"
+	}
+
+	if exp, ok := claims["exp"].(float64); ok && h.responses != nil && !h.DisableCache {
+		if ttl := time.Until(time.Unix(int64(exp), 0)); ttl > 0 {
+			h.responses.Set(cacheKey, response, ttl)
+		}
+	}
+
+	return response
+}
+
+func (h *IntrospectionHandler) verify(tokenString string) (jwt.MapClaims, error) {
+	keyFunc := h.Keyfunc
+	if h.Registry != nil {
+		keyFunc = h.Registry.Keyfunc(keyFunc)
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("gcpjwt/oauth2server: invalid token")
+	}
+
+	if err := verifyClaimsPolicy(claims, h.Audiences, h.Issuers); err != nil {
+		return nil, err
+	}
+	if h.Validate != nil {
+		if err := h.Validate(claims); err != nil {
+			return nil, err
+		}
+	}
+	if err := gcpjwt.CheckRevocation(context.Background(), h.Revocations, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// verifyClaimsPolicy checks the token has one of the audiences and issuers, when not empty
+func verifyClaimsPolicy(claims jwt.MapClaims, audiences, issuers []string) error {
+	if len(audiences) > 0 {
+		accepted := false
+		for _, aud := range audiences {
+			accepted = accepted || claims.VerifyAudience(aud, true)
+		}
+		if !accepted {
+			return errors.New("gcpjwt/oauth2server: audience not accepted")
+		}
+	}
+	if len(issuers) > 0 {
+		accepted := false
+		for _, iss := range issuers {
+			accepted = accepted || claims.VerifyIssuer(iss, true)
+		}
+		if !accepted {
+			return errors.New("gcpjwt/oauth2server: issuer not accepted")
+		}
+	}
+	return nil
+}
+
+// writeError writes an OAuth 2.0 error response
+// https://tools.ietf.org/html/rfc6749#section-5.2
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	response := map[string]string{"error": code}
+	if description != "" {
+		response["error_description"] = description
+	}
+	writeJSON(w, status, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// bearerToken extracts the token of a Bearer authorization header
+func bearerToken(authorization string) (string, bool) {
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[len(prefix):]), true
+}
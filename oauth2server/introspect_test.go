@@ -0,0 +1,107 @@
+package oauth2server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestIntrospectionHandler(t *testing.T) {
+	secret := []byte("secret")
+	var keyCalls int
+	handler := NewIntrospectionHandler(func(token *jwt.Token) (interface{}, error) {
+		keyCalls++
+		return secret, nil
+	}, BasicAuthenticator(map[string]string{"client": "password"}))
+	handler.Audiences = []string{"https://api.example.com"}
+	handler.Issuers = []string{"issuer"}
+	handler.Validate = func(claims jwt.MapClaims) error {
+		if claims["sub"] == "blocked" {
+			return errors.New("blocked")
+		}
+		return nil
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := sign(jwt.MapClaims{"iss": "issuer", "aud": "https://api.example.com", "sub": "user", "exp": exp, "scope": "read"})
+
+	tests := []struct {
+		name       string
+		method     string
+		user       string
+		password   string
+		token      string
+		wantStatus int
+		wantActive bool
+	}{
+		{"Active", http.MethodPost, "client", "password", valid, http.StatusOK, true},
+		{"Expired", http.MethodPost, "client", "password", sign(jwt.MapClaims{"iss": "issuer", "aud": "https://api.example.com", "exp": time.Now().Add(-time.Hour).Unix()}), http.StatusOK, false},
+		{"WrongAudience", http.MethodPost, "client", "password", sign(jwt.MapClaims{"iss": "issuer", "aud": "other", "exp": exp}), http.StatusOK, false},
+		{"WrongIssuer", http.MethodPost, "client", "password", sign(jwt.MapClaims{"iss": "other", "aud": "https://api.example.com", "exp": exp}), http.StatusOK, false},
+		{"Policy", http.MethodPost, "client", "password", sign(jwt.MapClaims{"iss": "issuer", "aud": "https://api.example.com", "sub": "blocked", "exp": exp}), http.StatusOK, false},
+		{"Malformed", http.MethodPost, "client", "password", "not.a.token", http.StatusOK, false},
+		{"MissingToken", http.MethodPost, "client", "password", "", http.StatusBadRequest, false},
+		{"WrongSecret", http.MethodPost, "client", "wrong", valid, http.StatusUnauthorized, false},
+		{"UnknownClient", http.MethodPost, "other", "password", valid, http.StatusUnauthorized, false},
+		{"Get", http.MethodGet, "client", "password", valid, http.StatusMethodNotAllowed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.token != "" {
+				form.Set("token", tt.token)
+			}
+			r := httptest.NewRequest(tt.method, "/introspect", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth(tt.user, tt.password)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			response := map[string]interface{}{}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response["active"] != tt.wantActive {
+				t.Errorf("active = %v, want %v", response["active"], tt.wantActive)
+			}
+			if !tt.wantActive && len(response) != 1 {
+				t.Errorf("inactive response has claims: %v", response)
+			}
+			if tt.wantActive && (response["sub"] != "user" || response["scope"] != "read" || response["token_type"] != "Bearer") {
+				t.Errorf("unexpected response %v", response)
+			}
+		})
+	}
+
+	// Active responses are cached
+	keyCalls = 0
+	for i := 0; i < 3; i++ {
+		if response := handler.Introspect(valid); response["active"] != true {
+			t.Fatalf("Introspect() = %v", response)
+		}
+	}
+	if keyCalls != 0 {
+		t.Errorf("token verified %d times, want cached response", keyCalls)
+	}
+}
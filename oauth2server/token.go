@@ -0,0 +1,324 @@
+package oauth2server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	cache "github.com/patrickmn/go-cache"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+const (
+	// GrantTypeClientCredentials is the OAuth 2.0 client credentials grant type
+	GrantTypeClientCredentials = "client_credentials"
+	// ClientAssertionTypeJWTBearer is the client assertion type of the private_key_jwt client authentication method
+	// https://tools.ietf.org/html/rfc7523#section-2.2
+	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	defaultTokenTTL = time.Hour
+)
+
+// Client is a client of a TokenHandler, authenticated with its Secret (client_secret_basic or client_secret_post) or
+// a JWT signed with its private key (private_key_jwt) verified with its Keyfunc, e.g. gcpjwt.IAMVerfiyKeyfunc for a
+// service account of the client.
+type Client struct {
+	// ID is the client id, the `iss` and `sub` claims of its client assertions
+	ID string
+
+	// Secret, if not empty, authenticates the client
+	Secret string
+
+	// Keyfunc, if set, verifies the client assertions of the client
+	Keyfunc jwt.Keyfunc
+
+	// Scopes are the scopes the client may request, all of them are granted when none is requested
+	Scopes []string
+
+	// Audience is the `aud` claim of the client's access tokens
+	Audience string
+
+	// TTL, if set, is the lifetime of the client's access tokens instead of the TokenHandler's
+	TTL time.Duration
+}
+
+// TokenHandler is an http.Handler implementing the token endpoint of the OAuth 2.0 client credentials grant, issuing
+// JWT access tokens signed with the Method and Key, e.g. gcpjwt.SigningMethodKMSES256 and a context from
+// gcpjwt.NewKMSContext, or gcpjwt.SigningMethodIAMJWT and a context from gcpjwt.NewIAMContext. Publish the matching
+// keys with a JWKSHandler. The `alg` header of tokens signed with a *gcpjwt.SigningMethodKMS, *gcpjwt.SigningMethodIAM
+// or *gcpjwt.SigningMethodAppEngineImpl is the standard one of its Overridden method, e.g. ES256, matching the
+// published keys.
+//
+// https://tools.ietf.org/html/rfc6749#section-4.4
+type TokenHandler struct {
+	// Clients by client id
+	Clients map[string]*Client
+
+	// Method and Key sign the access tokens
+	Method jwt.SigningMethod
+	Key    interface{}
+
+	// KeyID, if not empty, is the `kid` header of the access tokens, e.g. KMSConfig.KeyID()
+	KeyID string
+
+	// Issuer is the `iss` claim of the access tokens
+	Issuer string
+
+	// TokenURL is the URL of the endpoint, accepted along with the Issuer as the `aud` claim of client assertions
+	TokenURL string
+
+	// Registry, if set, resolves the signing method of client assertions instead of the global jwt-go registry
+	Registry *gcpjwt.Registry
+
+	// TTL is the lifetime of the access tokens, defaults to 1 hour
+	TTL time.Duration
+
+	// used client assertion ids, rejected until the assertion expires
+	assertions     *cache.Cache
+	assertionsOnce sync.Once
+}
+
+// NewTokenHandler returns a TokenHandler issuing access tokens signed with the method and key for the issuer to the
+// clients.
+func NewTokenHandler(method jwt.SigningMethod, key interface{}, issuer string, clients ...*Client) *TokenHandler {
+	h := &TokenHandler{
+		Clients: make(map[string]*Client, len(clients)),
+		Method:  method,
+		Key:     key,
+		Issuer:  issuer,
+	}
+	for _, client := range clients {
+		h.Clients[client.ID] = client
+	}
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "could not parse form")
+		return
+	}
+
+	client, err := h.authenticate(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		writeError(w, http.StatusUnauthorized, "invalid_client", "")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != GrantTypeClientCredentials {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	scopes := strings.Fields(r.PostForm.Get("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !contains(client.Scopes, scope) {
+			writeError(w, http.StatusBadRequest, "invalid_scope", "")
+			return
+		}
+	}
+
+	tokenString, ttl, err := h.issue(client, scopes)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	response := map[string]interface{}{
+		"access_token": tokenString,
+		"token_type":   "Bearer",
+		"expires_in":   int64(ttl.Seconds()),
+	}
+	if len(scopes) > 0 {
+		response["scope"] = strings.Join(scopes, " ")
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// authenticate returns the client authenticated with a client assertion, HTTP Basic authentication or the
+// client_id and client_secret form parameters
+func (h *TokenHandler) authenticate(r *http.Request) (*Client, error) {
+	if assertion := r.PostForm.Get("client_assertion"); assertion != "" {
+		if r.PostForm.Get("client_assertion_type") != ClientAssertionTypeJWTBearer {
+			return nil, ErrUnauthenticated
+		}
+		return h.verifyAssertion(assertion, r.PostForm.Get("client_id"))
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, known := h.Clients[id]
+	if !known || client.Secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) != 1 {
+		return nil, ErrUnauthenticated
+	}
+	return client, nil
+}
+
+// verifyAssertion verifies a private_key_jwt client assertion
+// https://openid.net/specs/openid-connect-core-1_0.html#ClientAuthentication
+func (h *TokenHandler) verifyAssertion(assertion, clientID string) (*Client, error) {
+	claims := &jwt.StandardClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(assertion, claims); err != nil {
+		return nil, ErrUnauthenticated
+	}
+	client, known := h.Clients[claims.Issuer]
+	if !known || client.Keyfunc == nil || claims.Subject != claims.Issuer || (clientID != "" && clientID != claims.Issuer) {
+		return nil, ErrUnauthenticated
+	}
+
+	keyFunc := client.Keyfunc
+	if h.Registry != nil {
+		keyFunc = h.Registry.Keyfunc(keyFunc)
+	}
+	claims = &jwt.StandardClaims{}
+	token, err := jwt.ParseWithClaims(assertion, claims, keyFunc)
+	if err != nil || !token.Valid || claims.ExpiresAt == 0 || claims.Id == "" {
+		return nil, ErrUnauthenticated
+	}
+	if !(h.TokenURL != "" && claims.VerifyAudience(h.TokenURL, true)) && !(h.Issuer != "" && claims.VerifyAudience(h.Issuer, true)) {
+		return nil, ErrUnauthenticated
+	}
+
+	// Assertions can only be used once
+	h.assertionsOnce.Do(func() {
+		h.assertions = cache.New(0, 10*time.Minute)
+	})
+	if err := h.assertions.Add(client.ID+"\n"+claims.Id, true, time.Until(time.Unix(claims.ExpiresAt, 0))); err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	return client, nil
+}
+
+func (h *TokenHandler) issue(client *Client, scopes []string) (string, time.Duration, error) {
+	ttl := client.TTL
+	if ttl <= 0 {
+		ttl = h.TTL
+	}
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", 0, err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":       h.Issuer,
+		"sub":       client.ID,
+		"client_id": client.ID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(ttl).Unix(),
+		"jti":       base64.RawURLEncoding.EncodeToString(jti),
+	}
+	if client.Audience != "" {
+		claims["aud"] = client.Audience
+	}
+	if len(scopes) > 0 {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+
+	token := jwt.NewWithClaims(overridden(h.Method), claims)
+	if h.KeyID != "" {
+		token.Header["kid"] = h.KeyID
+	}
+	tokenString, err := token.SignedString(h.Key)
+	if err != nil {
+		return "", 0, err
+	}
+	return tokenString, ttl, nil
+}
+
+// overridden returns the method using the standard algorithm identifier for the gcpjwt signing methods
+func overridden(method jwt.SigningMethod) jwt.SigningMethod {
+	switch m := method.(type) {
+	case *gcpjwt.SigningMethodKMS:
+		return m.Overridden()
+	case *gcpjwt.SigningMethodIAM:
+		return m.Overridden()
+	case *gcpjwt.SigningMethodAppEngineImpl:
+		return m.Overridden()
+	}
+	return method
+}
+
+// JWKSHandler is an http.Handler publishing the JWK Set of the keys access tokens are signed with, e.g. built with
+// gcpjwt.KMSJWK or gcpjwt.IAMJWKSet.
+type JWKSHandler struct {
+	// Keys returns the JWK Set, required
+	Keys func(ctx context.Context) (*gcpjwt.JWKSet, error)
+
+	// MaxAge is the duration clients may cache the JWK Set for, defaults to 0 (no caching)
+	MaxAge time.Duration
+}
+
+// NewJWKSHandler returns a JWKSHandler publishing the JWK Set of the KMS key versions for the signing method. The
+// public keys are retrieved once.
+func NewJWKSHandler(ctx context.Context, method *gcpjwt.SigningMethodKMS, configs ...*gcpjwt.KMSConfig) (*JWKSHandler, error) {
+	set := &gcpjwt.JWKSet{Keys: make([]*gcpjwt.JWK, 0, len(configs))}
+	for _, config := range configs {
+		jwk, err := gcpjwt.KMSJWK(ctx, config, method)
+		if err != nil {
+			return nil, fmt.Errorf("gcpjwt/oauth2server: could not get public key of `%s`: %v", config.KeyPath, err)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return &JWKSHandler{
+		Keys: func(ctx context.Context) (*gcpjwt.JWKSet, error) {
+			return set, nil
+		},
+	}, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *JWKSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	set, err := h.Keys(r.Context())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	b, err := json.Marshal(set)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	if h.MaxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(h.MaxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Write(b)
+}
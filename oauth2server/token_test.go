@@ -0,0 +1,191 @@
+package oauth2server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+func TestTokenHandler(t *testing.T) {
+	ctx := context.Background()
+	const (
+		issuer   = "https://auth.example.com"
+		tokenURL = "https://auth.example.com/token"
+		partner  = "partner@project.iam.gserviceaccount.com"
+	)
+	config := &gcpjwt.KMSConfig{KeyPath: "projects/p/locations/l/keyRings/r/cryptoKeys/tokens/cryptoKeyVersions/1", LocalMode: true}
+	partnerConfig := &gcpjwt.IAMConfig{ServiceAccount: partner, LocalMode: true}
+
+	handler := NewTokenHandler(gcpjwt.SigningMethodKMSES256, gcpjwt.NewKMSContext(ctx, config), issuer,
+		&Client{ID: "secret-client", Secret: "password", Scopes: []string{"read", "write"}, Audience: "https://api.example.com"},
+		&Client{ID: partner, Keyfunc: gcpjwt.IAMVerfiyKeyfunc(ctx, partnerConfig), Scopes: []string{"read"}, TTL: 10 * time.Minute},
+	)
+	handler.KeyID = config.KeyID()
+	handler.TokenURL = tokenURL
+
+	jwks, err := NewJWKSHandler(ctx, gcpjwt.SigningMethodKMSES256, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	jwks.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jwks", nil))
+	set := &gcpjwt.JWKSet{}
+	if err := json.NewDecoder(w.Body).Decode(set); err != nil || len(set.Keys) != 1 {
+		t.Fatalf("JWKSHandler returned %v, %v", set, err)
+	}
+	publicKey, err := set.Keys[0].PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion := func(aud, jti string) string {
+		tokenString, err := jwt.NewWithClaims(gcpjwt.SigningMethodIAMBlob, &jwt.StandardClaims{
+			Issuer:    partner,
+			Subject:   partner,
+			Audience:  aud,
+			Id:        jti,
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		}).SignedString(gcpjwt.NewIAMContext(ctx, partnerConfig))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+	replayed := assertion(tokenURL, "replayed")
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		user       string
+		password   string
+		wantStatus int
+		wantError  string
+		wantClaims jwt.MapClaims
+	}{
+		{"Basic", url.Values{"grant_type": {"client_credentials"}, "scope": {"read"}}, "secret-client", "password", http.StatusOK, "", jwt.MapClaims{"sub": "secret-client", "aud": "https://api.example.com", "scope": "read"}},
+		{"Post", url.Values{"grant_type": {"client_credentials"}, "client_id": {"secret-client"}, "client_secret": {"password"}}, "", "", http.StatusOK, "", jwt.MapClaims{"sub": "secret-client", "scope": "read write"}},
+		{"PrivateKeyJWT", url.Values{"grant_type": {"client_credentials"}, "client_assertion_type": {ClientAssertionTypeJWTBearer}, "client_assertion": {replayed}}, "", "", http.StatusOK, "", jwt.MapClaims{"sub": partner, "client_id": partner, "scope": "read"}},
+		{"AssertionIssuerAudience", url.Values{"grant_type": {"client_credentials"}, "client_assertion_type": {ClientAssertionTypeJWTBearer}, "client_assertion": {assertion(issuer, "2")}}, "", "", http.StatusOK, "", jwt.MapClaims{"sub": partner}},
+		{"AssertionReplayed", url.Values{"grant_type": {"client_credentials"}, "client_assertion_type": {ClientAssertionTypeJWTBearer}, "client_assertion": {replayed}}, "", "", http.StatusUnauthorized, "invalid_client", nil},
+		{"AssertionAudience", url.Values{"grant_type": {"client_credentials"}, "client_assertion_type": {ClientAssertionTypeJWTBearer}, "client_assertion": {assertion("https://other.example.com", "3")}}, "", "", http.StatusUnauthorized, "invalid_client", nil},
+		{"AssertionType", url.Values{"grant_type": {"client_credentials"}, "client_assertion_type": {"other"}, "client_assertion": {assertion(tokenURL, "4")}}, "", "", http.StatusUnauthorized, "invalid_client", nil},
+		{"WrongSecret", url.Values{"grant_type": {"client_credentials"}}, "secret-client", "wrong", http.StatusUnauthorized, "invalid_client", nil},
+		{"SecretForKeyClient", url.Values{"grant_type": {"client_credentials"}}, partner, "", http.StatusUnauthorized, "invalid_client", nil},
+		{"GrantType", url.Values{"grant_type": {"password"}}, "secret-client", "password", http.StatusBadRequest, "unsupported_grant_type", nil},
+		{"Scope", url.Values{"grant_type": {"client_credentials"}, "scope": {"read admin"}}, "secret-client", "password", http.StatusBadRequest, "invalid_scope", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			response := map[string]interface{}{}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if response["error"] != tt.wantError {
+					t.Errorf("error = %v, want %v", response["error"], tt.wantError)
+				}
+				return
+			}
+
+			tokenString, _ := response["access_token"].(string)
+			claims := jwt.MapClaims{}
+			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+				if token.Header["kid"] != set.Keys[0].KeyID {
+					t.Errorf("kid = %v, want %v", token.Header["kid"], set.Keys[0].KeyID)
+				}
+				if token.Header["alg"] != set.Keys[0].Algorithm {
+					t.Errorf("alg = %v, want %v", token.Header["alg"], set.Keys[0].Algorithm)
+				}
+				return publicKey, nil
+			})
+			if err != nil || !token.Valid {
+				t.Fatalf("issued token invalid: %v", err)
+			}
+			if claims["iss"] != issuer || response["token_type"] != "Bearer" || response["scope"] != claims["scope"] {
+				t.Errorf("unexpected response %v for claims %v", response, claims)
+			}
+			for k, v := range tt.wantClaims {
+				if claims[k] != v {
+					t.Errorf("claim %s = %v, want %v", k, claims[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenHandler_AssertionReplay(t *testing.T) {
+	ctx := context.Background()
+	const partner = "partner@project.iam.gserviceaccount.com"
+	config := &gcpjwt.KMSConfig{KeyPath: "projects/p/locations/l/keyRings/r/cryptoKeys/tokens/cryptoKeyVersions/1", LocalMode: true}
+	partnerConfig := &gcpjwt.IAMConfig{ServiceAccount: partner, LocalMode: true}
+
+	// Built without NewTokenHandler
+	handler := &TokenHandler{
+		Clients:  map[string]*Client{partner: {ID: partner, Keyfunc: gcpjwt.IAMVerfiyKeyfunc(ctx, partnerConfig)}},
+		Method:   gcpjwt.SigningMethodKMSES256,
+		Key:      gcpjwt.NewKMSContext(ctx, config),
+		TokenURL: "https://auth.example.com/token",
+	}
+
+	assertion, err := jwt.NewWithClaims(gcpjwt.SigningMethodIAMBlob, &jwt.StandardClaims{
+		Issuer:    partner,
+		Subject:   partner,
+		Audience:  handler.TokenURL,
+		Id:        "1",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}).SignedString(gcpjwt.NewIAMContext(ctx, partnerConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"grant_type": {"client_credentials"}, "client_assertion_type": {ClientAssertionTypeJWTBearer}, "client_assertion": {assertion}}
+
+	for _, wantStatus := range []int{http.StatusOK, http.StatusUnauthorized} {
+		r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != wantStatus {
+			t.Errorf("status = %d, want %d: %s", w.Code, wantStatus, w.Body)
+		}
+	}
+}
+
+func Test_overridden(t *testing.T) {
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		want   string
+	}{
+		{"KMS", gcpjwt.SigningMethodKMSES256, "ES256"},
+		{"IAM", gcpjwt.SigningMethodIAMJWT, "RS256"},
+		{"AppEngine", gcpjwt.SigningMethodAppEngine, "RS256"},
+		{"Other", jwt.SigningMethodHS256, "HS256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := overridden(tt.method).Alg(); got != tt.want {
+				t.Errorf("overridden() alg = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
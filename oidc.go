@@ -0,0 +1,95 @@
+package gcpjwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	googleOIDCKeysURL = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+var (
+	// ErrInvalidGoogleIDTokenClaims is returned when a valid Google ID token has an unexpected audience or issuer
+	ErrInvalidGoogleIDTokenClaims = errors.New("gcpjwt: unexpected Google ID token audience or issuer")
+
+	googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+	// Google ID tokens are always RS256, resolved without the global (possibly overridden) jwt-go registry
+	googleIDTokenMethods = NewRegistry(jwt.SigningMethodRS256)
+)
+
+// GoogleIDTokenConfig is used to verify Google-signed OpenID Connect ID tokens, e.g. the tokens of Pub/Sub, Cloud
+// Tasks and Cloud Scheduler push requests.
+type GoogleIDTokenConfig struct {
+	// EnableCache will enable the in-memory caching of Google's public keys.
+	// The cache will expire keys when an expiration is known or fallback to the configured CacheExpiration
+	EnableCache bool
+
+	// CacheExpiration is the default time to keep the keys in cache if no expiration time is provided
+	// Use a value of 0 to disable the expiration time fallback.
+	CacheExpiration time.Duration
+
+	// Client is a user provided *http.Client to use, http.DefaultClient is used otherwise
+	Client *http.Client
+}
+
+// GoogleIDTokenClaims are the claims of a Google ID token.
+type GoogleIDTokenClaims struct {
+	jwt.StandardClaims
+	AuthorizedParty string `json:"azp,omitempty"`
+	Email           string `json:"email,omitempty"`
+	EmailVerified   bool   `json:"email_verified,omitempty"`
+}
+
+// GoogleIDTokenVerifyKeyfunc is a helper that returns a jwt.Keyfunc selecting Google's public key to verify an ID
+// token with, caching the keys when enabled.
+func GoogleIDTokenVerifyKeyfunc(ctx context.Context, config *GoogleIDTokenConfig) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("gcpjwt: unexpected signing method: %v", token.Header["alg"])
+		}
+
+		keys, err := getJWKSetKeys(ctx, googleOIDCKeysURL, "", config.Client, config.EnableCache, config.CacheExpiration)
+		if err != nil {
+			return nil, fmt.Errorf("gcpjwt: could not get Google keys: %v", err)
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("gcpjwt: could not find Google key for key id `%s`", kid)
+		}
+
+		return key, nil
+	}
+}
+
+// VerifyGoogleIDToken verifies a Google ID token for the audience and returns its claims.
+// ErrInvalidGoogleIDTokenClaims is returned for a valid token with an unexpected audience or issuer.
+func VerifyGoogleIDToken(ctx context.Context, config *GoogleIDTokenConfig, idToken, audience string) (*GoogleIDTokenClaims, error) {
+	claims := &GoogleIDTokenClaims{}
+	token, err := googleIDTokenMethods.ParseWithClaims(idToken, claims, GoogleIDTokenVerifyKeyfunc(ctx, config))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("gcpjwt: invalid Google ID token")
+	}
+
+	if !claims.VerifyAudience(audience, true) {
+		return nil, ErrInvalidGoogleIDTokenClaims
+	}
+	for _, iss := range googleIssuers {
+		if claims.VerifyIssuer(iss, true) {
+			return claims, nil
+		}
+	}
+
+	return nil, ErrInvalidGoogleIDTokenClaims
+}
@@ -0,0 +1,188 @@
+// Package paseto implements PASETO v3.public tokens signed with Cloud KMS EC_SIGN_P384_SHA384 keys, for consumers that
+// prefer PASETO over JWT to avoid algorithm agility.
+// https://github.com/paseto-standard/paseto-spec/blob/master/docs/01-Protocol-Versions/Version3.md
+package paseto
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+const (
+	headerV3Public  = "v3.public."
+	signatureLength = 96
+)
+
+var (
+	// ErrInvalidToken is returned when a token is malformed or is not a v3.public token
+	ErrInvalidToken = errors.New("gcpjwt/paseto: invalid v3.public token")
+	// ErrInvalidSignature is returned when the token signature could not be verified
+	ErrInvalidSignature = errors.New("gcpjwt/paseto: invalid token signature")
+)
+
+// Footer is the recommended JSON footer for tokens, carrying the key id of the signing key.
+type Footer struct {
+	KeyID string `json:"kid,omitempty"`
+}
+
+// V3Signer signs v3.public tokens with a Cloud KMS EC_SIGN_P384_SHA384 key.
+type V3Signer struct {
+	publicKey *ecdsa.PublicKey
+	sign      func(m2 []byte) ([]byte, error)
+}
+
+// NewV3KMSSigner returns a V3Signer for the key configured in the KMSConfig. The public key is retrieved once as it
+// is part of the signed data.
+func NewV3KMSSigner(ctx context.Context, config *gcpjwt.KMSConfig) (*V3Signer, error) {
+	publicKey, err := V3KMSPublicKey(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	key := gcpjwt.NewKMSContext(ctx, config)
+	return &V3Signer{
+		publicKey: publicKey,
+		sign: func(m2 []byte) ([]byte, error) {
+			sig, err := gcpjwt.SigningMethodKMSES384.Sign(string(m2), key)
+			if err != nil {
+				return nil, err
+			}
+			return jwt.DecodeSegment(sig)
+		},
+	}, nil
+}
+
+// Sign returns a v3.public token for the message. The footer is appended to the token unencrypted, the implicit
+// assertion is signed but not included in the token and must be provided again to verify it.
+func (s *V3Signer) Sign(message, footer, implicit []byte) (string, error) {
+	m2 := pae(compressPublicKey(s.publicKey), []byte(headerV3Public), message, footer, implicit)
+	sig, err := s.sign(m2)
+	if err != nil {
+		return "", fmt.Errorf("gcpjwt/paseto: could not sign token: %v", err)
+	}
+	if len(sig) != signatureLength {
+		return "", fmt.Errorf("gcpjwt/paseto: unexpected signature length %d", len(sig))
+	}
+
+	token := headerV3Public + base64.RawURLEncoding.EncodeToString(append(append([]byte{}, message...), sig...))
+	if len(footer) > 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(footer)
+	}
+
+	return token, nil
+}
+
+// Keyfunc returns the public key to verify a token with, based on the (unverified) footer of the token.
+type Keyfunc func(footer []byte) (*ecdsa.PublicKey, error)
+
+// V3KMSPublicKey retrieves the P-384 public key of the key configured in the KMSConfig.
+func V3KMSPublicKey(ctx context.Context, config *gcpjwt.KMSConfig) (*ecdsa.PublicKey, error) {
+	publicKey, err := gcpjwt.KMSPublicKey(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	ecKey, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok || ecKey.Curve != elliptic.P384() {
+		return nil, errors.New("gcpjwt/paseto: v3.public requires an EC_SIGN_P384_SHA384 key")
+	}
+
+	return ecKey, nil
+}
+
+// KMSVerifyKeyfunc is a helper that returns a Keyfunc for the key configured in the KMSConfig. The public key is
+// retrieved when creating the Keyfunc. If the token footer is a JSON Footer with a key id, it must match the
+// KMSConfig's KeyID.
+func KMSVerifyKeyfunc(ctx context.Context, config *gcpjwt.KMSConfig) (Keyfunc, error) {
+	keyID := config.KeyID()
+	publicKey, err := V3KMSPublicKey(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(footer []byte) (*ecdsa.PublicKey, error) {
+		var f Footer
+		if json.Unmarshal(footer, &f) == nil && f.KeyID != "" && f.KeyID != keyID {
+			return nil, fmt.Errorf("gcpjwt/paseto: unknown kid `%s` found in footer", f.KeyID)
+		}
+
+		return publicKey, nil
+	}, nil
+}
+
+// Verify verifies a v3.public token with the key returned by the keyFunc and the implicit assertion used to sign it,
+// returning the message and footer.
+func Verify(token string, keyFunc Keyfunc, implicit []byte) (message, footer []byte, err error) {
+	if !strings.HasPrefix(token, headerV3Public) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	parts := strings.Split(strings.TrimPrefix(token, headerV3Public), ".")
+	if len(parts) > 2 {
+		return nil, nil, ErrInvalidToken
+	}
+	if len(parts) == 2 {
+		if footer, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+			return nil, nil, ErrInvalidToken
+		}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) < signatureLength {
+		return nil, nil, ErrInvalidToken
+	}
+	message, sig := payload[:len(payload)-signatureLength], payload[len(payload)-signatureLength:]
+
+	publicKey, err := keyFunc(footer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if publicKey.Curve != elliptic.P384() {
+		return nil, nil, errors.New("gcpjwt/paseto: v3.public requires a P-384 public key")
+	}
+
+	m2 := pae(compressPublicKey(publicKey), []byte(headerV3Public), message, footer, implicit)
+	digest := sha512.Sum384(m2)
+	r := new(big.Int).SetBytes(sig[:signatureLength/2])
+	s := new(big.Int).SetBytes(sig[signatureLength/2:])
+	if !ecdsa.Verify(publicKey, digest[:], r, s) {
+		return nil, nil, ErrInvalidSignature
+	}
+
+	return message, footer, nil
+}
+
+func compressPublicKey(publicKey *ecdsa.PublicKey) []byte {
+	return elliptic.MarshalCompressed(publicKey.Curve, publicKey.X, publicKey.Y)
+}
+
+// pae is the PASETO Pre-Authentication Encoding
+// https://github.com/paseto-standard/paseto-spec/blob/master/docs/01-Protocol-Versions/Common.md#pae-definition
+func pae(pieces ...[]byte) []byte {
+	out := le64(uint64(len(pieces)))
+	for _, piece := range pieces {
+		out = append(out, le64(uint64(len(piece)))...)
+		out = append(out, piece...)
+	}
+	return out
+}
+
+func le64(n uint64) []byte {
+	b := make([]byte, 8)
+	// Clear the most significant bit for interoperability with languages without unsigned integers
+	binary.LittleEndian.PutUint64(b, n&(1<<63-1))
+	return b
+}
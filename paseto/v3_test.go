@@ -0,0 +1,218 @@
+package paseto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func newTestSigner(t *testing.T) (*V3Signer, *ecdsa.PublicKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &V3Signer{
+		publicKey: &key.PublicKey,
+		sign: func(m2 []byte) ([]byte, error) {
+			digest := sha512.Sum384(m2)
+			r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+			if err != nil {
+				return nil, err
+			}
+			sig := make([]byte, signatureLength)
+			r.FillBytes(sig[:signatureLength/2])
+			s.FillBytes(sig[signatureLength/2:])
+			return sig, nil
+		},
+	}, &key.PublicKey
+}
+
+func Test_pae(t *testing.T) {
+	tests := []struct {
+		name   string
+		pieces [][]byte
+		want   string
+	}{
+		{"Empty", nil, "\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{"EmptyString", [][]byte{{}}, "\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{"Test", [][]byte{[]byte("test")}, "\x01\x00\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00\x00\x00\x00\x00test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pae(tt.pieces...); string(got) != tt.want {
+				t.Errorf("pae() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestV3SignAndVerify(t *testing.T) {
+	signer, publicKey := newTestSigner(t)
+	_, otherKey := newTestSigner(t)
+
+	message := []byte(`{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`)
+	footer := []byte(`{"kid":"test"}`)
+	implicit := []byte("implicit")
+
+	token, err := signer.Sign(message, footer, implicit)
+	if err != nil {
+		t.Fatalf("V3Signer.Sign() error = %v", err)
+	}
+	if !strings.HasPrefix(token, "v3.public.") || strings.Count(token, ".") != 3 {
+		t.Fatalf("V3Signer.Sign() unexpected token format %v", token)
+	}
+
+	keyFunc := func(key *ecdsa.PublicKey) Keyfunc {
+		return func(footer []byte) (*ecdsa.PublicKey, error) {
+			return key, nil
+		}
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		key      *ecdsa.PublicKey
+		implicit []byte
+		wantErr  error
+	}{
+		{"Valid", token, publicKey, implicit, nil},
+		{"WrongImplicit", token, publicKey, []byte("other"), ErrInvalidSignature},
+		{"WrongKey", token, otherKey, implicit, ErrInvalidSignature},
+		{"WrongFooter", token[:strings.LastIndex(token, ".")] + ".e30", publicKey, implicit, ErrInvalidSignature},
+		{"WrongVersion", strings.Replace(token, "v3.", "v4.", 1), publicKey, implicit, ErrInvalidToken},
+		{"TooShort", "v3.public.AAAA", publicKey, implicit, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMessage, gotFooter, err := Verify(tt.token, keyFunc(tt.key), tt.implicit)
+			if err != tt.wantErr {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(gotMessage, message) || !bytes.Equal(gotFooter, footer) {
+				t.Errorf("Verify() = %s, %s, want %s, %s", gotMessage, gotFooter, message, footer)
+			}
+		})
+	}
+}
+
+func TestV3SignWithoutFooter(t *testing.T) {
+	signer, publicKey := newTestSigner(t)
+
+	token, err := signer.Sign([]byte("message"), nil, nil)
+	if err != nil {
+		t.Fatalf("V3Signer.Sign() error = %v", err)
+	}
+	if strings.Count(token, ".") != 2 {
+		t.Errorf("V3Signer.Sign() expected no footer, got %v", token)
+	}
+
+	_, _, err = Verify(token, func(footer []byte) (*ecdsa.PublicKey, error) {
+		return publicKey, nil
+	}, nil)
+	if err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+}
+
+// Official test vectors, https://github.com/paseto-standard/test-vectors/blob/master/v3.json
+var v3Vectors = []struct {
+	name     string
+	token    string
+	payload  string
+	footer   string
+	implicit string
+}{
+	{
+		"3-S-2",
+		"v3.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9ZWrbGZ6L0MDK72skosUaS0Dz7wJ_2bMcM6tOxFuCasO9GhwHrvvchqgXQNLQQyWzGC2wkr-VKII71AvkLpC8tJOrzJV1cap9NRwoFzbcXjzMZyxQ0wkshxZxx8ImmNWP.eyJraWQiOiJkWWtJU3lseFFlZWNFY0hFTGZ6Rjg4VVpyd2JMb2xOaUNkcHpVSEd3OVVxbiJ9",
+		`{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`,
+		`{"kid":"dYkISylxQeecEcHELfzF88UZrwbLolNiCdpzUHGw9Uqn"}`,
+		"",
+	},
+	{
+		"3-S-3",
+		"v3.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ94SjWIbjmS7715GjLSnHnpJrC9Z-cnwK45dmvnVvCRQDCCKAXaKEopTajX0DKYx1Xqr6gcTdfqscLCAbiB4eOW9jlt-oNqdG8TjsYEi6aloBfTzF1DXff_45tFlnBukEX.eyJraWQiOiJkWWtJU3lseFFlZWNFY0hFTGZ6Rjg4VVpyd2JMb2xOaUNkcHpVSEd3OVVxbiJ9",
+		`{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`,
+		`{"kid":"dYkISylxQeecEcHELfzF88UZrwbLolNiCdpzUHGw9Uqn"}`,
+		`{"test-vector":"3-S-3"}`,
+	},
+}
+
+func v3VectorKey(t *testing.T) *ecdsa.PrivateKey {
+	const (
+		secretKey = "20347609607477aca8fbfbc5e6218455f3199669792ef8b466faa87bdc67798144c848dd03661eed5ac62461340cea96"
+		publicKey = "02fbcb7c69ee1c60579be7a334134878d9c5c5bf35d552dab63c0140397ed14cef637d7720925c44699ea30e72874c72fb"
+	)
+
+	d, ok := new(big.Int).SetString(secretKey, 16)
+	if !ok {
+		t.Fatal("invalid secret key")
+	}
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = elliptic.P384()
+	key.X, key.Y = key.Curve.ScalarBaseMult(d.Bytes())
+
+	if got := hex.EncodeToString(compressPublicKey(&key.PublicKey)); got != publicKey {
+		t.Fatalf("compressPublicKey() = %s, want %s", got, publicKey)
+	}
+
+	return key
+}
+
+func TestV3Vectors(t *testing.T) {
+	key := v3VectorKey(t)
+	keyFunc := func(footer []byte) (*ecdsa.PublicKey, error) {
+		return &key.PublicKey, nil
+	}
+
+	for _, tt := range v3Vectors {
+		t.Run(tt.name, func(t *testing.T) {
+			message, footer, err := Verify(tt.token, keyFunc, []byte(tt.implicit))
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if string(message) != tt.payload || string(footer) != tt.footer {
+				t.Errorf("Verify() = %s, %s, want %s, %s", message, footer, tt.payload, tt.footer)
+			}
+
+			// ECDSA signatures are randomized, only the payload and footer encoding can be compared
+			signer := &V3Signer{
+				publicKey: &key.PublicKey,
+				sign: func(m2 []byte) ([]byte, error) {
+					digest := sha512.Sum384(m2)
+					r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+					if err != nil {
+						return nil, err
+					}
+					sig := make([]byte, signatureLength)
+					r.FillBytes(sig[:signatureLength/2])
+					s.FillBytes(sig[signatureLength/2:])
+					return sig, nil
+				},
+			}
+			token, err := signer.Sign([]byte(tt.payload), []byte(tt.footer), []byte(tt.implicit))
+			if err != nil {
+				t.Fatalf("V3Signer.Sign() error = %v", err)
+			}
+			// The payload is a multiple of 3 bytes long, so its encoding ends where the signature's begins
+			prefix := len(headerV3Public) + base64.RawURLEncoding.EncodedLen(len(tt.payload))
+			if token[:prefix] != tt.token[:prefix] || token[strings.LastIndex(token, "."):] != tt.token[strings.LastIndex(tt.token, "."):] {
+				t.Errorf("V3Signer.Sign() = %s, want payload and footer of %s", token, tt.token)
+			}
+			if _, _, err := Verify(token, keyFunc, []byte(tt.implicit)); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package gcpjwt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	// ErrRateLimited is returned when a signing call would exceed the configured RateLimit and waiting is disabled
+	ErrRateLimited = errors.New("gcpjwt: signing rate limit exceeded")
+)
+
+// RateLimit configures an optional client-side token bucket in front of the remote signing APIs (IAM signBlob/signJwt
+// and KMS AsymmetricSign). Buckets are shared by every config pointing at the same service account or key path so
+// bursts queue locally instead of burning through the API quota.
+type RateLimit struct {
+	// Limit is the sustained number of signing calls allowed per second. A zero Limit does not limit the calls.
+	Limit rate.Limit
+
+	// Burst is the maximum number of signing calls allowed at once. Defaults to 1 if not set.
+	Burst int
+
+	// Wait will block until a call is allowed (or the context is done) instead of failing with ErrRateLimited
+	Wait bool
+}
+
+// RateLimitStats are the counters kept for a rate limited service account or key path
+type RateLimitStats struct {
+	// Allowed is the number of calls that went through without waiting
+	Allowed uint64
+	// Waited is the number of calls that went through after waiting for the bucket to refill
+	Waited uint64
+	// Rejected is the number of calls refused, either with ErrRateLimited or because the context was done
+	Rejected uint64
+	// WaitTime is the total time spent waiting for the bucket to refill
+	WaitTime time.Duration
+}
+
+type signingLimiter struct {
+	limiter *rate.Limiter
+
+	allowed  uint64
+	waited   uint64
+	rejected uint64
+	waitTime int64
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[string]*signingLimiter)
+)
+
+func getLimiter(key string, limit *RateLimit) *signingLimiter {
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	// The zero value would otherwise allow a single call and never refill the bucket
+	r := limit.Limit
+	if r == 0 {
+		r = rate.Inf
+	}
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	l, ok := limiters[key]
+	if !ok {
+		l = &signingLimiter{limiter: rate.NewLimiter(r, burst)}
+		limiters[key] = l
+		return l
+	}
+
+	// Pick up any changes made to the config since the limiter was created
+	if l.limiter.Limit() != r {
+		l.limiter.SetLimit(r)
+	}
+	if l.limiter.Burst() != burst {
+		l.limiter.SetBurst(burst)
+	}
+
+	return l
+}
+
+// wait will block or fail according to the RateLimit for the given service account or key path. A nil RateLimit
+// allows every call.
+func (r *RateLimit) wait(ctx context.Context, key string) error {
+	if r == nil {
+		return nil
+	}
+
+	l := getLimiter(key, r)
+	if l.limiter.Allow() {
+		atomic.AddUint64(&l.allowed, 1)
+		return nil
+	}
+
+	if !r.Wait {
+		atomic.AddUint64(&l.rejected, 1)
+		return ErrRateLimited
+	}
+
+	start := time.Now()
+	if err := l.limiter.Wait(ctx); err != nil {
+		atomic.AddUint64(&l.rejected, 1)
+		return err
+	}
+	atomic.AddUint64(&l.waited, 1)
+	atomic.AddInt64(&l.waitTime, int64(time.Since(start)))
+
+	return nil
+}
+
+// RateLimitMetrics returns the counters for the given service account or key path, if a RateLimit was ever applied
+// to it.
+func RateLimitMetrics(key string) (RateLimitStats, bool) {
+	limitersMu.Lock()
+	l, ok := limiters[key]
+	limitersMu.Unlock()
+	if !ok {
+		return RateLimitStats{}, false
+	}
+
+	return RateLimitStats{
+		Allowed:  atomic.LoadUint64(&l.allowed),
+		Waited:   atomic.LoadUint64(&l.waited),
+		Rejected: atomic.LoadUint64(&l.rejected),
+		WaitTime: time.Duration(atomic.LoadInt64(&l.waitTime)),
+	}, true
+}
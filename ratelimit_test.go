@@ -0,0 +1,104 @@
+package gcpjwt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimit_wait(t *testing.T) {
+	tests := []struct {
+		name         string
+		key          string
+		limit        *RateLimit
+		calls        int
+		wantErrs     int
+		wantStats    RateLimitStats
+		wantNoLimits bool
+	}{
+		{
+			"NilLimit",
+			"nil-limit@test",
+			nil,
+			5,
+			0,
+			RateLimitStats{},
+			true,
+		},
+		{
+			"ZeroLimit",
+			"zero-limit@test",
+			&RateLimit{},
+			5,
+			0,
+			RateLimitStats{Allowed: 5},
+			false,
+		},
+		{
+			"Reject",
+			"reject@test",
+			&RateLimit{Limit: rate.Every(time.Hour), Burst: 2},
+			4,
+			2,
+			RateLimitStats{Allowed: 2, Rejected: 2},
+			false,
+		},
+		{
+			"Wait",
+			"projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1",
+			&RateLimit{Limit: rate.Every(10 * time.Millisecond), Burst: 1, Wait: true},
+			3,
+			0,
+			RateLimitStats{Allowed: 1, Waited: 2},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs int
+			for i := 0; i < tt.calls; i++ {
+				err := tt.limit.wait(context.Background(), tt.key)
+				if err != nil {
+					if err != ErrRateLimited {
+						t.Errorf("RateLimit.wait() unexpected error = %v", err)
+					}
+					errs++
+				}
+			}
+			if errs != tt.wantErrs {
+				t.Errorf("RateLimit.wait() errors = %d, want %d", errs, tt.wantErrs)
+			}
+
+			stats, ok := RateLimitMetrics(tt.key)
+			if ok == tt.wantNoLimits {
+				t.Errorf("RateLimitMetrics() found = %v, want %v", ok, !tt.wantNoLimits)
+				return
+			}
+			stats.WaitTime = 0
+			if stats != tt.wantStats {
+				t.Errorf("RateLimitMetrics() = %+v, want %+v", stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestRateLimit_waitContextDone(t *testing.T) {
+	limit := &RateLimit{Limit: rate.Every(time.Hour), Burst: 1, Wait: true}
+	key := "context-done@test"
+	if err := limit.wait(context.Background(), key); err != nil {
+		t.Fatalf("RateLimit.wait() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limit.wait(ctx, key); err == nil {
+		t.Errorf("RateLimit.wait() expected error when the context would expire before a token is available")
+	}
+
+	stats, _ := RateLimitMetrics(key)
+	if stats.Rejected != 1 {
+		t.Errorf("RateLimitMetrics() rejected = %d, want 1", stats.Rejected)
+	}
+}
@@ -0,0 +1,91 @@
+package gcpjwt
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Registry maps `alg` header values to signing methods for a single parser or verifier. Unlike Override, which
+// registers a signing method globally with jwt-go, several registries can map the same `alg` to different methods in
+// one process, e.g. RS256 to SigningMethodKMSRS256 for one verifier and to SigningMethodIAMBlob for another.
+//
+// Signing does not need a registry, simply sign with the method returned by Overridden to get a standard `alg`
+// header.
+type Registry struct {
+	sync.RWMutex
+
+	methods map[string]jwt.SigningMethod
+}
+
+// NewRegistry returns a Registry with the provided methods registered under their own Alg().
+func NewRegistry(methods ...jwt.SigningMethod) *Registry {
+	r := &Registry{
+		methods: make(map[string]jwt.SigningMethod),
+	}
+	for _, method := range methods {
+		r.Register(method)
+	}
+
+	return r
+}
+
+// Register maps the method's Alg() to the method.
+func (r *Registry) Register(method jwt.SigningMethod) {
+	r.RegisterAs(method.Alg(), method)
+}
+
+// RegisterAs maps the provided alg to the method, regardless of the method's own Alg().
+func (r *Registry) RegisterAs(alg string, method jwt.SigningMethod) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.methods[alg] = method
+}
+
+// Get returns the signing method registered for the alg, or nil if there is none.
+func (r *Registry) Get(alg string) jwt.SigningMethod {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.methods[alg]
+}
+
+// Keyfunc wraps a jwt.Keyfunc so the token's signing method is resolved with this registry instead of the global
+// jwt-go registry before the keyFunc is called. Tokens with an `alg` header unknown to the registry are rejected.
+func (r *Registry) Keyfunc(keyFunc jwt.Keyfunc) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		alg, _ := token.Header["alg"].(string)
+		method := r.Get(alg)
+		if method == nil {
+			return nil, fmt.Errorf("gcpjwt: signing method `%s` is not registered", alg)
+		}
+		token.Method = method
+
+		return keyFunc(token)
+	}
+}
+
+// Parse parses and validates the token like jwt.Parse, resolving the signing method with this registry.
+func (r *Registry) Parse(tokenString string, keyFunc jwt.Keyfunc) (*jwt.Token, error) {
+	return r.ParseWithClaims(tokenString, jwt.MapClaims{}, keyFunc)
+}
+
+// ParseWithClaims parses and validates the token like jwt.ParseWithClaims, resolving the signing method with this
+// registry. NOTE: the `alg` header must still be known to jwt-go, which is the case for all standard algorithms and
+// the non-overridden algorithms of this package.
+func (r *Registry) ParseWithClaims(tokenString string, claims jwt.Claims, keyFunc jwt.Keyfunc) (*jwt.Token, error) {
+	return new(jwt.Parser).ParseWithClaims(tokenString, claims, r.Keyfunc(keyFunc))
+}
+
+// restoreSigningMethod registers the original jwt-go implementation for the alg if current is the one registered.
+func restoreSigningMethod(alg string, current, original jwt.SigningMethod) {
+	if jwt.GetSigningMethod(alg) != current {
+		return
+	}
+
+	jwt.RegisterSigningMethod(alg, func() jwt.SigningMethod {
+		return original
+	})
+}
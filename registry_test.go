@@ -0,0 +1,96 @@
+package gcpjwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestRegistry_Parse(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"foo": "bar"}).SignedString(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kmsAlg, iamAlg := SigningMethodKMSRS256.Alg(), SigningMethodIAMBlob.Alg()
+	kmsRegistry := NewRegistry(SigningMethodKMSRS256.Overridden())
+	iamRegistry := NewRegistry(SigningMethodIAMBlob.Overridden())
+	emptyRegistry := NewRegistry()
+
+	tests := []struct {
+		name     string
+		registry *Registry
+		keyFunc  jwt.Keyfunc
+		wantErr  bool
+	}{
+		{
+			"KMS",
+			kmsRegistry,
+			func(token *jwt.Token) (interface{}, error) {
+				if _, ok := token.Method.(*SigningMethodKMS); !ok {
+					t.Errorf("unexpected signing method %T", token.Method)
+				}
+				return &privateKey.PublicKey, nil
+			},
+			false,
+		},
+		{
+			"IAM",
+			iamRegistry,
+			func(token *jwt.Token) (interface{}, error) {
+				if _, ok := token.Method.(*SigningMethodIAM); !ok {
+					t.Errorf("unexpected signing method %T", token.Method)
+				}
+				return []*rsa.PublicKey{&privateKey.PublicKey}, nil
+			},
+			false,
+		},
+		{
+			"Unregistered",
+			emptyRegistry,
+			func(token *jwt.Token) (interface{}, error) {
+				return &privateKey.PublicKey, nil
+			},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.registry.Parse(tokenString, tt.keyFunc)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Registry.Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !token.Valid {
+				t.Errorf("Registry.Parse() expected a valid token")
+			}
+		})
+	}
+
+	if SigningMethodKMSRS256.Alg() != kmsAlg || SigningMethodIAMBlob.Alg() != iamAlg {
+		t.Errorf("Overridden() should not modify the original signing methods")
+	}
+}
+
+func TestSigningMethodKMS_Restore(t *testing.T) {
+	SigningMethodKMSPS256.Override()
+	t.Cleanup(SigningMethodKMSPS256.Restore)
+	if method := jwt.GetSigningMethod("PS256"); method != SigningMethodKMSPS256 {
+		t.Fatalf("Expected method == `%T`, got `%T` instead", SigningMethodKMSPS256, method)
+	}
+
+	SigningMethodKMSPS256.Restore()
+	if method := jwt.GetSigningMethod("PS256"); method != jwt.SigningMethodPS256 {
+		t.Errorf("Expected method == `%T`, got `%T` instead", jwt.SigningMethodPS256, method)
+	}
+	if SigningMethodKMSPS256.Alg() != "KMSPS256" {
+		t.Errorf("Expected Alg() == KMSPS256, got %v instead", SigningMethodKMSPS256.Alg())
+	}
+}
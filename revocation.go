@@ -0,0 +1,321 @@
+package gcpjwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	defaultRevocationInterval = time.Minute
+)
+
+var (
+	// ErrTokenRevoked is returned for tokens revoked by a RevocationChecker
+	ErrTokenRevoked = errors.New("gcpjwt: token has been revoked")
+	// ErrRevocationListUnavailable is returned by PollingRevocations when no valid revocation list is loaded, tokens
+	// are then rejected
+	ErrRevocationListUnavailable = errors.New("gcpjwt: revocation list unavailable")
+)
+
+// RevocationClaims are the claims of a token revocations apply to.
+type RevocationClaims struct {
+	ID       string `json:"jti"`
+	Subject  string `json:"sub"`
+	IssuedAt int64  `json:"iat"`
+}
+
+// RevocationChecker is a source of token revocations.
+type RevocationChecker interface {
+	// Revoked reports whether the token with the claims is revoked. Tokens are rejected when an error is returned.
+	Revoked(ctx context.Context, claims *RevocationClaims) (bool, error)
+}
+
+// CheckRevocation returns ErrTokenRevoked if the token with the claims is revoked by the checker, or the checker's
+// error. A nil checker revokes nothing.
+func CheckRevocation(ctx context.Context, checker RevocationChecker, claims jwt.Claims) error {
+	if checker == nil {
+		return nil
+	}
+
+	revocationClaims, err := tokenRevocationClaims(claims)
+	if err != nil {
+		return err
+	}
+	revoked, err := checker.Revoked(ctx, revocationClaims)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+func tokenRevocationClaims(claims jwt.Claims) (*RevocationClaims, error) {
+	if c, ok := claims.(*jwt.StandardClaims); ok {
+		return &RevocationClaims{ID: c.Id, Subject: c.Subject, IssuedAt: c.IssuedAt}, nil
+	}
+
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	// iat may be encoded as a float
+	var raw struct {
+		ID       string  `json:"jti"`
+		Subject  string  `json:"sub"`
+		IssuedAt float64 `json:"iat"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	return &RevocationClaims{ID: raw.ID, Subject: raw.Subject, IssuedAt: int64(raw.IssuedAt)}, nil
+}
+
+// RevocationList is a list of revoked tokens, e.g. published as a signed JWT with SignRevocationList.
+type RevocationList struct {
+	// IDs are the revoked `jti` claims
+	IDs []string `json:"revokedIds,omitempty"`
+
+	// Subjects are the `sub` claims all the tokens of are revoked
+	Subjects []string `json:"revokedSubjects,omitempty"`
+
+	// IssuedBefore revokes the tokens of a subject issued before the Unix time
+	IssuedBefore map[string]int64 `json:"revokedIssuedBefore,omitempty"`
+}
+
+// MemoryRevocations is an in-memory RevocationChecker.
+type MemoryRevocations struct {
+	// revoked ids to the expiry of their token, zero if unknown
+	ids          map[string]time.Time
+	subjects     map[string]bool
+	issuedBefore map[string]int64
+
+	sync.RWMutex
+}
+
+// NewMemoryRevocations returns a MemoryRevocations with the revocations of the list, which may be nil.
+func NewMemoryRevocations(list *RevocationList) *MemoryRevocations {
+	m := &MemoryRevocations{
+		ids:          make(map[string]time.Time),
+		subjects:     make(map[string]bool),
+		issuedBefore: make(map[string]int64),
+	}
+	if list == nil {
+		return m
+	}
+
+	for _, id := range list.IDs {
+		m.ids[id] = time.Time{}
+	}
+	for _, sub := range list.Subjects {
+		m.subjects[sub] = true
+	}
+	for sub, iat := range list.IssuedBefore {
+		m.issuedBefore[sub] = iat
+	}
+	return m
+}
+
+// RevokeID revokes the token with the `jti` claim. The revocation is forgotten after expires, when the token is no
+// longer valid anyway, unless it is zero.
+func (m *MemoryRevocations) RevokeID(id string, expires time.Time) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.ids[id] = expires
+}
+
+// RevokeSubject revokes all the tokens with the `sub` claim.
+func (m *MemoryRevocations) RevokeSubject(subject string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.subjects[subject] = true
+}
+
+// RevokeIssuedBefore revokes the tokens with the `sub` claim issued before t, e.g. after a credential leak.
+func (m *MemoryRevocations) RevokeIssuedBefore(subject string, t time.Time) {
+	m.Lock()
+	defer m.Unlock()
+
+	if t.Unix() > m.issuedBefore[subject] {
+		m.issuedBefore[subject] = t.Unix()
+	}
+}
+
+// Revoked implements RevocationChecker. Tokens without an `iat` claim are revoked by RevokeIssuedBefore.
+func (m *MemoryRevocations) Revoked(ctx context.Context, claims *RevocationClaims) (bool, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if claims.ID != "" {
+		if expires, ok := m.ids[claims.ID]; ok && (expires.IsZero() || time.Now().Before(expires)) {
+			return true, nil
+		}
+	}
+	if claims.Subject != "" {
+		if m.subjects[claims.Subject] {
+			return true, nil
+		}
+		if before, ok := m.issuedBefore[claims.Subject]; ok && claims.IssuedAt < before {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// List returns the current revocations, e.g. to publish them with SignRevocationList. Expired ID revocations are
+// omitted.
+func (m *MemoryRevocations) List() *RevocationList {
+	m.RLock()
+	defer m.RUnlock()
+
+	now := time.Now()
+	list := &RevocationList{IssuedBefore: make(map[string]int64, len(m.issuedBefore))}
+	for id, expires := range m.ids {
+		if expires.IsZero() || now.Before(expires) {
+			list.IDs = append(list.IDs, id)
+		}
+	}
+	for sub := range m.subjects {
+		list.Subjects = append(list.Subjects, sub)
+	}
+	for sub, iat := range m.issuedBefore {
+		list.IssuedBefore[sub] = iat
+	}
+	return list
+}
+
+// RevocationListType is the `typ` header of signed revocation lists, so other tokens signed by the same key are not
+// accepted as lists.
+const RevocationListType = "revocation-list+jwt"
+
+// RevocationListClaims are the claims of a signed revocation list.
+type RevocationListClaims struct {
+	jwt.StandardClaims
+	RevocationList
+}
+
+// SignRevocationList signs the list as a JWT valid for ttl, e.g. with SigningMethodKMSES256 and a context carrying the
+// KMSConfig as key. Publish it where PollingRevocations fetch it from.
+func SignRevocationList(list *RevocationList, ttl time.Duration, method jwt.SigningMethod, key interface{}) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(method, &RevocationListClaims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+		RevocationList: *list,
+	})
+	token.Header["typ"] = RevocationListType
+	return token.SignedString(key)
+}
+
+// PollingRevocations is a RevocationChecker polling a revocation list signed with SignRevocationList. It fails
+// closed: tokens are rejected with ErrRevocationListUnavailable until a list is loaded and after it expires, so the
+// list must be republished before its expiry. Lists older than the loaded one are ignored.
+type PollingRevocations struct {
+	// Fetch returns the signed revocation list
+	Fetch func(ctx context.Context) ([]byte, error)
+
+	// Keyfunc selects the key to verify the list with, e.g. KMSVerfiyKeyfunc for the publishing key
+	Keyfunc jwt.Keyfunc
+
+	// Interval between fetches by Run, defaults to 1 minute
+	Interval time.Duration
+
+	current  *MemoryRevocations
+	issuedAt int64
+	expires  time.Time
+
+	sync.RWMutex
+}
+
+// NewPollingRevocations returns a PollingRevocations fetching the signed revocation list from the URL with the
+// client, http.DefaultClient if nil.
+func NewPollingRevocations(url string, client *http.Client, keyFunc jwt.Keyfunc) *PollingRevocations {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &PollingRevocations{
+		Fetch: func(ctx context.Context) ([]byte, error) {
+			b, _, err := fetchKeys(client, url, 0)
+			return b, err
+		},
+		Keyfunc: keyFunc,
+	}
+}
+
+// Refresh fetches and verifies the revocation list, replacing the loaded one unless it is older.
+func (p *PollingRevocations) Refresh(ctx context.Context) error {
+	b, err := p.Fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRevocationListUnavailable, err)
+	}
+
+	claims := &RevocationListClaims{}
+	token, err := jwt.ParseWithClaims(string(b), claims, p.Keyfunc)
+	if err != nil || !token.Valid {
+		return fmt.Errorf("%w: invalid revocation list: %v", ErrRevocationListUnavailable, err)
+	}
+	if typ, _ := token.Header["typ"].(string); typ != RevocationListType {
+		return fmt.Errorf("%w: invalid revocation list type `%s`", ErrRevocationListUnavailable, typ)
+	}
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("%w: revocation list without expiry", ErrRevocationListUnavailable)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if claims.IssuedAt < p.issuedAt {
+		return fmt.Errorf("%w: revocation list older than the loaded one", ErrRevocationListUnavailable)
+	}
+	p.current = NewMemoryRevocations(&claims.RevocationList)
+	p.issuedAt = claims.IssuedAt
+	p.expires = time.Unix(claims.ExpiresAt, 0)
+	return nil
+}
+
+// Run refreshes the revocation list every Interval until the context is done, passing errors to onError if not nil.
+func (p *PollingRevocations) Run(ctx context.Context, onError func(err error)) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultRevocationInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Refresh(ctx); err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Revoked implements RevocationChecker.
+func (p *PollingRevocations) Revoked(ctx context.Context, claims *RevocationClaims) (bool, error) {
+	p.RLock()
+	current, expires := p.current, p.expires
+	p.RUnlock()
+
+	if current == nil || !time.Now().Before(expires) {
+		return false, ErrRevocationListUnavailable
+	}
+	return current.Revoked(ctx, claims)
+}
@@ -0,0 +1,206 @@
+package gcpjwt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestMemoryRevocations(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	m := NewMemoryRevocations(&RevocationList{IDs: []string{"listed"}})
+	m.RevokeID("leaked", now.Add(time.Hour))
+	m.RevokeID("expired", now.Add(-time.Hour))
+	m.RevokeSubject("banned")
+	m.RevokeIssuedBefore("rotated", now)
+	// An earlier time does not undo a revocation
+	m.RevokeIssuedBefore("rotated", now.Add(-time.Hour))
+
+	tests := []struct {
+		name   string
+		claims jwt.Claims
+		want   error
+	}{
+		{"Valid", &jwt.StandardClaims{Id: "ok", Subject: "user", IssuedAt: now.Unix()}, nil},
+		{"ListedID", &jwt.StandardClaims{Id: "listed"}, ErrTokenRevoked},
+		{"RevokedID", jwt.MapClaims{"jti": "leaked"}, ErrTokenRevoked},
+		{"ExpiredRevocation", &jwt.StandardClaims{Id: "expired"}, nil},
+		{"Subject", &jwt.StandardClaims{Subject: "banned", IssuedAt: now.Unix()}, ErrTokenRevoked},
+		{"IssuedBefore", jwt.MapClaims{"sub": "rotated", "iat": float64(now.Add(-time.Minute).Unix())}, ErrTokenRevoked},
+		{"IssuedAfter", &jwt.StandardClaims{Subject: "rotated", IssuedAt: now.Add(time.Minute).Unix()}, nil},
+		{"IssuedBeforeNoIat", &jwt.StandardClaims{Subject: "rotated"}, ErrTokenRevoked},
+	}
+	for _, tt := range tests {
+		if err := CheckRevocation(ctx, m, tt.claims); err != tt.want {
+			t.Errorf("%s: CheckRevocation() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if err := CheckRevocation(ctx, nil, &jwt.StandardClaims{Id: "leaked"}); err != nil {
+		t.Errorf("CheckRevocation() with nil checker error = %v", err)
+	}
+
+	list := m.List()
+	if len(list.IDs) != 2 || len(list.Subjects) != 1 || list.IssuedBefore["rotated"] != now.Unix() {
+		t.Errorf("List() = %+v", list)
+	}
+}
+
+func TestPollingRevocations(t *testing.T) {
+	ctx := context.Background()
+	for _, env := range productionEnvVars {
+		t.Setenv(env, "")
+	}
+	config := &KMSConfig{KeyPath: "projects/p/locations/l/keyRings/r/cryptoKeys/revocations/cryptoKeyVersions/1", LocalMode: true}
+	keyFunc, err := KMSVerfiyKeyfunc(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu        sync.Mutex
+		published string
+	)
+	publish := func(list *RevocationList, ttl time.Duration, key interface{}) {
+		signed, err := SignRevocationList(list, ttl, SigningMethodKMSES256, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		published = signed
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(published))
+	}))
+	defer server.Close()
+
+	p := NewPollingRevocations(server.URL, server.Client(), keyFunc)
+	revoked := &jwt.StandardClaims{Id: "leaked"}
+
+	// Fails closed until a list is loaded
+	if err := CheckRevocation(ctx, p, revoked); err != ErrRevocationListUnavailable {
+		t.Errorf("CheckRevocation() before refresh error = %v, want %v", err, ErrRevocationListUnavailable)
+	}
+
+	publish(&RevocationList{IDs: []string{"leaked"}}, time.Hour, NewKMSContext(ctx, config))
+	if err := p.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if err := CheckRevocation(ctx, p, revoked); err != ErrTokenRevoked {
+		t.Errorf("CheckRevocation() error = %v, want %v", err, ErrTokenRevoked)
+	}
+	if err := CheckRevocation(ctx, p, &jwt.StandardClaims{Id: "other"}); err != nil {
+		t.Errorf("CheckRevocation() error = %v", err)
+	}
+
+	// Lists signed by another key are rejected and the loaded one kept
+	other := &KMSConfig{KeyPath: "projects/p/locations/l/keyRings/r/cryptoKeys/other/cryptoKeyVersions/1", LocalMode: true}
+	publish(&RevocationList{}, time.Hour, NewKMSContext(ctx, other))
+	if err := p.Refresh(ctx); !errors.Is(err, ErrRevocationListUnavailable) {
+		t.Errorf("Refresh() error = %v, want %v for a list signed by another key", err, ErrRevocationListUnavailable)
+	}
+	if err := CheckRevocation(ctx, p, revoked); err != ErrTokenRevoked {
+		t.Errorf("CheckRevocation() error = %v, want %v", err, ErrTokenRevoked)
+	}
+
+	// Other tokens signed by the key are rejected
+	token, err := jwt.NewWithClaims(SigningMethodKMSES256, &RevocationListClaims{
+		StandardClaims: jwt.StandardClaims{IssuedAt: time.Now().Unix(), ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}).SignedString(NewKMSContext(ctx, config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	published = token
+	mu.Unlock()
+	if err := p.Refresh(ctx); !errors.Is(err, ErrRevocationListUnavailable) {
+		t.Errorf("Refresh() error = %v, want %v for a token without the revocation list type", err, ErrRevocationListUnavailable)
+	}
+	if err := CheckRevocation(ctx, p, revoked); err != ErrTokenRevoked {
+		t.Errorf("CheckRevocation() error = %v, want %v", err, ErrTokenRevoked)
+	}
+
+	// Fails closed once the list expired
+	p.expires = time.Now().Add(-time.Second)
+	if err := CheckRevocation(ctx, p, &jwt.StandardClaims{Id: "other"}); err != ErrRevocationListUnavailable {
+		t.Errorf("CheckRevocation() after expiry error = %v, want %v", err, ErrRevocationListUnavailable)
+	}
+
+	// Older lists are rejected
+	p.issuedAt = time.Now().Add(time.Hour).Unix()
+	publish(&RevocationList{}, time.Hour, NewKMSContext(ctx, config))
+	if err := p.Refresh(ctx); !errors.Is(err, ErrRevocationListUnavailable) {
+		t.Errorf("Refresh() error = %v, want %v for an older list", err, ErrRevocationListUnavailable)
+	}
+}
+
+func TestVerifier_Revocations(t *testing.T) {
+	ctx := context.Background()
+	for _, env := range productionEnvVars {
+		t.Setenv(env, "")
+	}
+	if err := EnableLocalMode(); err != nil {
+		t.Fatal(err)
+	}
+	defer DisableLocalMode()
+
+	const keyPath = "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+	verifier, err := NewVerifier(ctx, &VerifierConfig{KMSKeyPaths: []string{keyPath}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocations := NewMemoryRevocations(nil)
+	verifier.Revocations = revocations
+
+	tokenString, err := jwt.NewWithClaims(SigningMethodKMSES256, &jwt.StandardClaims{Id: "token-1"}).
+		SignedString(NewKMSContext(ctx, &KMSConfig{KeyPath: keyPath}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.ParseWithClaims(ctx, tokenString, &jwt.StandardClaims{}); err != nil {
+		t.Fatalf("ParseWithClaims() error = %v", err)
+	}
+	revocations.RevokeID("token-1", time.Time{})
+	if _, err := verifier.ParseWithClaims(ctx, tokenString, &jwt.StandardClaims{}); err != ErrTokenRevoked {
+		t.Errorf("ParseWithClaims() error = %v, want %v", err, ErrTokenRevoked)
+	}
+
+	// Tokens failing verification are not checked
+	checker := &countingRevocations{RevocationChecker: revocations}
+	verifier.Revocations = checker
+	if _, err := verifier.ParseWithClaims(ctx, tokenString[:len(tokenString)-4]+"AAAA", &jwt.StandardClaims{}); err == nil {
+		t.Errorf("ParseWithClaims() expected error for an invalid signature")
+	}
+	if checker.calls != 0 {
+		t.Errorf("ParseWithClaims() checked the revocation of an unverified token")
+	}
+
+	// Checker failures are returned as is
+	verifier.Revocations = NewPollingRevocations("http://localhost/", nil, nil)
+	if _, err := verifier.ParseWithClaims(ctx, tokenString, &jwt.StandardClaims{}); err != ErrRevocationListUnavailable {
+		t.Errorf("ParseWithClaims() error = %v, want %v", err, ErrRevocationListUnavailable)
+	}
+}
+
+// countingRevocations counts the revocation checks
+type countingRevocations struct {
+	RevocationChecker
+	calls int
+}
+
+func (c *countingRevocations) Revoked(ctx context.Context, claims *RevocationClaims) (bool, error) {
+	c.calls++
+	return c.RevocationChecker.Revoked(ctx, claims)
+}
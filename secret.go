@@ -0,0 +1,341 @@
+package gcpjwt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+const (
+	defaultSecretVerifyVersions  = 2
+	defaultSecretRefreshInterval = 5 * time.Minute
+)
+
+var (
+	// minimum time between reloads triggered by tokens with an unknown kid
+	secretReloadInterval = 10 * time.Second
+)
+
+type secretConfigKey struct{}
+
+// SecretConfig is used to sign/verify HMAC JWTs with keys stored as versions of a Google Secret Manager secret. The
+// latest enabled version signs and the last VerifyVersions enabled versions verify, with the version number as `kid`.
+type SecretConfig struct {
+	// SecretName is the name of the secret to use in the format of:
+	// "projects/*/secrets/*"
+	SecretName string
+
+	// VerifyVersions is the number of latest enabled versions accepted when verifying, defaults to 2 so tokens signed
+	// before a rotation remain valid
+	VerifyVersions int
+
+	// RefreshInterval is how long the loaded versions are used before listing them again, defaults to 5 minutes
+	RefreshInterval time.Duration
+
+	// SecretService is a user provided service client that should be used when communicating with the Secret Manager
+	// API, otherwise the default service will be used.
+	SecretService *secretmanager.Service
+
+	versions []*secretVersion
+	payloads map[string][]byte
+	loadedAt time.Time
+
+	sync.RWMutex
+}
+
+type secretVersion struct {
+	id  string
+	key []byte
+}
+
+// KeyID returns the version number of the latest enabled version of the secret, loading the versions if needed.
+// Helper function for adding the kid header to your token.
+func (s *SecretConfig) KeyID(ctx context.Context) (string, error) {
+	versions, err := s.keys(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	return versions[0].id, nil
+}
+
+// keys returns the enabled versions, latest first, reloading them when expired or forced
+func (s *SecretConfig) keys(ctx context.Context, reload bool) ([]*secretVersion, error) {
+	refresh := s.RefreshInterval
+	if refresh <= 0 {
+		refresh = defaultSecretRefreshInterval
+	}
+
+	s.RLock()
+	versions, loadedAt := s.versions, s.loadedAt
+	s.RUnlock()
+
+	age := time.Since(loadedAt)
+	if len(versions) > 0 && age < refresh && (!reload || age < secretReloadInterval) {
+		return versions, nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Another caller may have reloaded in the meantime
+	if s.loadedAt != loadedAt {
+		return s.versions, nil
+	}
+
+	versions, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.versions = versions
+	s.loadedAt = time.Now()
+
+	return versions, nil
+}
+
+// load lists the enabled versions and accesses the payloads not already known, versions are immutable
+func (s *SecretConfig) load(ctx context.Context) ([]*secretVersion, error) {
+	service := s.SecretService
+	if service == nil {
+		var err error
+		service, err = secretmanager.NewService(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var ids []int
+	err := service.Projects.Secrets.Versions.List(s.SecretName).Filter("state:ENABLED").Pages(ctx,
+		func(resp *secretmanager.ListSecretVersionsResponse) error {
+			for _, version := range resp.Versions {
+				if version.State != "" && version.State != "ENABLED" {
+					continue
+				}
+				id, err := strconv.Atoi(path.Base(version.Name))
+				if err != nil {
+					return fmt.Errorf("gcpjwt: unexpected secret version name `%s`", version.Name)
+				}
+				ids = append(ids, id)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("gcpjwt: no enabled version found for secret `%s`", s.SecretName)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	n := s.VerifyVersions
+	if n <= 0 {
+		n = defaultSecretVerifyVersions
+	}
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+
+	payloads := make(map[string][]byte, len(ids))
+	versions := make([]*secretVersion, 0, len(ids))
+	for _, id := range ids {
+		kid := strconv.Itoa(id)
+		key, ok := s.payloads[kid]
+		if !ok {
+			resp, err := service.Projects.Secrets.Versions.Access(s.SecretName + "/versions/" + kid).Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			key, err = base64.StdEncoding.DecodeString(resp.Payload.Data)
+			if err != nil {
+				return nil, fmt.Errorf("gcpjwt: could not decode secret version `%s`: %v", kid, err)
+			}
+		}
+		payloads[kid] = key
+		versions = append(versions, &secretVersion{id: kid, key: key})
+	}
+	s.payloads = payloads
+
+	return versions, nil
+}
+
+// key returns the key of the version with the kid, or the latest version if kid is empty
+func (s *SecretConfig) key(ctx context.Context, kid string) (*secretVersion, error) {
+	versions, err := s.keys(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if kid == "" {
+		return versions[0], nil
+	}
+
+	for reload := false; ; reload = true {
+		for _, version := range versions {
+			if version.id == kid {
+				return version, nil
+			}
+		}
+		if reload {
+			return nil, fmt.Errorf("gcpjwt: unknown kid `%s` for secret `%s`", kid, s.SecretName)
+		}
+
+		// The secret may have been rotated since the versions were loaded
+		versions, err = s.keys(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// NewSecretContext returns a new context.Context that carries a provided SecretConfig value
+func NewSecretContext(parent context.Context, val *SecretConfig) context.Context {
+	return context.WithValue(parent, secretConfigKey{}, val)
+}
+
+// SecretFromContext extracts a SecretConfig from a context.Context
+func SecretFromContext(ctx context.Context) (*SecretConfig, bool) {
+	val, ok := ctx.Value(secretConfigKey{}).(*SecretConfig)
+	return val, ok
+}
+
+// SigningMethodSecret implements the jwt.SiginingMethod interface for HMAC keys stored in Google Secret Manager
+type SigningMethodSecret struct {
+	alg      string
+	override *jwt.SigningMethodHMAC
+}
+
+var (
+	// SigningMethodSecretHS256 signs HS256 tokens with a Secret Manager secret
+	SigningMethodSecretHS256 *SigningMethodSecret
+	// SigningMethodSecretHS384 signs HS384 tokens with a Secret Manager secret
+	SigningMethodSecretHS384 *SigningMethodSecret
+	// SigningMethodSecretHS512 signs HS512 tokens with a Secret Manager secret
+	SigningMethodSecretHS512 *SigningMethodSecret
+)
+
+func init() {
+	// HS256
+	SigningMethodSecretHS256 = &SigningMethodSecret{
+		"SecretHS256",
+		jwt.SigningMethodHS256,
+	}
+	jwt.RegisterSigningMethod(SigningMethodSecretHS256.Alg(), func() jwt.SigningMethod {
+		return SigningMethodSecretHS256
+	})
+
+	// HS384
+	SigningMethodSecretHS384 = &SigningMethodSecret{
+		"SecretHS384",
+		jwt.SigningMethodHS384,
+	}
+	jwt.RegisterSigningMethod(SigningMethodSecretHS384.Alg(), func() jwt.SigningMethod {
+		return SigningMethodSecretHS384
+	})
+
+	// HS512
+	SigningMethodSecretHS512 = &SigningMethodSecret{
+		"SecretHS512",
+		jwt.SigningMethodHS512,
+	}
+	jwt.RegisterSigningMethod(SigningMethodSecretHS512.Alg(), func() jwt.SigningMethod {
+		return SigningMethodSecretHS512
+	})
+}
+
+// Alg will return the JWT header algorithm identifier this method is configured for.
+func (s *SigningMethodSecret) Alg() string {
+	return s.alg
+}
+
+// Override will override the default JWT implementation of the signing function this Secret Manager type implements.
+func (s *SigningMethodSecret) Override() {
+	s.alg = s.override.Alg()
+	jwt.RegisterSigningMethod(s.alg, func() jwt.SigningMethod {
+		return s
+	})
+}
+
+// Overridden returns a copy of this signing method using the algorithm identifier it would have after calling
+// Override, without modifying this method or the global jwt-go registry. Use it along with a Registry.
+func (s *SigningMethodSecret) Overridden() *SigningMethodSecret {
+	method := *s
+	method.alg = s.override.Alg()
+	return &method
+}
+
+// Restore will undo Override, resetting this method's algorithm identifier and restoring the default JWT
+// implementation if this method is still the one registered for it.
+func (s *SigningMethodSecret) Restore() {
+	restoreSigningMethod(s.override.Alg(), s, s.override)
+	s.alg = "Secret" + s.override.Alg()
+}
+
+// Sign implements the Sign method from jwt.SigningMethod. For this signing method, a valid context.Context must be
+// passed as the key containing a SecretConfig value. The version named by the token's `kid` header is used, see
+// SecretConfig.KeyID, or the latest version if the header has none.
+func (s *SigningMethodSecret) Sign(signingString string, key interface{}) (string, error) {
+	ctx, ok := key.(context.Context)
+	if !ok {
+		return "", jwt.ErrInvalidKey
+	}
+
+	config, ok := SecretFromContext(ctx)
+	if !ok {
+		return "", ErrMissingConfig
+	}
+
+	version, err := config.key(ctx, signingStringKeyID(signingString))
+	if err != nil {
+		return "", err
+	}
+
+	return s.override.Sign(signingString, version.key)
+}
+
+// Verify does a pass-thru to the appropriate jwt.SigningMethodHMAC for this signing algorithm and expects the same key
+func (s *SigningMethodSecret) Verify(signingString, signature string, key interface{}) error {
+	return s.override.Verify(signingString, signature, key)
+}
+
+// signingStringKeyID returns the `kid` header of the signing string, if any
+func signingStringKeyID(signingString string) string {
+	header, err := jwt.DecodeSegment(strings.SplitN(signingString, ".", 2)[0])
+	if err != nil {
+		return ""
+	}
+
+	var h struct {
+		KeyID string `json:"kid"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return ""
+	}
+	return h.KeyID
+}
+
+// SecretVerifyKeyfunc is a helper meant that returns a jwt.Keyfunc. It will handle selecting the secret version to
+// verify signatures with based on the `kid` header (the latest version if none), reloading the versions when unknown.
+func SecretVerifyKeyfunc(ctx context.Context, config *SecretConfig) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		// Make sure we have the proper header alg
+		if _, ok := token.Method.(*SigningMethodSecret); !ok {
+			return nil, fmt.Errorf("gcpjwt: unexpected signing method: %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		version, err := config.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+
+		return version.key, nil
+	}
+}
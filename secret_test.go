@@ -0,0 +1,169 @@
+package gcpjwt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/api/option"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// fakeSecretManager serves the versions list and access endpoints of the Secret Manager API for a single secret
+type fakeSecretManager struct {
+	sync.Mutex
+	name     string
+	versions map[int]string // version -> payload, empty if disabled
+	accesses int
+}
+
+func (f *fakeSecretManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	switch {
+	case r.URL.Path == "/v1/"+f.name+"/versions":
+		resp := &secretmanager.ListSecretVersionsResponse{}
+		for id, payload := range f.versions {
+			state := "ENABLED"
+			if payload == "" {
+				state = "DISABLED"
+			}
+			resp.Versions = append(resp.Versions, &secretmanager.SecretVersion{
+				Name:  fmt.Sprintf("%s/versions/%d", f.name, id),
+				State: state,
+			})
+		}
+		json.NewEncoder(w).Encode(resp)
+	case strings.HasPrefix(r.URL.Path, "/v1/"+f.name+"/versions/") && strings.HasSuffix(r.URL.Path, ":access"):
+		var id int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/v1/"+f.name+"/versions/"), "%d:access", &id)
+		payload, ok := f.versions[id]
+		if !ok || payload == "" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		f.accesses++
+		json.NewEncoder(w).Encode(&secretmanager.AccessSecretVersionResponse{
+			Name:    fmt.Sprintf("%s/versions/%d", f.name, id),
+			Payload: &secretmanager.SecretPayload{Data: base64.StdEncoding.EncodeToString([]byte(payload))},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeSecretManager) setVersion(id int, payload string) {
+	f.Lock()
+	defer f.Unlock()
+	f.versions[id] = payload
+}
+
+func TestSigningMethodSecret(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeSecretManager{
+		name:     "projects/p/secrets/jwt",
+		versions: map[int]string{1: "first", 2: "second", 3: ""},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	service, err := secretmanager.NewService(ctx, option.WithEndpoint(server.URL), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloadInterval := secretReloadInterval
+	secretReloadInterval = 0
+	defer func() { secretReloadInterval = reloadInterval }()
+
+	newConfig := func() *SecretConfig {
+		return &SecretConfig{SecretName: fake.name, SecretService: service}
+	}
+	signer, verifier := newConfig(), newConfig()
+	keyFunc := SecretVerifyKeyfunc(ctx, verifier)
+
+	sign := func(config *SecretConfig, kid string) string {
+		token := jwt.NewWithClaims(SigningMethodSecretHS256, &jwt.StandardClaims{Subject: "test"})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(NewSecretContext(ctx, config))
+		if err != nil {
+			t.Fatalf("SignedString() error = %v", err)
+		}
+		return s
+	}
+	verify := func(tokenString string) error {
+		_, err := jwt.Parse(tokenString, keyFunc)
+		return err
+	}
+
+	kid, err := signer.KeyID(ctx)
+	if err != nil {
+		t.Fatalf("SecretConfig.KeyID() error = %v", err)
+	}
+	if kid != "2" {
+		t.Errorf("SecretConfig.KeyID() = %v, want 2", kid)
+	}
+
+	if err := verify(sign(signer, kid)); err != nil {
+		t.Errorf("verify latest version error = %v", err)
+	}
+	if err := verify(sign(signer, "")); err != nil {
+		t.Errorf("verify without kid error = %v", err)
+	}
+	if err := verify(sign(signer, "1")); err != nil {
+		t.Errorf("verify previous version error = %v", err)
+	}
+
+	// Rotate: version 4 is added by another instance, the verifier reloads on the unknown kid
+	fake.setVersion(4, "fourth")
+	other := newConfig()
+	kid, err = other.KeyID(ctx)
+	if err != nil || kid != "4" {
+		t.Fatalf("SecretConfig.KeyID() = %v, %v, want 4", kid, err)
+	}
+	if err := verify(sign(other, kid)); err != nil {
+		t.Errorf("verify rotated version error = %v", err)
+	}
+	// Only the last 2 enabled versions verify
+	if err := verify(sign(signer, "1")); err == nil {
+		t.Errorf("verify expected error for a version older than VerifyVersions")
+	}
+	if _, err := verifier.key(ctx, "3"); err == nil {
+		t.Errorf("SecretConfig.key() expected error for a disabled version")
+	}
+
+	// Payloads of versions are only accessed once per config
+	accesses := fake.accesses
+	if _, err := verifier.keys(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if fake.accesses != accesses {
+		t.Errorf("reload accessed %d versions again", fake.accesses-accesses)
+	}
+
+	t.Run("Override", func(t *testing.T) {
+		method := SigningMethodSecretHS256.Overridden()
+		if method.Alg() != "HS256" || SigningMethodSecretHS256.Alg() != "SecretHS256" {
+			t.Errorf("Overridden() alg = %v, original = %v", method.Alg(), SigningMethodSecretHS256.Alg())
+		}
+
+		tokenString, err := jwt.New(method).SignedString(NewSecretContext(ctx, other))
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry := NewRegistry(method)
+		if _, err := registry.Parse(tokenString, keyFunc); err != nil {
+			t.Errorf("Registry.Parse() error = %v", err)
+		}
+	})
+}
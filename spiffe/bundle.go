@@ -0,0 +1,119 @@
+package spiffe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+const (
+	// KeyUseJWTSVID is the `use` of the JWT-SVID keys of a bundle
+	KeyUseJWTSVID = "jwt-svid"
+)
+
+// Bundle is the SPIFFE bundle of a trust domain holding its JWT-SVID keys, a JWK Set with the `spiffe_refresh_hint`
+// and `spiffe_sequence` parameters. It implements http.Handler to publish it, e.g. as the trust domain's bundle
+// endpoint.
+// https://github.com/spiffe/spiffe/blob/main/standards/SPIFFE_Trust_Domain_and_Bundle.md
+type Bundle struct {
+	// TrustDomain of the bundle, not part of its JSON encoding
+	TrustDomain string
+
+	// Keys are the JWT-SVID keys
+	Keys []*gcpjwt.JWK
+
+	// RefreshHint is how often consumers should refresh the bundle, and how long they may cache it
+	RefreshHint time.Duration
+
+	// Sequence is incremented on each change of the bundle
+	Sequence uint64
+}
+
+type bundleJSON struct {
+	Keys        []*gcpjwt.JWK `json:"keys"`
+	RefreshHint int64         `json:"spiffe_refresh_hint,omitempty"`
+	Sequence    uint64        `json:"spiffe_sequence,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (b *Bundle) MarshalJSON() ([]byte, error) {
+	keys := b.Keys
+	if keys == nil {
+		keys = []*gcpjwt.JWK{}
+	}
+	return json.Marshal(&bundleJSON{
+		Keys:        keys,
+		RefreshHint: int64(b.RefreshHint / time.Second),
+		Sequence:    b.Sequence,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *Bundle) UnmarshalJSON(data []byte) error {
+	raw := &bundleJSON{}
+	if err := json.Unmarshal(data, raw); err != nil {
+		return err
+	}
+	b.Keys = raw.Keys
+	b.RefreshHint = time.Duration(raw.RefreshHint) * time.Second
+	b.Sequence = raw.Sequence
+	return nil
+}
+
+// NewKMSBundle returns the bundle of the trust domain with the JWT-SVID keys of the KMS key versions for the signing
+// method, with their key ids set to KMSConfig.KeyID().
+func NewKMSBundle(ctx context.Context, trustDomain string, refreshHint time.Duration, method *gcpjwt.SigningMethodKMS, configs ...*gcpjwt.KMSConfig) (*Bundle, error) {
+	if !validTrustDomain(trustDomain) {
+		return nil, fmt.Errorf("%v: trust domain `%s`", ErrInvalidID, trustDomain)
+	}
+
+	bundle := &Bundle{TrustDomain: trustDomain, RefreshHint: refreshHint, Keys: make([]*gcpjwt.JWK, 0, len(configs))}
+	for _, config := range configs {
+		jwk, err := gcpjwt.KMSJWK(ctx, config, method)
+		if err != nil {
+			return nil, fmt.Errorf("gcpjwt/spiffe: could not get public key of `%s`: %v", config.KeyPath, err)
+		}
+		jwk.Use = KeyUseJWTSVID
+		bundle.Keys = append(bundle.Keys, jwk)
+	}
+	return bundle, nil
+}
+
+// Keyfunc returns a jwt.Keyfunc selecting the JWT-SVID key of the bundle with the token's `kid` header.
+func (b *Bundle) Keyfunc() jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, jwk := range b.Keys {
+			if jwk.KeyID == kid && (jwk.Use == "" || jwk.Use == KeyUseJWTSVID) {
+				return jwk.PublicKey()
+			}
+		}
+		return nil, fmt.Errorf("gcpjwt/spiffe: could not find key for key id `%s` in bundle of `%s`", kid, b.TrustDomain)
+	}
+}
+
+// ServeHTTP implements http.Handler, publishing the bundle cacheable for its RefreshHint.
+func (b *Bundle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if b.RefreshHint > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(b.RefreshHint/time.Second)))
+	}
+	w.Write(data)
+}
@@ -0,0 +1,86 @@
+// Package spiffe mints and validates SPIFFE JWT-SVIDs signed with Cloud KMS and publishes the JWT bundle of a trust
+// domain, built on the gcpjwt signing methods and key caches.
+//
+// https://github.com/spiffe/spiffe/blob/main/standards/JWT-SVID.md
+package spiffe
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	scheme = "spiffe://"
+)
+
+var (
+	// ErrInvalidID is returned for malformed SPIFFE IDs
+	ErrInvalidID = errors.New("gcpjwt/spiffe: invalid SPIFFE ID")
+)
+
+// ID is a SPIFFE ID, spiffe://<trust domain><path>.
+// https://github.com/spiffe/spiffe/blob/main/standards/SPIFFE-ID.md
+type ID struct {
+	TrustDomain string
+	Path        string
+}
+
+// ParseID parses a SPIFFE ID.
+func ParseID(id string) (ID, error) {
+	if !strings.HasPrefix(id, scheme) {
+		return ID{}, ErrInvalidID
+	}
+	rest := id[len(scheme):]
+
+	trustDomain, path := rest, ""
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		trustDomain, path = rest[:i], rest[i:]
+	}
+	if !validTrustDomain(trustDomain) || !validPath(path) {
+		return ID{}, ErrInvalidID
+	}
+
+	return ID{TrustDomain: trustDomain, Path: path}, nil
+}
+
+// String returns the SPIFFE ID.
+func (id ID) String() string {
+	return scheme + id.TrustDomain + id.Path
+}
+
+// MemberOf reports whether the ID is in the trust domain.
+func (id ID) MemberOf(trustDomain string) bool {
+	return id.TrustDomain == trustDomain
+}
+
+// validTrustDomain checks the trust domain only has lowercase letters, digits, dots, dashes and underscores
+func validTrustDomain(trustDomain string) bool {
+	if trustDomain == "" {
+		return false
+	}
+	for _, c := range trustDomain {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// validPath checks the path segments are not empty, `.` or `..`, and only have letters, digits, dots, dashes and
+// underscores
+func validPath(path string) bool {
+	if path == "" {
+		return true
+	}
+	for _, segment := range strings.Split(path[1:], "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+		for _, c := range segment {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package spiffe
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    ID
+		wantErr bool
+	}{
+		{"spiffe://example.org/ns/prod/sa/api", ID{"example.org", "/ns/prod/sa/api"}, false},
+		{"spiffe://example.org", ID{"example.org", ""}, false},
+		{"spiffe://example.org/", ID{}, true},
+		{"spiffe://example.org/a//b", ID{}, true},
+		{"spiffe://example.org/a/../b", ID{}, true},
+		{"spiffe://Example.org/a", ID{}, true},
+		{"spiffe://example.org:8080/a", ID{}, true},
+		{"spiffe://user@example.org/a", ID{}, true},
+		{"spiffe://example.org/a?b", ID{}, true},
+		{"spiffe:///a", ID{}, true},
+		{"https://example.org/a", ID{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseID(tt.id)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseID(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseID(%q) = %+v, want %+v", tt.id, got, tt.want)
+		}
+		if err == nil && got.String() != tt.id {
+			t.Errorf("String() = %q, want %q", got.String(), tt.id)
+		}
+	}
+}
+
+func TestJWTSVID(t *testing.T) {
+	ctx := context.Background()
+	const trustDomain = "example.org"
+	config := &gcpjwt.KMSConfig{KeyPath: "projects/p/locations/l/keyRings/r/cryptoKeys/svid/cryptoKeyVersions/1", LocalMode: true}
+	minter := NewMinter(trustDomain, gcpjwt.SigningMethodKMSES256, config)
+
+	bundle, err := NewKMSBundle(ctx, trustDomain, time.Minute, gcpjwt.SigningMethodKMSES256, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle.Sequence = 1
+
+	// The published bundle
+	w := httptest.NewRecorder()
+	bundle.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["spiffe_refresh_hint"] != float64(60) || raw["spiffe_sequence"] != float64(1) || w.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Errorf("unexpected bundle %s %v", w.Body, w.Header())
+	}
+	published := &Bundle{}
+	if err := json.Unmarshal(w.Body.Bytes(), published); err != nil {
+		t.Fatal(err)
+	}
+	if len(published.Keys) != 1 || published.Keys[0].Use != KeyUseJWTSVID || published.Keys[0].KeyID != config.KeyID() || published.RefreshHint != time.Minute {
+		t.Errorf("unexpected decoded bundle %+v", published)
+	}
+
+	server := httptest.NewServer(bundle)
+	defer server.Close()
+
+	mint := func(id string, audience ...string) string {
+		svid, err := minter.Mint(ctx, id, audience...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return svid
+	}
+	valid := mint("spiffe://example.org/workload", "https://api.example.com")
+
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "spiffe://example.org/workload", "aud": "https://api.example.com", "exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := jwt.NewWithClaims(gcpjwt.SigningMethodKMSES256.Overridden(), jwt.MapClaims{
+		"sub": "spiffe://example.org/workload", "aud": "https://api.example.com", "exp": time.Now().Add(-time.Minute).Unix(),
+	})
+	expired.Header["kid"] = config.KeyID()
+	expiredSVID, err := expired.SignedString(gcpjwt.NewKMSContext(ctx, config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validators := []struct {
+		name      string
+		validator *Validator
+	}{
+		{"Bundle", NewValidator(bundle)},
+		{"BundleEndpoint", NewValidator()},
+	}
+	validators[1].validator.AddBundleEndpoint(ctx, trustDomain, server.URL, server.Client())
+
+	tests := []struct {
+		name     string
+		svid     string
+		audience string
+		wantID   string
+		wantErr  bool
+	}{
+		{"Valid", valid, "https://api.example.com", "spiffe://example.org/workload", false},
+		{"MultipleAudiences", mint("spiffe://example.org/other", "https://a.example.com", "https://api.example.com"), "https://api.example.com", "spiffe://example.org/other", false},
+		{"WrongAudience", valid, "https://other.example.com", "", true},
+		{"Expired", expiredSVID, "https://api.example.com", "", true},
+		{"HMAC", hmac, "https://api.example.com", "", true},
+		{"Malformed", "not.a.token", "https://api.example.com", "", true},
+	}
+	for _, v := range validators {
+		for _, tt := range tests {
+			svid, err := v.validator.Validate(tt.svid, tt.audience)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("%s/%s: Validate() error = %v, wantErr %v", v.name, tt.name, err, tt.wantErr)
+				continue
+			}
+			if err == nil && (svid.ID.String() != tt.wantID || svid.Expiry.IsZero() || len(svid.Audience) == 0) {
+				t.Errorf("%s/%s: Validate() = %+v", v.name, tt.name, svid)
+			}
+		}
+	}
+
+	// Other trust domains are unknown
+	other := NewMinter("other.org", gcpjwt.SigningMethodKMSES256, config)
+	otherSVID, err := other.Mint(ctx, "spiffe://other.org/workload", "https://api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := validators[0].validator.Validate(otherSVID, "https://api.example.com"); err != ErrUnknownTrustDomain {
+		t.Errorf("Validate() error = %v, want %v", err, ErrUnknownTrustDomain)
+	}
+
+	if _, err := minter.Mint(ctx, "spiffe://other.org/workload", "aud"); err == nil {
+		t.Errorf("Mint() expected an error for an ID of another trust domain")
+	}
+	if _, err := minter.Mint(ctx, "spiffe://example.org/workload"); err == nil {
+		t.Errorf("Mint() expected an error without audience")
+	}
+}
@@ -0,0 +1,195 @@
+package spiffe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+const (
+	defaultSVIDTTL       = 5 * time.Minute
+	defaultBundleRefresh = 5 * time.Minute
+)
+
+var (
+	// ErrUnknownTrustDomain is returned when validating a JWT-SVID of a trust domain without bundle
+	ErrUnknownTrustDomain = errors.New("gcpjwt/spiffe: no bundle for trust domain")
+	// ErrInvalidSVID is returned for JWT-SVIDs without the required claims or for another audience
+	ErrInvalidSVID = errors.New("gcpjwt/spiffe: invalid JWT-SVID")
+
+	// JWT-SVIDs are signed with RSA or ECDSA, resolved without the global (possibly overridden) jwt-go registry
+	svidMethods = gcpjwt.NewRegistry(
+		jwt.SigningMethodRS256, jwt.SigningMethodRS384, jwt.SigningMethodRS512,
+		jwt.SigningMethodES256, jwt.SigningMethodES384, jwt.SigningMethodES512,
+		jwt.SigningMethodPS256, jwt.SigningMethodPS384, jwt.SigningMethodPS512,
+	)
+)
+
+// Minter mints the JWT-SVIDs of the workloads of a trust domain, signed with Cloud KMS. Publish the matching keys
+// with NewKMSBundle.
+type Minter struct {
+	// TrustDomain of the SPIFFE IDs minted for
+	TrustDomain string
+
+	// Method and KMSConfig sign the JWT-SVIDs, the `alg` header is the standard algorithm of the method and the `kid`
+	// header KMSConfig.KeyID()
+	Method    *gcpjwt.SigningMethodKMS
+	KMSConfig *gcpjwt.KMSConfig
+
+	// TTL is the lifetime of the JWT-SVIDs, defaults to 5 minutes
+	TTL time.Duration
+}
+
+// NewMinter returns a Minter for the trust domain signing with the method and KMS key.
+func NewMinter(trustDomain string, method *gcpjwt.SigningMethodKMS, config *gcpjwt.KMSConfig) *Minter {
+	return &Minter{
+		TrustDomain: trustDomain,
+		Method:      method,
+		KMSConfig:   config,
+	}
+}
+
+// Mint returns a JWT-SVID for the SPIFFE ID, which must be in the trust domain, and the audiences.
+func (m *Minter) Mint(ctx context.Context, id string, audience ...string) (string, error) {
+	spiffeID, err := ParseID(id)
+	if err != nil {
+		return "", err
+	}
+	if !spiffeID.MemberOf(m.TrustDomain) {
+		return "", fmt.Errorf("%v: `%s` is not in trust domain `%s`", ErrInvalidID, id, m.TrustDomain)
+	}
+	if len(audience) == 0 {
+		return "", fmt.Errorf("%v: audience is required", ErrInvalidSVID)
+	}
+
+	ttl := m.TTL
+	if ttl <= 0 {
+		ttl = defaultSVIDTTL
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub": spiffeID.String(),
+		"aud": audience,
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}
+	if len(audience) == 1 {
+		claims["aud"] = audience[0]
+	}
+
+	token := jwt.NewWithClaims(m.Method.Overridden(), claims)
+	token.Header["kid"] = m.KMSConfig.KeyID()
+	return token.SignedString(gcpjwt.NewKMSContext(ctx, m.KMSConfig))
+}
+
+// SVID is a validated JWT-SVID.
+type SVID struct {
+	ID       ID
+	Audience []string
+	Expiry   time.Time
+	Claims   jwt.MapClaims
+}
+
+// Validator validates JWT-SVIDs with the bundles of their trust domain.
+type Validator struct {
+	// keyfuncs by trust domain
+	keyfuncs map[string]jwt.Keyfunc
+
+	sync.RWMutex
+}
+
+// NewValidator returns a Validator trusting the bundles.
+func NewValidator(bundles ...*Bundle) *Validator {
+	v := &Validator{keyfuncs: make(map[string]jwt.Keyfunc, len(bundles))}
+	for _, bundle := range bundles {
+		v.AddBundle(bundle)
+	}
+	return v
+}
+
+// AddBundle trusts the bundle, replacing the bundle of its trust domain.
+func (v *Validator) AddBundle(bundle *Bundle) {
+	v.Lock()
+	defer v.Unlock()
+
+	v.keyfuncs[bundle.TrustDomain] = bundle.Keyfunc()
+}
+
+// AddBundleEndpoint trusts the bundle of the trust domain published at the URL, e.g. by a Bundle. The keys are
+// fetched with the client, http.DefaultClient if nil, and cached for the bundle's Cache-Control max-age or 5 minutes.
+func (v *Validator) AddBundleEndpoint(ctx context.Context, trustDomain, url string, client *http.Client) {
+	v.Lock()
+	defer v.Unlock()
+
+	v.keyfuncs[trustDomain] = gcpjwt.JWKSetVerifyKeyfunc(ctx, &gcpjwt.JWKSetConfig{
+		URL:             url,
+		Use:             KeyUseJWTSVID,
+		EnableCache:     true,
+		CacheExpiration: defaultBundleRefresh,
+		Client:          client,
+	})
+}
+
+// Validate verifies the JWT-SVID with the bundle of the trust domain of its SPIFFE ID and checks it is not expired
+// and for the audience.
+func (v *Validator) Validate(tokenString, audience string) (*SVID, error) {
+	claims := jwt.MapClaims{}
+	token, err := svidMethods.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		sub, _ := claims["sub"].(string)
+		id, err := ParseID(sub)
+		if err != nil {
+			return nil, err
+		}
+
+		v.RLock()
+		keyFunc, ok := v.keyfuncs[id.TrustDomain]
+		v.RUnlock()
+		if !ok {
+			return nil, ErrUnknownTrustDomain
+		}
+		return keyFunc(token)
+	})
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Inner != nil {
+			return nil, ve.Inner
+		}
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, ErrInvalidSVID
+	}
+	if typ, ok := token.Header["typ"].(string); ok && typ != "JWT" && typ != "JOSE" {
+		return nil, ErrInvalidSVID
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || !claims.VerifyAudience(audience, true) {
+		return nil, ErrInvalidSVID
+	}
+
+	// The trust domain was checked by the keyfunc
+	id, _ := ParseID(claims["sub"].(string))
+	svid := &SVID{
+		ID:     id,
+		Expiry: time.Unix(int64(exp), 0),
+		Claims: claims,
+	}
+	switch aud := claims["aud"].(type) {
+	case string:
+		svid.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				svid.Audience = append(svid.Audience, s)
+			}
+		}
+	}
+	return svid, nil
+}
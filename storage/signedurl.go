@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// utcNow is replaced in tests
+	utcNow = func() time.Time {
+		return time.Now().UTC()
+	}
+)
+
+// URLStyle determines how the bucket is addressed in a signed URL.
+type URLStyle int
+
+const (
+	// PathStyle addresses the bucket in the path: https://storage.googleapis.com/bucket/object
+	PathStyle URLStyle = iota
+	// VirtualHostedStyle addresses the bucket in the host: https://bucket.storage.googleapis.com/object
+	VirtualHostedStyle
+	// BucketBoundHostname uses the Hostname as a CNAME for the bucket: https://hostname/object
+	BucketBoundHostname
+)
+
+// SignedURLOptions are the options used to generate a V4 signed URL.
+type SignedURLOptions struct {
+	// GoogleAccessID is the email of the service account owning the signing key. Required.
+	GoogleAccessID string
+
+	// SignBytes signs the string-to-sign, see IAMSigner and KMSSigner. Required.
+	SignBytes SignBytesFunc
+
+	// Method is the HTTP method the URL will be used with, e.g. GET or PUT. Required.
+	Method string
+
+	// Expires is the expiration time of the URL, at most 7 days in the future. Required.
+	Expires time.Time
+
+	// ContentType is the content type header the client must provide when using the URL. Optional.
+	ContentType string
+
+	// Headers are extra headers ("name:value") the client must provide when using the URL. Optional.
+	Headers []string
+
+	// QueryParameters are extra query parameters to include in the signed URL. Optional.
+	QueryParameters url.Values
+
+	// MD5 is the base64 encoded MD5 checksum the client must provide when using the URL. Optional.
+	MD5 string
+
+	// Style is the URL style to use, PathStyle by default.
+	Style URLStyle
+
+	// Hostname overrides the default storage.googleapis.com host. Required for BucketBoundHostname.
+	Hostname string
+
+	// Insecure generates an http URL instead of https.
+	Insecure bool
+}
+
+func (opts *SignedURLOptions) validate() error {
+	if opts == nil {
+		return errors.New("gcpjwt/storage: missing required SignedURLOptions")
+	}
+	if opts.GoogleAccessID == "" {
+		return errors.New("gcpjwt/storage: missing required GoogleAccessID")
+	}
+	if opts.SignBytes == nil {
+		return errors.New("gcpjwt/storage: missing required SignBytes")
+	}
+	if opts.Method == "" {
+		return errors.New("gcpjwt/storage: missing required method")
+	}
+	if opts.Expires.IsZero() {
+		return errors.New("gcpjwt/storage: missing required expires option")
+	}
+	if opts.Style == BucketBoundHostname && opts.Hostname == "" {
+		return errors.New("gcpjwt/storage: missing required hostname for bucket bound hostname style")
+	}
+	return nil
+}
+
+// SignedURL returns a V4 signed URL for the object in the bucket.
+// https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+func SignedURL(bucket, object string, opts *SignedURLOptions) (string, error) {
+	if bucket == "" {
+		return "", errors.New("gcpjwt/storage: missing required bucket name")
+	}
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
+
+	now := utcNow()
+	expires := int64(opts.Expires.Sub(now).Seconds())
+	if expires <= 0 {
+		return "", errors.New("gcpjwt/storage: expires must be in the future")
+	}
+	if expires > maxExpiration {
+		return "", fmt.Errorf("gcpjwt/storage: expires must be within %d seconds from now", maxExpiration)
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s\n", opts.Method)
+
+	u := &url.URL{Path: urlPath(bucket, object, opts.Style)}
+	u.RawPath = pathEncode(u.Path)
+	fmt.Fprintf(buf, "%s\n", u.EscapedPath())
+
+	headers := sanitizeHeaders(opts.Headers)
+	headerNames := append(headerNames(headers), "host")
+	if opts.ContentType != "" {
+		headerNames = append(headerNames, "content-type")
+	}
+	if opts.MD5 != "" {
+		headerNames = append(headerNames, "content-md5")
+	}
+	sort.Strings(headerNames)
+	signedHeaders := strings.Join(headerNames, ";")
+
+	timestamp := now.Format("20060102T150405Z")
+	credentialScope := fmt.Sprintf("%s/auto/storage/goog4_request", now.Format("20060102"))
+	credential := fmt.Sprintf("%s/%s", opts.GoogleAccessID, credentialScope)
+
+	query := url.Values{}
+	query.Set("X-Goog-Algorithm", signingAlgorithm)
+	query.Set("X-Goog-Credential", credential)
+	query.Set("X-Goog-Date", timestamp)
+	query.Set("X-Goog-Expires", fmt.Sprintf("%d", expires))
+	query.Set("X-Goog-SignedHeaders", signedHeaders)
+	for k, v := range opts.QueryParameters {
+		query[k] = append(query[k], v...)
+	}
+	// url.Values.Encode escapes spaces as `+`, V4 requires `%20`
+	fmt.Fprintf(buf, "%s\n", strings.Replace(query.Encode(), "+", "%20", -1))
+
+	u.Host = urlHost(bucket, opts)
+	u.Scheme = "https"
+	if opts.Insecure {
+		u.Scheme = "http"
+	}
+
+	canonicalHeaders := append([]string{"host:" + u.Hostname()}, headers...)
+	if opts.ContentType != "" {
+		canonicalHeaders = append(canonicalHeaders, "content-type:"+strings.TrimSpace(opts.ContentType))
+	}
+	if opts.MD5 != "" {
+		canonicalHeaders = append(canonicalHeaders, "content-md5:"+strings.TrimSpace(opts.MD5))
+	}
+	sortHeaders(canonicalHeaders)
+	fmt.Fprintf(buf, "%s\n\n", strings.Join(canonicalHeaders, "\n"))
+	fmt.Fprintf(buf, "%s\n", signedHeaders)
+
+	// Use the X-Goog-Content-SHA256 header value if provided, UNSIGNED-PAYLOAD otherwise
+	payloadHash := "UNSIGNED-PAYLOAD"
+	for _, h := range headers {
+		if strings.HasPrefix(h, "x-goog-content-sha256:") {
+			payloadHash = strings.TrimPrefix(h, "x-goog-content-sha256:")
+			break
+		}
+	}
+	fmt.Fprint(buf, payloadHash)
+
+	sum := sha256.Sum256(buf.Bytes())
+	stringToSign := strings.Join([]string{
+		signingAlgorithm,
+		timestamp,
+		credentialScope,
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+
+	signature, err := opts.SignBytes([]byte(stringToSign))
+	if err != nil {
+		return "", fmt.Errorf("gcpjwt/storage: could not sign url: %v", err)
+	}
+
+	query.Set("X-Goog-Signature", hex.EncodeToString(signature))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+func urlPath(bucket, object string, style URLStyle) string {
+	if style == PathStyle {
+		return fmt.Sprintf("/%s/%s", bucket, object)
+	}
+	return fmt.Sprintf("/%s", object)
+}
+
+func urlHost(bucket string, opts *SignedURLOptions) string {
+	hostname := opts.Hostname
+	if hostname == "" {
+		hostname = "storage.googleapis.com"
+	}
+	if opts.Style == VirtualHostedStyle {
+		return fmt.Sprintf("%s.%s", bucket, hostname)
+	}
+	return hostname
+}
+
+// pathEncode escapes each path segment as required by the V4 signing process
+func pathEncode(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = url.QueryEscape(s)
+	}
+	return strings.Replace(strings.Join(segments, "/"), "+", "%20", -1)
+}
+
+// sanitizeHeaders lowercases header names, collapses whitespace in values and merges repeated headers
+func sanitizeHeaders(headers []string) []string {
+	values := make(map[string][]string)
+	var names []string
+	for _, h := range headers {
+		parts := strings.SplitN(strings.TrimSpace(h), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		values[name] = append(values[name], strings.Join(strings.Fields(parts[1]), " "))
+	}
+
+	sanitized := make([]string, 0, len(names))
+	for _, name := range names {
+		sanitized = append(sanitized, fmt.Sprintf("%s:%s", name, strings.Join(values[name], ",")))
+	}
+	return sanitized
+}
+
+func headerNames(headers []string) []string {
+	names := make([]string, 0, len(headers))
+	for _, h := range headers {
+		names = append(names, strings.SplitN(h, ":", 2)[0])
+	}
+	return names
+}
+
+func sortHeaders(headers []string) {
+	sort.Slice(headers, func(i, j int) bool {
+		return strings.SplitN(headers[i], ":", 2)[0] < strings.SplitN(headers[j], ":", 2)[0]
+	})
+}
@@ -0,0 +1,43 @@
+// Package storage generates Google Cloud Storage V4 signed URLs and signed POST policy documents with the IAM signBlob
+// API or a Cloud KMS RSA key, so no private key file is needed to hand out temporary access to objects.
+// https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+package storage
+
+import (
+	"context"
+
+	"github.com/golang-jwt/jwt"
+
+	gcpjwt "github.com/csmadhu/gcp-jwt-go"
+)
+
+const (
+	signingAlgorithm = "GOOG4-RSA-SHA256"
+	maxExpiration    = 604800 // 7 days in seconds
+)
+
+// SignBytesFunc signs the provided bytes with an RSA SHA-256 (PKCS #1 v1.5) key belonging to the GoogleAccessID.
+type SignBytesFunc func(b []byte) ([]byte, error)
+
+// IAMSigner returns a SignBytesFunc using the IAM signBlob API for the service account configured in the IAMConfig.
+func IAMSigner(ctx context.Context, config *gcpjwt.IAMConfig) SignBytesFunc {
+	return signWith(gcpjwt.SigningMethodIAMBlob, gcpjwt.NewIAMContext(ctx, config))
+}
+
+// KMSSigner returns a SignBytesFunc using a Cloud KMS RSA_SIGN_PKCS1_*_SHA256 key. The key's public key must be
+// uploaded as a key of the service account used as the GoogleAccessID.
+// https://cloud.google.com/iam/docs/keys-upload
+func KMSSigner(ctx context.Context, config *gcpjwt.KMSConfig) SignBytesFunc {
+	return signWith(gcpjwt.SigningMethodKMSRS256, gcpjwt.NewKMSContext(ctx, config))
+}
+
+func signWith(method jwt.SigningMethod, key interface{}) SignBytesFunc {
+	return func(b []byte) ([]byte, error) {
+		sig, err := method.Sign(string(b), key)
+		if err != nil {
+			return nil, err
+		}
+
+		return jwt.DecodeSegment(sig)
+	}
+}
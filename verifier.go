@@ -0,0 +1,339 @@
+package gcpjwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	// ErrNoVerifierConfig is returned by a Verifier's keyfunc before a configuration was loaded
+	ErrNoVerifierConfig = errors.New("gcpjwt: verifier has no configuration")
+	// ErrInvalidAudience is returned by a Verifier's keyfunc for tokens without one of the configured audiences
+	ErrInvalidAudience = errors.New("gcpjwt: token audience is not trusted")
+)
+
+// VerifierConfig is the part of a Verifier's configuration that can be swapped at runtime. It can be loaded from
+// JSON, with the CacheExpiration as a duration string such as "1h":
+//
+//	{
+//		"serviceAccounts": ["caller@project.iam.gserviceaccount.com"],
+//		"kmsKeyPaths": ["projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"],
+//		"audiences": ["https://service.example.com"],
+//		"enableCache": true,
+//		"cacheExpiration": "1h"
+//	}
+type VerifierConfig struct {
+	// ServiceAccounts are the trusted issuers of tokens signed with the IAM API, verified with their certificates
+	ServiceAccounts []string `json:"serviceAccounts"`
+
+	// KMSKeyPaths are the trusted KMS key versions, selected with the token's `kid` header (KMSConfig.KeyID)
+	KMSKeyPaths []string `json:"kmsKeyPaths"`
+
+	// Audiences, if not empty, are the accepted `aud` claims, any audience is accepted otherwise
+	Audiences []string `json:"audiences"`
+
+	// EnableCache and CacheExpiration configure the caching of the service accounts' certificates, see IAMConfig
+	EnableCache     bool          `json:"enableCache"`
+	CacheExpiration time.Duration `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler, parsing `cacheExpiration` with time.ParseDuration.
+func (c *VerifierConfig) UnmarshalJSON(b []byte) error {
+	type verifierConfig VerifierConfig
+	raw := struct {
+		*verifierConfig
+		CacheExpiration string `json:"cacheExpiration"`
+	}{verifierConfig: (*verifierConfig)(c)}
+
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if raw.CacheExpiration != "" {
+		d, err := time.ParseDuration(raw.CacheExpiration)
+		if err != nil {
+			return fmt.Errorf("gcpjwt: invalid cacheExpiration: %v", err)
+		}
+		c.CacheExpiration = d
+	}
+
+	return nil
+}
+
+// LoadVerifierConfigFile reads a JSON encoded VerifierConfig from the file.
+func LoadVerifierConfigFile(path string) (*VerifierConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &VerifierConfig{}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Verifier verifies tokens signed by the IAM API for the trusted service accounts or by the trusted KMS key versions.
+// Its VerifierConfig can be swapped atomically with Update, or reloaded with Watch and WatchFile, without restarting:
+// each verification uses the configuration current when it started.
+//
+// Client and KMSClient must be set before the first Update, the zero Verifier uses the default clients. Revocations
+// can be set to reject revoked tokens parsed with ParseWithClaims.
+type Verifier struct {
+	// Client is used to fetch the service accounts' certificates, see IAMConfig.Client
+	Client *http.Client
+
+	// KMSClient is used to retrieve the KMS public keys, see KMSConfig.KMSClient
+	KMSClient *kms.KeyManagementClient
+
+	// Revocations, if set, rejects revoked tokens in ParseWithClaims
+	Revocations RevocationChecker
+
+	// serializes updates
+	mu    sync.Mutex
+	state atomic.Value // *verifierState
+}
+
+type verifierState struct {
+	config *VerifierConfig
+
+	// keyfuncs by service account
+	iam map[string]jwt.Keyfunc
+
+	// keyfuncs by KMS key id
+	kms map[string]jwt.Keyfunc
+}
+
+// NewVerifier returns a Verifier for the config using the default clients.
+func NewVerifier(ctx context.Context, config *VerifierConfig) (*Verifier, error) {
+	v := &Verifier{}
+	if err := v.Update(ctx, config); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Update retrieves the public keys of the config's KMS key versions and then atomically replaces the current
+// configuration. The current configuration is kept if an error is returned. Public keys of key versions already
+// trusted are not retrieved again.
+func (v *Verifier) Update(ctx context.Context, config *VerifierConfig) error {
+	if config == nil {
+		return errors.New("gcpjwt: nil verifier config")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	previous := v.current()
+	state := &verifierState{
+		config: config,
+		iam:    make(map[string]jwt.Keyfunc, len(config.ServiceAccounts)),
+		kms:    make(map[string]jwt.Keyfunc, len(config.KMSKeyPaths)),
+	}
+
+	for _, serviceAccount := range config.ServiceAccounts {
+		state.iam[serviceAccount] = IAMVerfiyKeyfunc(ctx, &IAMConfig{
+			ServiceAccount:  serviceAccount,
+			EnableCache:     config.EnableCache,
+			CacheExpiration: config.CacheExpiration,
+			Client:          v.Client,
+		})
+	}
+
+	for _, keyPath := range config.KMSKeyPaths {
+		kmsConfig := &KMSConfig{KeyPath: keyPath, KMSClient: v.KMSClient}
+		keyID := kmsConfig.KeyID()
+		if previous != nil {
+			if keyFunc, ok := previous.kms[keyID]; ok {
+				state.kms[keyID] = keyFunc
+				continue
+			}
+		}
+
+		keyFunc, err := KMSVerfiyKeyfunc(ctx, kmsConfig)
+		if err != nil {
+			return fmt.Errorf("gcpjwt: could not get public key of `%s`: %v", keyPath, err)
+		}
+		state.kms[keyID] = keyFunc
+	}
+
+	v.state.Store(state)
+	return nil
+}
+
+func (v *Verifier) current() *verifierState {
+	state, _ := v.state.Load().(*verifierState)
+	return state
+}
+
+// Config returns the current configuration, which must not be modified.
+func (v *Verifier) Config() *VerifierConfig {
+	if state := v.current(); state != nil {
+		return state.config
+	}
+	return nil
+}
+
+// Keyfunc returns a jwt.Keyfunc checking the token's audience and selecting the key to verify it with, using the
+// configuration current when it is called. Revocations are not checked, see ParseWithClaims.
+func (v *Verifier) Keyfunc() jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		state := v.current()
+		if state == nil {
+			return nil, ErrNoVerifierConfig
+		}
+
+		if len(state.config.Audiences) > 0 {
+			audiences, err := tokenAudiences(token.Claims)
+			if err != nil {
+				return nil, err
+			}
+			if !containsAny(state.config.Audiences, audiences) {
+				return nil, ErrInvalidAudience
+			}
+		}
+
+		switch token.Method.(type) {
+		case *SigningMethodIAM:
+			iss, err := tokenIssuer(token.Claims)
+			if err != nil {
+				return nil, err
+			}
+			keyFunc, ok := state.iam[iss]
+			if !ok {
+				return nil, fmt.Errorf("gcpjwt: unknown issuer `%s`", iss)
+			}
+			return keyFunc(token)
+		case *SigningMethodKMS:
+			kid, ok := token.Header["kid"].(string)
+			if !ok && len(state.kms) == 1 {
+				for _, keyFunc := range state.kms {
+					return keyFunc(token)
+				}
+			}
+			keyFunc, ok := state.kms[kid]
+			if !ok {
+				return nil, fmt.Errorf("gcpjwt: unknown kid `%s` found in header", kid)
+			}
+			return keyFunc(token)
+		}
+
+		return nil, fmt.Errorf("gcpjwt: unexpected signing method: %v", token.Header["alg"])
+	}
+}
+
+// ParseWithClaims parses and verifies the token with the Keyfunc and then checks that the verified token is not
+// revoked by the Revocations with the ctx, returning ErrTokenRevoked or the error of the RevocationChecker.
+func (v *Verifier) ParseWithClaims(ctx context.Context, tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	token, err := jwt.ParseWithClaims(tokenString, claims, v.Keyfunc())
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckRevocation(ctx, v.Revocations, token.Claims); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Watch calls load every interval until the context is done, updating the Verifier with the configuration returned.
+// load returns a nil configuration when it is unchanged. Errors of load or Update are passed to onError, if not nil,
+// and the current configuration is kept.
+func (v *Verifier) Watch(ctx context.Context, interval time.Duration, load func(ctx context.Context) (*VerifierConfig, error), onError func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		config, err := load(ctx)
+		if err == nil && config != nil {
+			err = v.Update(ctx, config)
+		}
+		if err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+	}
+}
+
+// WatchFile reloads the JSON encoded VerifierConfig from the file whenever it differs from the current configuration,
+// checking every interval until the context is done, so a configuration whose Update failed is retried. See Watch.
+func (v *Verifier) WatchFile(ctx context.Context, path string, interval time.Duration, onError func(err error)) {
+	v.Watch(ctx, interval, func(ctx context.Context) (*VerifierConfig, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		config := &VerifierConfig{}
+		if err := json.Unmarshal(b, config); err != nil {
+			return nil, fmt.Errorf("gcpjwt: could not parse `%s`: %v", path, err)
+		}
+		if reflect.DeepEqual(config, v.Config()) {
+			return nil, nil
+		}
+		return config, nil
+	}, onError)
+}
+
+// tokenAudiences returns the `aud` claim, a string or an array of strings
+func tokenAudiences(claims jwt.Claims) ([]string, error) {
+	var aud interface{}
+	switch c := claims.(type) {
+	case jwt.MapClaims:
+		aud = c["aud"]
+	case *jwt.StandardClaims:
+		return []string{c.Audience}, nil
+	default:
+		b, err := json.Marshal(claims)
+		if err != nil {
+			return nil, err
+		}
+		var raw struct {
+			Audience interface{} `json:"aud"`
+		}
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return nil, err
+		}
+		aud = raw.Audience
+	}
+
+	switch a := aud.(type) {
+	case string:
+		return []string{a}, nil
+	case []interface{}:
+		audiences := make([]string, 0, len(a))
+		for _, v := range a {
+			if s, ok := v.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+		return audiences, nil
+	}
+	return nil, nil
+}
+
+func containsAny(trusted, values []string) bool {
+	for _, t := range trusted {
+		for _, v := range values {
+			if t == v {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package gcpjwt
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+func TestVerifier(t *testing.T) {
+	ctx := context.Background()
+	for _, env := range productionEnvVars {
+		t.Setenv(env, "")
+	}
+	if err := EnableLocalMode(); err != nil {
+		t.Fatal(err)
+	}
+	defer DisableLocalMode()
+
+	const (
+		accountA = "a@project.iam.gserviceaccount.com"
+		accountB = "b@project.iam.gserviceaccount.com"
+		keyPath1 = "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+		keyPath2 = "projects/p/locations/l/keyRings/r/cryptoKeys/k/cryptoKeyVersions/2"
+	)
+
+	iamToken := func(account, aud string) string {
+		tokenString, err := jwt.NewWithClaims(SigningMethodIAMBlob, &jwt.StandardClaims{Issuer: account, Audience: aud}).
+			SignedString(NewIAMContext(ctx, &IAMConfig{ServiceAccount: account}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+	kmsToken := func(keyPath, aud string) string {
+		config := &KMSConfig{KeyPath: keyPath}
+		token := jwt.NewWithClaims(SigningMethodKMSES256, jwt.MapClaims{"aud": []string{"other", aud}})
+		token.Header["kid"] = config.KeyID()
+		tokenString, err := token.SignedString(NewKMSContext(ctx, config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+
+	path := filepath.Join(t.TempDir(), "verifier.json")
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(`{"serviceAccounts": ["` + accountA + `"], "kmsKeyPaths": ["` + keyPath1 + `"], "audiences": ["aud"], "cacheExpiration": "1h"}`)
+
+	config, err := LoadVerifierConfigFile(path)
+	if err != nil {
+		t.Fatalf("LoadVerifierConfigFile() error = %v", err)
+	}
+	if config.CacheExpiration != time.Hour {
+		t.Errorf("CacheExpiration = %v, want %v", config.CacheExpiration, time.Hour)
+	}
+
+	verifier, err := NewVerifier(ctx, config)
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	keyFunc := verifier.Keyfunc()
+
+	verify := func(tokenString string) error {
+		_, err := jwt.Parse(tokenString, keyFunc)
+		return err
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantBefore bool
+		wantReload bool
+	}{
+		{"IAMTrusted", iamToken(accountA, "aud"), true, false},
+		{"IAMAdded", iamToken(accountB, "aud"), false, true},
+		{"IAMAudience", iamToken(accountA, "other"), false, false},
+		{"KMSTrusted", kmsToken(keyPath1, "aud"), true, true},
+		{"KMSAdded", kmsToken(keyPath2, "aud"), false, true},
+		{"KMSAudience", kmsToken(keyPath2, "unknown"), false, false},
+	}
+	for _, tt := range tests {
+		if err := verify(tt.token); (err == nil) != tt.wantBefore {
+			t.Errorf("%s: verify() before reload error = %v, want valid %v", tt.name, err, tt.wantBefore)
+		}
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	errs := make(chan error, 10)
+	go func() {
+		defer close(done)
+		verifier.WatchFile(watchCtx, path, 10*time.Millisecond, func(err error) {
+			errs <- err
+		})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// An invalid file keeps the current configuration
+	writeConfig(`{"serviceAccounts": `)
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchFile() did not report the invalid configuration")
+	}
+	if err := verify(tests[0].token); err != nil {
+		t.Errorf("verify() after invalid reload error = %v", err)
+	}
+
+	writeConfig(`{"serviceAccounts": ["` + accountB + `"], "kmsKeyPaths": ["` + keyPath1 + `", "` + keyPath2 + `"], "audiences": ["aud"]}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(verifier.Config().KMSKeyPaths) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("WatchFile() did not reload the configuration")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, tt := range tests {
+		if err := verify(tt.token); (err == nil) != tt.wantReload {
+			t.Errorf("%s: verify() after reload error = %v, want valid %v", tt.name, err, tt.wantReload)
+		}
+	}
+	if err := verify(tests[2].token); !errors.Is(err.(*jwt.ValidationError).Inner, ErrInvalidAudience) {
+		t.Errorf("verify() error = %v, want %v", err, ErrInvalidAudience)
+	}
+}
+
+func TestVerifier_NoConfig(t *testing.T) {
+	if _, err := (&Verifier{}).Keyfunc()(jwt.New(SigningMethodKMSES256)); err != ErrNoVerifierConfig {
+		t.Errorf("Keyfunc() error = %v, want %v", err, ErrNoVerifierConfig)
+	}
+	if _, err := LoadVerifierConfigFile(filepath.Join(os.TempDir(), "missing-verifier-config.json")); err == nil {
+		t.Errorf("LoadVerifierConfigFile() expected error")
+	}
+}
+
+func TestVerifier_WatchFileRetry(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeKMS(t)
+	const keyPath = "projects/p/locations/l/keyRings/r/cryptoKeys/watch/cryptoKeyVersions/1"
+
+	path := filepath.Join(t.TempDir(), "verifier.json")
+	if err := ioutil.WriteFile(path, []byte(`{"kmsKeyPaths": ["`+keyPath+`"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := &Verifier{KMSClient: client}
+	if err := verifier.Update(ctx, &VerifierConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	errs := make(chan error, 100)
+	go func() {
+		defer close(done)
+		verifier.WatchFile(watchCtx, path, 10*time.Millisecond, func(err error) {
+			errs <- err
+		})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The key version is missing so the first Update fails
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchFile() did not report the failed update")
+	}
+	if len(verifier.Config().KMSKeyPaths) != 0 {
+		t.Fatal("WatchFile() applied a configuration whose update failed")
+	}
+
+	// Retried without the file changing
+	fake.addKey(t, keyPath, &kmspb.CryptoKeyVersion{})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(verifier.Config().KMSKeyPaths) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("WatchFile() did not retry the configuration")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}